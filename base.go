@@ -16,8 +16,9 @@ package servicebase
 
 import (
 	"context"
-	"io/fs"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/pprof"
 	"os"
@@ -28,9 +29,15 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/sebest/xff"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
 
 	"github.com/vs49688/servicebase/internal/middleware/combinedlog"
+	"github.com/vs49688/servicebase/internal/middleware/recovery"
 	"github.com/vs49688/servicebase/internal/middleware/requestid"
+	"github.com/vs49688/servicebase/internal/middleware/tracing"
+	"github.com/vs49688/servicebase/internal/systemd"
+	"github.com/vs49688/servicebase/internal/transcode"
 	"github.com/vs49688/servicebase/multilistener"
 )
 
@@ -43,24 +50,155 @@ func closeService(ctx context.Context, impl Service, timeout time.Duration, logg
 	}
 }
 
+// handlePanic records a panic recovered from a handler, returning true if it
+// should crash the process.
+func (sw *serviceBase) handlePanic(ctx context.Context, protocol string, value any, stack []byte) bool {
+	sw.metrics.RecordPanic(protocol)
+	sw.logger.ErrorContext(ctx, "handler panicked",
+		slog.String("protocol", protocol),
+		slog.Any("panic", value),
+		slog.String("stack", string(stack)),
+	)
+
+	return sw.crashOnPanic.Load()
+}
+
+// newHandlerChain wraps a router with the default handler chain.
+func (sw *serviceBase) newHandlerChain(cfg *ServiceConfig, router *mux.Router) (http.Handler, error) {
+	// Create the default handler chain, in reverse order
+	// 1. XFF handling
+	// 2. Request ID
+	// 3. Tracing
+	// 4. Logging
+	// 5. Metrics
+	// 6. Panic recovery
+	router.Use(recordRoute)
+	recovered := recovery.NewHandler(router, sw.handlePanic)
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sw.metrics.RecordHTTPRequest(req)
+		recovered.ServeHTTP(w, req)
+	})
+	handler = sw.metrics.instrumentHTTP(handler)
+
+	handler = func(h http.Handler) http.Handler {
+		logged := combinedlog.NewHandler(h, sw.logger)
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if sw.accessLog.Load() {
+				logged.ServeHTTP(w, req)
+			} else {
+				h.ServeHTTP(w, req)
+			}
+		})
+	}(handler)
+
+	if sw.tracing.enabled() {
+		handler = sw.tracing.instrumentHTTP(handler)
+	}
+
+	if !cfg.DisableRequestID {
+		ids, err := cfg.RequestID.options()
+		if err != nil {
+			return nil, err
+		}
+
+		handler = requestid.NewHandler(handler, ids)
+	}
+
+	if !cfg.HTTP.DisableXFF {
+		xfff, err := xff.New(xff.Options{AllowedSubnets: nil, Debug: false})
+		if err != nil {
+			sw.logger.Error("xff creation failed", slog.Any("error", err))
+			return nil, err
+		}
+
+		handler = xfff.Handler(handler)
+
+		// UNIX sockets have "@" as a RemoteAddr, and xfff can't handle it.
+		handler = func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.RemoteAddr == "@" {
+					req.RemoteAddr = "127.0.0.1:0"
+				}
+
+				h.ServeHTTP(w, req)
+			})
+		}(handler)
+	}
+
+	return handler, nil
+}
+
+func (sw *serviceBase) newHTTPServer(cfg *HTTPConfig, info ListenerInfo) *http.Server {
+	handler := sw.httpHandler
+	if info.Protocol == "admin" {
+		handler = sw.adminHandler
+	}
+
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		BaseContext: func(net.Listener) context.Context {
+			return withListenerInfo(context.Background(), info)
+		},
+	}
+
+	if sw.draining.Load() {
+		srv.SetKeepAlivesEnabled(false)
+	}
+
+	return srv
+}
+
+// RunService runs the service until ctx is cancelled or it's signalled to stop. It has
+// no config sources, so SIGHUP only reloads the TLS certificates. Use
+// RunServiceWithOptions() with RunOptions.ConfigSources to reload the configuration.
 func RunService(ctx context.Context, cfg ServiceConfig, factory ServiceFactory) error {
-	sw := &serviceBase{}
+	return RunServiceWithOptions(ctx, cfg, factory, RunOptions{})
+}
 
-	var logHandler slog.Handler
-	hopts := slog.HandlerOptions{Level: cfg.LogLevel}
-	if cfg.LogFormat == LogFormatJSON {
-		logHandler = slog.NewJSONHandler(os.Stdout, &hopts)
-	} else {
-		logHandler = slog.NewTextHandler(os.Stdout, &hopts)
+// RunServiceWithOptions is RunService, with control over how the service is hosted.
+func RunServiceWithOptions(ctx context.Context, cfg ServiceConfig, factory ServiceFactory, opts RunOptions) error {
+	sw := &serviceBase{
+		baseCfg:       cfg,
+		configSources: opts.ConfigSources,
+	}
+
+	// Loaded as upon reload, so an invalid configuration is rejected now.
+	cfg, err := sw.loadConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	sw.cfg = cfg
+
+	sw.stopCtx, sw.stop = context.WithCancel(context.Background())
+	defer sw.stop()
+
+	sw.logLevel.Set(cfg.LogLevel)
+
+	logHandler := opts.LogHandler
+	if lf, ok := logHandler.(LogFlusher); ok {
+		sw.logFlusher = lf
+	}
+
+	if logHandler == nil {
+		sw.logSwap = newSwapHandler(newFormatHandler(os.Stdout, cfg.LogFormat, &sw.logLevel))
+		logHandler = sw.logSwap
 	}
 
 	if !cfg.DisableRequestID {
 		logHandler = requestid.NewLogHandler(requestid.DefaultLoggerFieldName, logHandler)
 	}
 
+	if cfg.Tracing.Enabled {
+		logHandler = tracing.NewLogHandler(logHandler)
+	}
+
+	logHandler = &listenerLogHandler{Handler: logHandler}
+
 	sw.logger = slog.New(logHandler)
 
 	sw.multiListener = multilistener.New(sw.logger)
+	sw.multiListener.SetShutdownConfig(sw.listenerShutdownConfig(&cfg))
 	defer func() {
 		if err := sw.multiListener.Close(); err != nil {
 			sw.logger.Error("error closing listeners", slog.Any("error", err))
@@ -73,52 +211,48 @@ func RunService(ctx context.Context, cfg ServiceConfig, factory ServiceFactory)
 	sw.serviceRouter.MethodNotAllowedHandler = http.HandlerFunc(MethodNotAllowedHandler)
 
 	// Register /metrics
-	metrics, metricsHandler, err := configureMetrics(sw.logger)
+	metrics, metricsHandler, err := configureMetrics(&cfg, sw.logger)
 	if err != nil {
 		return err
 	}
 
 	sw.metrics = metrics
+	sw.healthHistory = newHealthHistory(sw.logger, &sw.metrics)
 
-	// Create the default handler chain, in reverse order
-	// 1. XFF handling
-	// 2. Logging
-	// 3. Metrics
-	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		sw.metrics.RecordHTTPRequest(req)
-		sw.serviceRouter.ServeHTTP(w, req)
-	})
+	sw.tracing, err = newTracing(ctx, &cfg.Tracing)
+	if err != nil {
+		sw.logger.Error("error creating tracer", slog.Any("error", err))
+		return err
+	}
 
-	handler = combinedlog.NewHandler(handler, sw.logger)
+	// Normally flushed during shutdown, this covers failing to start.
+	defer func() {
+		ctx, cancel := withTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		_ = sw.tracing.shutdown(ctx)
+	}()
 
-	if !cfg.DisableRequestID {
-		handler = requestid.NewHandler(handler)
+	sw.accessLog.Store(!cfg.HTTP.DisableAccessLog)
+	sw.crashOnPanic.Store(cfg.CrashOnPanic)
+	sw.healthStatusCodes.Store(&cfg.HTTP.HealthStatusCodes)
+
+	sw.httpHandler, err = sw.newHandlerChain(&cfg, sw.serviceRouter)
+	if err != nil {
+		return err
 	}
 
-	if !cfg.HTTP.DisableXFF {
-		xfff, err := xff.New(xff.Options{AllowedSubnets: nil, Debug: false})
+	// Create the admin router, if enabled. Otherwise, the built-in endpoints
+	// are served alongside the application.
+	sw.adminRouter = sw.serviceRouter
+	if cfg.Admin.Enabled {
+		sw.adminRouter = mux.NewRouter()
+		sw.adminRouter.NotFoundHandler = http.HandlerFunc(NotFoundHandler)
+		sw.adminRouter.MethodNotAllowedHandler = http.HandlerFunc(MethodNotAllowedHandler)
+
+		sw.adminHandler, err = sw.newHandlerChain(&cfg, sw.adminRouter)
 		if err != nil {
-			sw.logger.Error("xff creation failed", slog.Any("error", err))
 			return err
 		}
-
-		handler = xfff.Handler(handler)
-
-		// UNIX sockets have "@" as a RemoteAddr, and xfff can't handle it.
-		handler = func(h http.Handler) http.Handler {
-			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-				if req.RemoteAddr == "@" {
-					req.RemoteAddr = "127.0.0.1:0"
-				}
-
-				h.ServeHTTP(w, req)
-			})
-		}(handler)
-	}
-
-	sw.httpServer = &http.Server{
-		Handler:           handler,
-		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
 	}
 
 	// Create the application-level router
@@ -135,25 +269,29 @@ func RunService(ctx context.Context, cfg ServiceConfig, factory ServiceFactory)
 		sw.applicationRouter.MethodNotAllowedHandler = http.HandlerFunc(MethodNotAllowedHandler)
 	}
 
-	// Register the /health and /metrics endponts. This must be done before the
-	// service factory is called, so they can't override them. checkHealth() requires
-	// a Service instance, so reserve just the route.
+	// Register the /metrics endpoint. This must be done before the
+	// service factory is called, so they can't override it.
 	if !cfg.HTTP.DisableMetrics {
-		sw.serviceRouter.Handle("/metrics", metricsHandler).Methods(http.MethodGet)
+		sw.adminRouter.Handle("/metrics", metricsHandler).Methods(http.MethodGet)
 	}
 
-	var healthRouter *mux.Route
+	// Register the health endpoints before the service factory is called, so
+	// they can't be overridden. They won't be called before the service is created.
 	if !cfg.HTTP.DisableHealth {
-		healthRouter = sw.serviceRouter.Path("/health").Methods(http.MethodGet)
+		sw.adminRouter.Path("/health").HandlerFunc(sw.checkHealth(sw.getHealth)).Methods(http.MethodGet)
+		sw.adminRouter.Path("/health/live").HandlerFunc(sw.checkHealth(sw.getLiveness)).Methods(http.MethodGet)
+		sw.adminRouter.Path("/health/ready").HandlerFunc(sw.checkHealth(sw.getReadiness)).Methods(http.MethodGet)
+		sw.adminRouter.Path("/health/startup").HandlerFunc(sw.checkHealth(sw.getStartup)).Methods(http.MethodGet)
 	}
 
 	if cfg.HTTP.EnableDebug {
-		debugRouter := sw.serviceRouter.PathPrefix("/debug").Subrouter()
+		debugRouter := sw.adminRouter.PathPrefix("/debug").Subrouter()
 		debugRouter.Path("/pprof/cmdline").HandlerFunc(pprof.Cmdline).Methods(http.MethodGet)
 		debugRouter.Path("/pprof/profile").HandlerFunc(pprof.Profile).Methods(http.MethodGet)
 		debugRouter.Path("/pprof/symbol").HandlerFunc(pprof.Symbol).Methods(http.MethodGet)
 		debugRouter.Path("/pprof/trace").HandlerFunc(pprof.Trace).Methods(http.MethodGet)
 		debugRouter.PathPrefix("/pprof/").HandlerFunc(pprof.Index).Methods(http.MethodGet)
+		debugRouter.Path("/health/history").Handler(sw.healthHistory).Methods(http.MethodGet)
 		debugRouter.Path("/pprof").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 			w.Header().Set("Location", "/debug/pprof/")
 			w.WriteHeader(http.StatusPermanentRedirect)
@@ -161,73 +299,306 @@ func RunService(ctx context.Context, cfg ServiceConfig, factory ServiceFactory)
 	}
 
 	// Create the GRPC server
-	sw.grpcServer, err = createGRPCServer(&cfg, sw.metrics.Registry)
+	sw.grpcServer, sw.grpcBridgeServer, err = createGRPCServer(&cfg, &sw.metrics, sw.tracing, &sw.grpcListeners, sw.handlePanic)
 	if err != nil {
 		sw.logger.Error("error creating grpc server", slog.Any("error", err))
 		return err
 	}
 
+	var grpcRegistrar grpc.ServiceRegistrar = sw.grpcServer
+	if sw.grpcBridgeServer != nil {
+		grpcRegistrar = grpcRegistrars{sw.grpcServer, sw.grpcBridgeServer}
+	}
+
+	if cfg.GRPC.EnableWeb {
+		webPath := path.Clean(cfg.GRPC.WebPath)
+		sw.serviceRouter.PathPrefix(webPath + "/").Handler(
+			http.StripPrefix(webPath, newGRPCWebHandler(sw.grpcBridgeServer, &cfg, sw.tracing)),
+		)
+	}
+
+	sw.grpcHealth = newGRPCHealth(sw.getHealth, &sw.draining)
+
+	// Only requests for registered methods are matched, so the service may still
+	// register its own routes around them.
+	var transcoder *transcode.Transcoder
+	if cfg.GRPC.EnableTranscoding {
+		transcoder = newTranscoder(sw.grpcBridgeServer, &cfg, sw.tracing, pathPrefix)
+		sw.applicationRouter.MatcherFunc(func(r *http.Request, _ *mux.RouteMatch) bool {
+			return transcoder.Match(r)
+		}).Handler(withRoute(transcoder, transcoder.Route))
+	}
+
+	workers := newWorkerGroup(sw.multiListener, &cfg.Workers, cfg.ShutdownTimeout)
+	sw.healthChecks = newHealthChecks(workers, sw.healthHistory.observe)
+	startMetricsPush(&cfg.MetricsPush, sw.metrics.Registry, workers, sw.logger)
+
 	// Finally, create the service itself
 	svc, err := factory(ctx, ServiceParameters{
 		Logger:            sw.logger,
 		Metrics:           sw.metrics,
 		ServiceRouter:     sw.serviceRouter,
 		ApplicationRouter: sw.applicationRouter,
-		GRPCRegistrar:     sw.grpcServer,
+		GRPCRegistrar:     grpcRegistrar,
+		GRPCHealth:        sw.grpcHealth,
+		Workers:           workers,
+		HealthChecks:      sw.healthChecks,
+		Tracing:           sw.tracing,
 	})
 	if err != nil {
 		return err
 	}
 
 	sw.svc = svc
-	defer closeService(ctx, svc, cfg.ShutdownTimeout, sw.logger)
 
-	if healthRouter != nil {
-		healthRouter.HandlerFunc(checkHealth(svc, sw.logger))
+	if _, ok := sw.grpcServer.GetServiceInfo()[grpc_health_v1.Health_ServiceDesc.ServiceName]; !ok {
+		grpc_health_v1.RegisterHealthServer(grpcRegistrar, sw.grpcHealth)
+	}
+
+	if transcoder != nil {
+		if err := transcoder.Register(sw.grpcServer.GetServiceInfo()); err != nil {
+			sw.logger.Warn("unable to transcode some grpc methods", slog.Any("error", err))
+		}
+	}
+
+	// Once serving, the service is closed as part of shutdown.
+	serving := false
+	defer func() {
+		if !serving {
+			closeService(ctx, svc, cfg.ShutdownTimeout, sw.logger)
+		}
+	}()
+
+	listen := opts.Listen
+	if listen == nil {
+		listen = multilistener.Listen
+	}
+
+	if cfg.DevTLS {
+		if err := applyDevTLS(&cfg, sw.logger); err != nil {
+			return err
+		}
 	}
 
+	var httpAddrs, grpcAddrs, adminAddrs []net.Addr
+
 	// Finally, handle enables.
-	// We still create the actual server objects because initialisation code may rely on it.
+	// We still create the GRPC server object because initialisation code may rely on it.
 	if cfg.HTTP.Enabled {
-		err = sw.multiListener.ListenHTTP(&multilistener.ListenConfig{
-			BindAddress:       cfg.HTTP.BindAddress,
-			BindNetwork:       cfg.HTTP.BindNetwork,
-			SocketPermissions: fs.FileMode(cfg.HTTP.SocketPermissions),
-		}, sw.httpServer)
+		lcfgs, err := cfg.HTTP.listenConfigs("http")
+		if err != nil {
+			return err
+		}
+
+		tlsConfig, err := sw.newTLSConfig(&cfg.HTTP.TLS, httpNextProtos)
+		if err != nil {
+			return err
+		}
+
+		for i := range lcfgs {
+			lis, err := listen(&lcfgs[i], sw.logger)
+			if err != nil {
+				return err
+			}
+
+			info := ListenerInfo{Protocol: "http", Index: i, Address: lis.Addr().String()}
+			srv := sw.newHTTPServer(&cfg.HTTP, info)
+			sw.httpListeners = append(sw.httpListeners, info)
+			sw.httpServers = append(sw.httpServers, srv)
+			httpAddrs = append(httpAddrs, lis.Addr())
+
+			if !cfg.HTTP.ServeGRPC {
+				sw.multiListener.AddHTTPTLS(lcfgs[i].Name, lis, srv, tlsConfig)
+				continue
+			}
+
+			if err := sw.multiListener.AddMux(lcfgs[i].Name, lis, srv, sw.grpcServer, tlsConfig); err != nil {
+				_ = lis.Close()
+				return err
+			}
+
+			sw.grpcListeners.add(lis.Addr(), info)
+			grpcAddrs = append(grpcAddrs, lis.Addr())
+		}
+	}
+
+	if cfg.Admin.Enabled {
+		lcfgs, err := cfg.Admin.listenConfigs("admin")
+		if err != nil {
+			return err
+		}
+
+		tlsConfig, err := sw.newTLSConfig(&cfg.Admin.TLS, httpNextProtos)
 		if err != nil {
 			return err
 		}
+
+		for i := range lcfgs {
+			lis, err := listen(&lcfgs[i], sw.logger)
+			if err != nil {
+				return err
+			}
+
+			info := ListenerInfo{Protocol: "admin", Index: i, Address: lis.Addr().String()}
+			srv := sw.newHTTPServer(&cfg.HTTP, info)
+			sw.httpListeners = append(sw.httpListeners, info)
+			sw.httpServers = append(sw.httpServers, srv)
+			sw.multiListener.AddHTTPTLS(lcfgs[i].Name, lis, srv, tlsConfig)
+			adminAddrs = append(adminAddrs, lis.Addr())
+		}
 	}
 
 	if cfg.GRPC.Enabled {
-		err = sw.multiListener.ListenGRPC(&multilistener.ListenConfig{
-			BindAddress:       cfg.GRPC.BindAddress,
-			BindNetwork:       cfg.GRPC.BindNetwork,
-			SocketPermissions: fs.FileMode(cfg.GRPC.SocketPermissions),
-		}, sw.grpcServer)
+		lcfgs, err := cfg.GRPC.listenConfigs("grpc")
 		if err != nil {
 			return err
 		}
+
+		tlsConfig, err := sw.newTLSConfig(&cfg.GRPC.TLS, grpcNextProtos)
+		if err != nil {
+			return err
+		}
+
+		for i := range lcfgs {
+			lis, err := listen(&lcfgs[i], sw.logger)
+			if err != nil {
+				return err
+			}
+
+			sw.multiListener.AddGRPCTLS(lcfgs[i].Name, lis, sw.grpcServer, tlsConfig)
+			sw.grpcListeners.add(lis.Addr(), ListenerInfo{Protocol: "grpc", Index: i, Address: lis.Addr().String()})
+			grpcAddrs = append(grpcAddrs, lis.Addr())
+		}
+	}
+
+	if len(sw.certReloaders) > 0 {
+		sw.multiListener.AddWorker("tls-reload", sw.watchCertificates(tlsPollInterval), multilistener.WorkerConfig{})
+	}
+
+	if interval, err := systemd.WatchdogInterval(); err != nil {
+		sw.logger.Warn("ignoring systemd watchdog", slog.Any("error", err))
+	} else if interval > 0 {
+		sw.multiListener.AddWorker("systemd-watchdog", sw.watchdog(interval), multilistener.WorkerConfig{})
 	}
 
+	// Validate() has rejected unsupported signals.
+	upgradeSignal := upgradeSignals[cfg.UpgradeSignal]
+
 	sigChan := make(chan os.Signal, 10)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	if !opts.DisableSignals {
+		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+		if upgradeSignal != nil {
+			signal.Notify(sigChan, upgradeSignal)
+		}
+		defer signal.Stop(sigChan)
+	}
 
 	runCtx, cancelRun := context.WithCancel(ctx)
 	defer cancelRun()
 
+	serving = true
 	doneChan := make(chan error, 1)
 	go func() {
-		doneChan <- sw.multiListener.Serve(runCtx)
+		err := sw.multiListener.Serve(runCtx)
+		sw.finishShutdown(&cfg)
+		doneChan <- err
 	}()
 
+	sw.started.Store(true)
+	sw.notify(systemd.StateReady, systemd.Status("serving"))
+	sw.notifyParent()
+
+	if opts.OnReady != nil {
+		opts.OnReady(ReadyInfo{
+			Service:    svc,
+			Metrics:    sw.metrics,
+			HTTPAddrs:  httpAddrs,
+			GRPCAddrs:  grpcAddrs,
+			AdminAddrs: adminAddrs,
+		})
+	}
+
+	var drainChan <-chan time.Time
+	var upgradeChan <-chan upgradeResult
+	stopping := false
+
 	for {
 		select {
 		case sig := <-sigChan:
 			sw.logger.Info("caught signal", slog.String("sig", sig.String()))
+
+			if sig == syscall.SIGHUP {
+				sw.notify(systemd.Status("reloading"))
+				err := sw.reload(ctx)
+				sw.notify(systemd.Status("serving"))
+
+				if err != nil {
+					sw.logger.Error("reload failed, keeping current configuration", slog.Any("error", err))
+				} else {
+					sw.logger.Info("reload complete")
+				}
+				continue
+			}
+
+			if upgradeSignal != nil && sig == upgradeSignal {
+				if upgradeChan != nil {
+					sw.logger.Warn("upgrade already in progress")
+					continue
+				}
+
+				if upgradeChan, err = sw.startUpgrade(cfg.UpgradeTimeout); err != nil {
+					sw.logger.Error("unable to start upgrade", slog.Any("error", err))
+				}
+				continue
+			}
+
+			// Once draining or stopping, another signal abandons the graceful shutdown.
+			if stopping || sw.draining.Load() {
+				sw.forceStop()
+				if !stopping {
+					stopping = true
+					cancelRun()
+				}
+				continue
+			}
+
+			// On the first SIGTERM, drain before stopping. Anything else stops immediately.
+			if sig == syscall.SIGTERM && cfg.DrainDelay > 0 {
+				sw.logger.Info("draining", slog.Duration("delay", cfg.DrainDelay))
+				sw.notify(systemd.StateStopping, systemd.Status("draining"))
+				sw.beginDrain()
+				drainChan = time.After(cfg.DrainDelay)
+				continue
+			}
+
+			sw.notify(systemd.StateStopping, systemd.Status("stopping"))
+			sw.beginDrain()
+			stopping = true
+			cancelRun()
+
+		case res := <-upgradeChan:
+			upgradeChan = nil
+			if res.err != nil {
+				sw.logger.Error("upgrade failed", slog.Any("error", res.err))
+				continue
+			}
+
+			// The child now owns the sockets, hand over and stop.
+			sw.logger.Info("upgrade complete, stopping", slog.Int("pid", res.pid))
+			sw.multiListener.SetUnlinkOnClose(false)
+			sw.notify(systemd.MainPID(res.pid), systemd.Status("upgraded"))
+			sw.beginDrain()
+			stopping = true
+			cancelRun()
+
+		case <-drainChan:
+			sw.logger.Info("drain complete")
+			stopping = true
 			cancelRun()
 
 		case err := <-doneChan:
+			sw.notify(systemd.StateStopping, systemd.Status("stopped"))
+
 			if err != nil {
 				sw.logger.Error("server termination error", slog.Any("error", err))
 			}
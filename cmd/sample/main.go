@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/urfave/cli/v2"
 
@@ -28,12 +29,32 @@ func (d *sampleService) GetHealth(_ context.Context) (*servicebase.GetHealthResp
 	}, nil
 }
 
+func (d *sampleService) Reload(_ context.Context, cfg servicebase.ServiceConfig) error {
+	// Edit the --config file, then kill -HUP <pid>
+	d.logger.Info("reloading", slog.String("log_level", cfg.LogLevel.String()))
+	return nil
+}
+
 func (d *sampleService) AmIATeapot(ctx context.Context, _ *pb.AmIATeapotRequest) (*pb.AmIATeapotResponse, error) {
 	// grpcurl -plaintext localhost:50051 sample.Teapot.AmIATeapot
 	d.logger.InfoContext(ctx, "im a teapot")
 	return &pb.AmIATeapotResponse{Answer: true}, nil
 }
 
+func (d *sampleService) heartbeat(ctx context.Context) error {
+	t := time.NewTicker(30 * time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+			d.logger.DebugContext(ctx, "still brewing")
+		}
+	}
+}
+
 func (d *sampleService) httpTeapot(w http.ResponseWriter, _ *http.Request) {
 	w.WriteHeader(http.StatusTeapot)
 	_, _ = w.Write([]byte("im a teapot"))
@@ -46,6 +67,7 @@ func makeService(_ *servicebase.ServiceConfig) servicebase.ServiceFactory {
 		}
 		params.ApplicationRouter.HandleFunc("/teapot", svc.httpTeapot)
 		pb.RegisterTeapotServer(params.GRPCRegistrar, svc)
+		params.Workers.Go("heartbeat", svc.heartbeat)
 		return svc, nil
 	}
 }
@@ -54,14 +76,25 @@ func main() {
 	cfg := servicebase.DefaultServiceConfig()
 	cfg.GRPC.EnableReflection = true
 
+	var configFile string
+
 	app := &cli.App{
-		Name:                   "servicebase-sample",
-		Usage:                  os.Args[0],
-		Description:            "Sample Application for servicebase",
-		Flags:                  cfg.Flags(),
+		Name:        "servicebase-sample",
+		Usage:       os.Args[0],
+		Description: "Sample Application for servicebase",
+		Flags: append(cfg.Flags(), &cli.StringFlag{
+			Name:        "config",
+			Usage:       "json configuration file, merged over the flags and re-read on SIGHUP",
+			Destination: &configFile,
+		}),
 		UseShortOptionHandling: true,
 		Action: func(context *cli.Context) error {
-			return servicebase.RunService(context.Context, cfg, makeService(&cfg))
+			var opts servicebase.RunOptions
+			if configFile != "" {
+				opts.ConfigSources = []servicebase.ConfigSource{servicebase.JSONConfigFile(configFile)}
+			}
+
+			return servicebase.RunServiceWithOptions(context.Context, cfg, makeService(&cfg), opts)
 		},
 	}
 
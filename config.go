@@ -15,18 +15,49 @@
 package servicebase
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/urfave/cli/v2"
+	"github.com/vs49688/servicebase/internal/middleware/requestid"
 	"google.golang.org/grpc"
 	"log/slog"
+	"math"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// TLSConfig configures TLS termination for a listener. TLS is enabled if a
+// certificate is given. The files are reloaded when changed, or on SIGHUP.
+type TLSConfig struct {
+	CertFile     string `json:"cert_file,omitempty"`
+	KeyFile      string `json:"key_file,omitempty"`
+	ClientCAFile string `json:"client_ca_file,omitempty"`
+
+	// ClientAuth is one of "none", "request", "require", "verify_if_given"
+	// or "require_and_verify". See tls.ClientAuthType.
+	ClientAuth string `json:"client_auth,omitempty"`
+
+	// MinVersion is one of "1.0", "1.1", "1.2" or "1.3". Defaults to "1.2".
+	MinVersion string `json:"min_version,omitempty"`
+
+	// CipherSuites are the names of the allowed cipher suites, see tls.CipherSuites().
+	// Only applies to TLS 1.2 and below.
+	CipherSuites []string `json:"cipher_suites,omitempty"`
+}
+
 type ListenConfig struct {
-	Enabled           bool     `json:"enabled"`
-	BindAddress       string   `json:"bind_address,omitempty"`
-	BindNetwork       string   `json:"bind_network,omitempty"`
-	SocketPermissions FileMode `json:"socket_permissions,omitempty"`
+	Enabled           bool      `json:"enabled"`
+	BindAddress       string    `json:"bind_address,omitempty"`
+	BindNetwork       string    `json:"bind_network,omitempty"`
+	SocketPermissions FileMode  `json:"socket_permissions,omitempty"`
+	TLS               TLSConfig `json:"tls"`
+
+	// AdditionalBinds are extra addresses to listen on, see ParseBind().
+	AdditionalBinds []string `json:"additional_binds,omitempty"`
 
 	hasEnabled bool
 }
@@ -36,14 +67,29 @@ type HTTPConfig struct {
 	PathPrefix        string        `json:"path_prefix,omitempty"`
 	DisableXFF        bool          `json:"disable_xff,omitempty"`
 	DisableMetrics    bool          `json:"disable_metrics"`
-	DisableHealth     bool          `json:"disable_health"`
+	DisableHealth     bool          `json:"disable_health"` // Disables all /health endpoints
 	EnableDebug       bool          `json:"enable_debug"`
+	DisableAccessLog  bool          `json:"disable_access_log"`
 	ReadHeaderTimeout time.Duration `json:"read_header_timeout"`
 
-	hasDisableXFF     bool
-	hasDisableMetrics bool
-	hasDisableHealth  bool
-	hasEnableDebug    bool
+	// ServeGRPC also serves GRPC on the HTTP listeners, routing each connection by protocol.
+	ServeGRPC bool `json:"serve_grpc"`
+
+	// HealthStatusCodes overrides the HTTP status code of the health endpoints for each
+	// status. By default, only unhealthy is 503.
+	HealthStatusCodes map[HealthStatus]int `json:"health_status_codes,omitempty"`
+
+	// LegacyRequestMetrics keeps the root_requests counter, labelled by path, remote IP,
+	// host, method and user agent. Its cardinality is unbounded.
+	LegacyRequestMetrics bool `json:"legacy_request_metrics"`
+
+	hasDisableXFF       bool
+	hasDisableMetrics   bool
+	hasDisableHealth    bool
+	hasEnableDebug      bool
+	hasDisableAccessLog bool
+	hasServeGRPC        bool
+	hasLegacyMetrics    bool
 }
 
 type GRPCConfig struct {
@@ -52,20 +98,228 @@ type GRPCConfig struct {
 	EnableReflection bool                `json:"enable_reflection"`
 	Options          []grpc.ServerOption `json:"-"` // TODO: Make this configurable from JSON/command line
 
-	hasDisableMetrics   bool
-	hasEnableReflection bool
+	// EnableWeb serves the GRPC services over GRPC-Web on the HTTP server, under WebPath.
+	// Cross-origin requests are allowed from WebAllowedOrigins, "*" allowing any.
+	EnableWeb         bool     `json:"enable_web"`
+	WebPath           string   `json:"web_path,omitempty"`
+	WebAllowedOrigins []string `json:"web_allowed_origins,omitempty"`
+
+	// EnableTranscoding serves the unary GRPC methods as REST/JSON on the application
+	// router, at POST {TranscodingPrefix}/{package.Service}/{Method} and at any
+	// google.api.http bindings.
+	EnableTranscoding bool   `json:"enable_transcoding"`
+	TranscodingPrefix string `json:"transcoding_prefix,omitempty"`
+
+	hasDisableMetrics    bool
+	hasEnableReflection  bool
+	hasEnableWeb         bool
+	hasEnableTranscoding bool
+}
+
+// AdminConfig configures the admin listener. If enabled, the metrics, health and debug
+// endpoints are served on it, rather than alongside the application.
+type AdminConfig struct {
+	ListenConfig
+}
+
+type WorkerConfig struct {
+	RestartOnFailure bool          `json:"restart_on_failure"`
+	MinBackoff       time.Duration `json:"min_backoff"`
+	MaxBackoff       time.Duration `json:"max_backoff"`
+
+	hasRestartOnFailure bool
+}
+
+// TracingConfig configures OpenTelemetry tracing of HTTP and GRPC requests.
+type TracingConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// Exporter is "otlp-http" or "otlp-grpc". Endpoint is a URL, or host:port, defaulting
+	// to that of the OTEL_EXPORTER_OTLP_* environment variables.
+	Exporter string            `json:"exporter,omitempty"`
+	Endpoint string            `json:"endpoint,omitempty"`
+	Insecure bool              `json:"insecure"`
+	Headers  map[string]string `json:"headers,omitempty"`
+
+	ServiceName string `json:"service_name,omitempty"` // The program name if empty
+
+	// SampleRatio is the fraction of new traces sampled. Requests continuing a trace
+	// follow the caller's decision.
+	SampleRatio float64 `json:"sample_ratio"`
+
+	// Propagators are the trace context formats accepted and sent, of tracecontext
+	// (W3C traceparent/tracestate), baggage, b3 (single header) and b3multi.
+	Propagators []string `json:"propagators,omitempty"`
+
+	hasEnabled     bool
+	hasInsecure    bool
+	hasSampleRatio bool
+}
+
+// RequestIDConfig configures how requests are identified, unless DisableRequestID is set.
+type RequestIDConfig struct {
+	// Header and MetadataKey are the HTTP header and GRPC metadata key the ID is read
+	// from and returned in. Aliases are also read from, in both.
+	Header      string   `json:"header,omitempty"`
+	MetadataKey string   `json:"metadata_key,omitempty"`
+	Aliases     []string `json:"aliases,omitempty"`
+
+	// Generator is "uuidv4", "uuidv7" or "ulid".
+	Generator string `json:"generator,omitempty"`
+
+	// MaxLength is the longest ID accepted from a client. Longer IDs, or those with
+	// characters other than letters, digits and "-_.:+/=", are replaced.
+	//
+	// Unset fields are taken from DefaultRequestIDConfig().
+	MaxLength int `json:"max_length"`
+}
+
+// MetricsConfig configures the metrics served on /metrics, and pushed.
+type MetricsConfig struct {
+	// Namespace prefixes the metrics of servicebase, and those created with the Metrics
+	// helpers. The Go and process collectors are left as-is.
+	Namespace string `json:"namespace,omitempty"`
+
+	// ConstLabels are added to every metric, such as service, env and instance.
+	ConstLabels map[string]string `json:"const_labels,omitempty"`
+
+	DisableGoCollector        bool `json:"disable_go_collector"`
+	DisableProcessCollector   bool `json:"disable_process_collector"`
+	DisableBuildInfoCollector bool `json:"disable_build_info_collector"`
+
+	// GoRuntimeMetrics adds runtime/metrics to the Go collector, by rule set
+	// (all/gc/memory/scheduler/debug) or a regexp of their names.
+	GoRuntimeMetrics []string `json:"go_runtime_metrics,omitempty"`
+
+	Histograms HistogramConfig `json:"histograms"`
+
+	hasDisableGoCollector        bool
+	hasDisableProcessCollector   bool
+	hasDisableBuildInfoCollector bool
+}
+
+// HistogramConfig is the default for latency histograms, such as HTTP and GRPC handling
+// times, and those created with the Metrics helpers.
+type HistogramConfig struct {
+	// Buckets are the classic buckets, in seconds. If empty, prometheus.DefBuckets are
+	// used, unless native histograms are enabled.
+	Buckets []float64 `json:"buckets,omitempty"`
+
+	// NativeBucketFactor enables native histograms if above 1, with each bucket at most
+	// this factor wider than the last. 1.1 is typical.
+	NativeBucketFactor     float64       `json:"native_bucket_factor"`
+	NativeMaxBuckets       uint32        `json:"native_max_buckets"`        // Unbounded if zero
+	NativeMinResetDuration time.Duration `json:"native_min_reset_duration"` // Before resetting upon NativeMaxBuckets
+}
+
+// PushConfig pushes the metrics to an endpoint, for services that can't be scraped, such
+// as batch jobs. Pushing is disabled without a URL.
+type PushConfig struct {
+	URL      string            `json:"url,omitempty"`
+	Job      string            `json:"job,omitempty"`      // The program name if empty
+	Grouping map[string]string `json:"grouping,omitempty"` // Labels distinguishing this instance
+	Interval time.Duration     `json:"interval"`
+	Timeout  time.Duration     `json:"timeout"` // Of each attempt
+	Retries  int               `json:"retries"`
+
+	hasRetries bool
+}
+
+type MetricsPushConfig struct {
+	Pushgateway PushConfig `json:"pushgateway"`
+	RemoteWrite PushConfig `json:"remote_write"`
+}
+
+// ShutdownConfig bounds each phase of shutdown. Every phase is also bounded by
+// ServiceConfig.ShutdownTimeout, which covers shutdown as a whole. Zero leaves a
+// phase bounded only by the overall timeout.
+type ShutdownConfig struct {
+	HTTPTimeout    time.Duration `json:"http_timeout"`
+	GRPCTimeout    time.Duration `json:"grpc_timeout"`
+	WorkerTimeout  time.Duration `json:"worker_timeout"`
+	ServiceTimeout time.Duration `json:"service_timeout"`
+	LogTimeout     time.Duration `json:"log_timeout"`
 }
 
 type ServiceConfig struct {
-	LogLevel         slog.Level    `json:"log_level,omitempty"`
-	LogFormat        string        `json:"log_format,omitempty"`
-	ShutdownTimeout  time.Duration `json:"shutdown_timeout"`
-	HTTP             HTTPConfig    `json:"http"`
-	GRPC             GRPCConfig    `json:"grpc"`
-	DisableRequestID bool          `json:"disable_request_id"`
+	LogLevel         slog.Level        `json:"log_level,omitempty"`
+	LogFormat        string            `json:"log_format,omitempty"`
+	ShutdownTimeout  time.Duration     `json:"shutdown_timeout"`
+	Shutdown         ShutdownConfig    `json:"shutdown"`
+	DrainDelay       time.Duration     `json:"drain_delay"`
+	UpgradeSignal    string            `json:"upgrade_signal,omitempty"`
+	UpgradeTimeout   time.Duration     `json:"upgrade_timeout"`
+	HTTP             HTTPConfig        `json:"http"`
+	GRPC             GRPCConfig        `json:"grpc"`
+	Admin            AdminConfig       `json:"admin"`
+	Workers          WorkerConfig      `json:"workers"`
+	Metrics          MetricsConfig     `json:"metrics"`
+	Tracing          TracingConfig     `json:"tracing"`
+	MetricsPush      MetricsPushConfig `json:"metrics_push"`
+	RequestID        RequestIDConfig   `json:"request_id"`
+	DisableRequestID bool              `json:"disable_request_id"`
+	CrashOnPanic     bool              `json:"crash_on_panic"`
+
+	// DevTLS serves every listener over TLS, with a certificate signed by a
+	// development CA kept in DevTLSDir. Not for production use.
+	DevTLS    bool   `json:"dev_tls"`
+	DevTLSDir string `json:"dev_tls_dir,omitempty"`
 
 	logLevel            string
 	hasDisableRequestID bool
+	hasCrashOnPanic     bool
+	hasDevTLS           bool
+}
+
+// Enabled reports whether TLS is enabled.
+func (cfg *TLSConfig) Enabled() bool {
+	return cfg.CertFile != ""
+}
+
+// flags returns the TLS flags for a listener, e.g. "http-tls-cert-file".
+func (cfg *TLSConfig) flags(name string) []cli.Flag {
+	env := strings.ToUpper(name)
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        name + "-tls-cert-file",
+			Usage:       name + " tls certificate file (enables tls)",
+			EnvVars:     []string{env + "_TLS_CERT_FILE"},
+			Destination: &cfg.CertFile,
+		},
+		&cli.StringFlag{
+			Name:        name + "-tls-key-file",
+			Usage:       name + " tls private key file",
+			EnvVars:     []string{env + "_TLS_KEY_FILE"},
+			Destination: &cfg.KeyFile,
+		},
+		&cli.StringFlag{
+			Name:        name + "-tls-client-ca-file",
+			Usage:       name + " tls client ca bundle, for verifying client certificates",
+			EnvVars:     []string{env + "_TLS_CLIENT_CA_FILE"},
+			Destination: &cfg.ClientCAFile,
+		},
+		&cli.StringFlag{
+			Name:        name + "-tls-client-auth",
+			Usage:       name + " tls client auth (none/request/require/verify_if_given/require_and_verify)",
+			EnvVars:     []string{env + "_TLS_CLIENT_AUTH"},
+			Destination: &cfg.ClientAuth,
+		},
+		&cli.StringFlag{
+			Name:        name + "-tls-min-version",
+			Usage:       name + " minimum tls version (1.0/1.1/1.2/1.3)",
+			EnvVars:     []string{env + "_TLS_MIN_VERSION"},
+			Destination: &cfg.MinVersion,
+		},
+		&cli.StringSliceFlag{
+			Name:    name + "-tls-cipher-suite",
+			Usage:   name + " allowed tls cipher suite (may be repeated)",
+			EnvVars: []string{env + "_TLS_CIPHER_SUITES"},
+			Action: func(context *cli.Context, suites []string) error {
+				cfg.CipherSuites = suites
+				return nil
+			},
+		},
+	}
 }
 
 func DefaultHTTPConfig() HTTPConfig {
@@ -86,7 +340,7 @@ func DefaultHTTPConfig() HTTPConfig {
 
 func (cfg *HTTPConfig) Flags() []cli.Flag {
 	def := DefaultHTTPConfig()
-	return []cli.Flag{
+	flags := []cli.Flag{
 		&cli.BoolFlag{
 			Name:    "http-enabled",
 			Usage:   "http server enabled",
@@ -120,6 +374,15 @@ func (cfg *HTTPConfig) Flags() []cli.Flag {
 			Required:    false,
 			Value:       def.PathPrefix,
 		},
+		&cli.StringSliceFlag{
+			Name:    "http-additional-bind",
+			Usage:   "additional http bind address, as network://address (may be repeated)",
+			EnvVars: []string{"HTTP_ADDITIONAL_BINDS"},
+			Action: func(context *cli.Context, binds []string) error {
+				cfg.AdditionalBinds = binds
+				return nil
+			},
+		},
 		&cli.StringFlag{
 			Name:    "http-unix-socket-permissions",
 			Usage:   "http unix socket permissions (only if socket)",
@@ -154,7 +417,7 @@ func (cfg *HTTPConfig) Flags() []cli.Flag {
 		},
 		&cli.BoolFlag{
 			Name:    "http-disable-health",
-			Usage:   "disable /health endpoint",
+			Usage:   "disable /health endpoints",
 			EnvVars: []string{"HTTP_DISABLE_HEALTH"},
 			Value:   def.DisableHealth,
 			Action: func(context *cli.Context, b bool) error {
@@ -163,6 +426,28 @@ func (cfg *HTTPConfig) Flags() []cli.Flag {
 				return nil
 			},
 		},
+		&cli.StringSliceFlag{
+			Name:    "http-health-status-code",
+			Usage:   "http status code of the health endpoints for a status, as status=code (may be repeated)",
+			EnvVars: []string{"HTTP_HEALTH_STATUS_CODES"},
+			Action: func(context *cli.Context, mappings []string) error {
+				cfg.HealthStatusCodes = map[HealthStatus]int{}
+				for _, m := range mappings {
+					status, code, ok := strings.Cut(m, "=")
+					if !ok {
+						return fmt.Errorf("invalid health status code: %q", m)
+					}
+
+					c, err := strconv.Atoi(code)
+					if err != nil {
+						return fmt.Errorf("invalid health status code: %q", m)
+					}
+
+					cfg.HealthStatusCodes[HealthStatus(status)] = c
+				}
+				return nil
+			},
+		},
 		&cli.BoolFlag{
 			Name:    "http-enable-debug",
 			Usage:   "enable /debug endpoints",
@@ -174,6 +459,17 @@ func (cfg *HTTPConfig) Flags() []cli.Flag {
 				return nil
 			},
 		},
+		&cli.BoolFlag{
+			Name:    "http-disable-access-log",
+			Usage:   "disable http access logging",
+			EnvVars: []string{"HTTP_DISABLE_ACCESS_LOG"},
+			Value:   def.DisableAccessLog,
+			Action: func(context *cli.Context, b bool) error {
+				cfg.DisableAccessLog = b
+				cfg.hasDisableAccessLog = true
+				return nil
+			},
+		},
 		&cli.DurationFlag{
 			Name:        "http-read-header-timeout",
 			Usage:       "http read header timeout",
@@ -181,7 +477,31 @@ func (cfg *HTTPConfig) Flags() []cli.Flag {
 			Destination: &cfg.ReadHeaderTimeout,
 			Value:       def.ReadHeaderTimeout,
 		},
+		&cli.BoolFlag{
+			Name:    "http-serve-grpc",
+			Usage:   "also serve grpc on the http listeners",
+			EnvVars: []string{"HTTP_SERVE_GRPC"},
+			Value:   def.ServeGRPC,
+			Action: func(context *cli.Context, b bool) error {
+				cfg.ServeGRPC = b
+				cfg.hasServeGRPC = true
+				return nil
+			},
+		},
+		&cli.BoolFlag{
+			Name:    "http-legacy-request-metrics",
+			Usage:   "keep the legacy root_requests counter, whose cardinality is unbounded",
+			EnvVars: []string{"HTTP_LEGACY_REQUEST_METRICS"},
+			Value:   def.LegacyRequestMetrics,
+			Action: func(context *cli.Context, b bool) error {
+				cfg.LegacyRequestMetrics = b
+				cfg.hasLegacyMetrics = true
+				return nil
+			},
+		},
 	}
+
+	return append(flags, cfg.TLS.flags("http")...)
 }
 
 func DefaultGRPCConfig() GRPCConfig {
@@ -194,12 +514,17 @@ func DefaultGRPCConfig() GRPCConfig {
 		},
 		DisableMetrics:   false,
 		EnableReflection: false,
+		EnableWeb:        false,
+		WebPath:          "/grpc-web",
+
+		EnableTranscoding: false,
+		TranscodingPrefix: "/rpc",
 	}
 }
 
 func (cfg *GRPCConfig) Flags() []cli.Flag {
 	def := DefaultGRPCConfig()
-	return []cli.Flag{
+	flags := []cli.Flag{
 		&cli.BoolFlag{
 			Name:    "grpc-enabled",
 			Usage:   "grpc server enabled",
@@ -225,6 +550,15 @@ func (cfg *GRPCConfig) Flags() []cli.Flag {
 			Destination: &cfg.BindAddress,
 			Value:       def.BindAddress,
 		},
+		&cli.StringSliceFlag{
+			Name:    "grpc-additional-bind",
+			Usage:   "additional grpc bind address, as network://address (may be repeated)",
+			EnvVars: []string{"GRPC_ADDITIONAL_BINDS"},
+			Action: func(context *cli.Context, binds []string) error {
+				cfg.AdditionalBinds = binds
+				return nil
+			},
+		},
 		&cli.StringFlag{
 			Name:    "grpc-unix-socket-permissions",
 			Usage:   "grpc unix socket permissions (only if socket)",
@@ -245,131 +579,1196 @@ func (cfg *GRPCConfig) Flags() []cli.Flag {
 				return nil
 			},
 		},
+		&cli.BoolFlag{
+			Name:    "grpc-enable-web",
+			Usage:   "serve grpc-web on the http server",
+			EnvVars: []string{"GRPC_ENABLE_WEB"},
+			Value:   def.EnableWeb,
+			Action: func(context *cli.Context, b bool) error {
+				cfg.EnableWeb = b
+				cfg.hasEnableWeb = true
+				return nil
+			},
+		},
+		&cli.StringFlag{
+			Name:        "grpc-web-path",
+			Usage:       "http path to serve grpc-web under",
+			EnvVars:     []string{"GRPC_WEB_PATH"},
+			Destination: &cfg.WebPath,
+			Value:       def.WebPath,
+		},
+		&cli.StringSliceFlag{
+			Name:    "grpc-web-allowed-origin",
+			Usage:   "origin allowed to make cross-origin grpc-web requests, or * for any (may be repeated)",
+			EnvVars: []string{"GRPC_WEB_ALLOWED_ORIGINS"},
+			Action: func(context *cli.Context, origins []string) error {
+				cfg.WebAllowedOrigins = origins
+				return nil
+			},
+		},
+		&cli.BoolFlag{
+			Name:    "grpc-enable-transcoding",
+			Usage:   "serve unary grpc methods as rest/json on the http server",
+			EnvVars: []string{"GRPC_ENABLE_TRANSCODING"},
+			Value:   def.EnableTranscoding,
+			Action: func(context *cli.Context, b bool) error {
+				cfg.EnableTranscoding = b
+				cfg.hasEnableTranscoding = true
+				return nil
+			},
+		},
+		&cli.StringFlag{
+			Name:        "grpc-transcoding-prefix",
+			Usage:       "application path prefix to serve transcoded grpc methods under",
+			EnvVars:     []string{"GRPC_TRANSCODING_PREFIX"},
+			Destination: &cfg.TranscodingPrefix,
+			Value:       def.TranscodingPrefix,
+		},
 	}
 
+	return append(flags, cfg.TLS.flags("grpc")...)
 }
 
-func DefaultServiceConfig() ServiceConfig {
-	return ServiceConfig{
-		LogLevel:        slog.LevelInfo,
-		LogFormat:       "text",
-		ShutdownTimeout: 10 * time.Second,
-		HTTP:            DefaultHTTPConfig(),
-		GRPC:            DefaultGRPCConfig(),
+func DefaultAdminConfig() AdminConfig {
+	return AdminConfig{
+		ListenConfig: ListenConfig{
+			Enabled:           false,
+			BindNetwork:       "tcp",
+			BindAddress:       "127.0.0.1:9090",
+			SocketPermissions: 0600,
+		},
 	}
 }
 
-func (cfg *ServiceConfig) Flags() []cli.Flag {
-	def := DefaultServiceConfig()
-
+func (cfg *AdminConfig) Flags() []cli.Flag {
+	def := DefaultAdminConfig()
 	flags := []cli.Flag{
-		&cli.StringFlag{
-			Name:        "log-level",
-			Usage:       "logging level",
-			EnvVars:     []string{"SERVICE_LOG_LEVEL"},
-			Destination: &cfg.logLevel,
-			Value:       def.logLevel,
-			Action: func(context *cli.Context, s string) error {
-				cfg.logLevel = s
-				return cfg.LogLevel.UnmarshalText([]byte(s))
+		&cli.BoolFlag{
+			Name:    "admin-enabled",
+			Usage:   "serve the metrics, health and debug endpoints on a separate admin listener",
+			EnvVars: []string{"ADMIN_ENABLED"},
+			Value:   def.Enabled,
+			Action: func(context *cli.Context, b bool) error {
+				cfg.Enabled = b
+				cfg.hasEnabled = true
+				return nil
 			},
 		},
 		&cli.StringFlag{
-			Name:        "log-format",
-			Usage:       "logging format (text/json)",
-			EnvVars:     []string{"SERVICE_LOG_FORMAT"},
-			Destination: &cfg.LogFormat,
-			Value:       def.LogFormat,
+			Name:        "admin-bind-network",
+			Usage:       "admin bind network (see net.Listen())",
+			EnvVars:     []string{"ADMIN_BIND_NETWORK"},
+			Destination: &cfg.BindNetwork,
+			Value:       def.BindNetwork,
 		},
-		&cli.DurationFlag{
-			Name:        "shutdown-timeout",
-			Usage:       "shutdown timeout",
-			EnvVars:     []string{"SERVICE_SHUTDOWN_TIMEOUT"},
-			Destination: &cfg.ShutdownTimeout,
-			Value:       def.ShutdownTimeout,
+		&cli.StringFlag{
+			Name:        "admin-bind-address",
+			Usage:       "admin bind address (see net.Listen())",
+			EnvVars:     []string{"ADMIN_BIND_ADDRESS"},
+			Destination: &cfg.BindAddress,
+			Value:       def.BindAddress,
 		},
-	}
-
-	flags = append(flags, cfg.HTTP.Flags()...)
-	flags = append(flags, cfg.GRPC.Flags()...)
-	flags = append(flags, &cli.BoolFlag{
-		Name:    "disable-request-id",
-		Usage:   "disable request id handling (for both HTTP and GRPC)",
-		EnvVars: []string{"SERVICE_DISABLE_REQUEST_ID"},
-		Value:   def.DisableRequestID,
-		Action: func(context *cli.Context, b bool) error {
-			cfg.DisableRequestID = b
-			cfg.hasDisableRequestID = true
-			return nil
+		&cli.StringSliceFlag{
+			Name:    "admin-additional-bind",
+			Usage:   "additional admin bind address, as network://address (may be repeated)",
+			EnvVars: []string{"ADMIN_ADDITIONAL_BINDS"},
+			Action: func(context *cli.Context, binds []string) error {
+				cfg.AdditionalBinds = binds
+				return nil
+			},
+		},
+		&cli.StringFlag{
+			Name:    "admin-unix-socket-permissions",
+			Usage:   "admin unix socket permissions (only if socket)",
+			EnvVars: []string{"ADMIN_UNIX_SOCKET_PERMISSIONS"},
+			Value:   strconv.FormatInt(int64(def.SocketPermissions), 8),
+			Action: func(context *cli.Context, s string) error {
+				return cfg.SocketPermissions.UnmarshalText([]byte(s))
+			},
 		},
-	})
-	return flags
-}
-
-func MergeMap[T comparable, V any](left, right map[T]V) map[T]V {
-	if left == nil && right != nil {
-		left = map[T]V{}
-	}
-
-	for k, v := range right {
-		left[k] = v
 	}
 
-	return left
+	return append(flags, cfg.TLS.flags("admin")...)
 }
 
-func MergeString(left, right string) string {
-	if right != "" {
-		return right
+func DefaultWorkerConfig() WorkerConfig {
+	return WorkerConfig{
+		RestartOnFailure: false,
+		MinBackoff:       1 * time.Second,
+		MaxBackoff:       1 * time.Minute,
 	}
-
-	return left
 }
 
-func MergeServiceConfig(left, right *ServiceConfig) *ServiceConfig {
-	if right.logLevel != "" {
-		left.LogLevel = right.LogLevel
-	}
-
-	left.LogFormat = MergeString(left.LogFormat, right.LogFormat)
-
-	if right.ShutdownTimeout != 0 {
-		left.ShutdownTimeout = right.ShutdownTimeout
-	}
-
-	MergeHTTPConfig(&left.HTTP, &right.HTTP)
-	MergeGRPCConfig(&left.GRPC, &right.GRPC)
-
-	if right.hasDisableRequestID {
-		left.DisableRequestID = right.DisableRequestID
+func (cfg *WorkerConfig) Flags() []cli.Flag {
+	def := DefaultWorkerConfig()
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "worker-restart-on-failure",
+			Usage:   "restart failed background workers, instead of stopping the service",
+			EnvVars: []string{"WORKER_RESTART_ON_FAILURE"},
+			Value:   def.RestartOnFailure,
+			Action: func(context *cli.Context, b bool) error {
+				cfg.RestartOnFailure = b
+				cfg.hasRestartOnFailure = true
+				return nil
+			},
+		},
+		&cli.DurationFlag{
+			Name:        "worker-min-backoff",
+			Usage:       "minimum delay before restarting a failed worker",
+			EnvVars:     []string{"WORKER_MIN_BACKOFF"},
+			Destination: &cfg.MinBackoff,
+			Value:       def.MinBackoff,
+		},
+		&cli.DurationFlag{
+			Name:        "worker-max-backoff",
+			Usage:       "maximum delay before restarting a failed worker",
+			EnvVars:     []string{"WORKER_MAX_BACKOFF"},
+			Destination: &cfg.MaxBackoff,
+			Value:       def.MaxBackoff,
+		},
 	}
-
-	return left
 }
 
-func MergeListenConfig(left, right *ListenConfig) *ListenConfig {
-	left.BindNetwork = MergeString(left.BindNetwork, right.BindNetwork)
-	left.BindAddress = MergeString(left.BindAddress, right.BindAddress)
-	if right.SocketPermissions != 0 {
-		left.SocketPermissions = right.SocketPermissions
+func DefaultRequestIDConfig() RequestIDConfig {
+	return RequestIDConfig{
+		Header:      requestid.DefaultHeaderName,
+		MetadataKey: requestid.DefaultMetadataKey,
+		Aliases:     []string{"X-Correlation-ID", requestid.DefaultHeaderName},
+		Generator:   RequestIDGeneratorUUIDv4,
+		MaxLength:   requestid.DefaultMaxLength,
 	}
+}
 
-	if right.hasEnabled {
-		left.Enabled = right.Enabled
+func (cfg *RequestIDConfig) Flags() []cli.Flag {
+	def := DefaultRequestIDConfig()
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "request-id-header",
+			Usage:       "http header the request id is read from and returned in",
+			EnvVars:     []string{"SERVICE_REQUEST_ID_HEADER"},
+			Destination: &cfg.Header,
+			Value:       def.Header,
+		},
+		&cli.StringFlag{
+			Name:        "request-id-metadata-key",
+			Usage:       "grpc metadata key the request id is read from and returned in",
+			EnvVars:     []string{"SERVICE_REQUEST_ID_METADATA_KEY"},
+			Destination: &cfg.MetadataKey,
+			Value:       def.MetadataKey,
+		},
+		&cli.StringSliceFlag{
+			Name:    "request-id-alias",
+			Usage:   "other header or metadata key the request id is read from (may be repeated)",
+			EnvVars: []string{"SERVICE_REQUEST_ID_ALIASES"},
+			Value:   cli.NewStringSlice(def.Aliases...),
+			Action: func(context *cli.Context, aliases []string) error {
+				cfg.Aliases = aliases
+				return nil
+			},
+		},
+		&cli.StringFlag{
+			Name:        "request-id-generator",
+			Usage:       "format of generated request ids (uuidv4/uuidv7/ulid)",
+			EnvVars:     []string{"SERVICE_REQUEST_ID_GENERATOR"},
+			Destination: &cfg.Generator,
+			Value:       def.Generator,
+		},
+		&cli.IntFlag{
+			Name:        "request-id-max-length",
+			Usage:       "longest request id accepted from a client",
+			EnvVars:     []string{"SERVICE_REQUEST_ID_MAX_LENGTH"},
+			Destination: &cfg.MaxLength,
+			Value:       def.MaxLength,
+		},
 	}
-
-	return left
 }
 
-func MergeHTTPConfig(left, right *HTTPConfig) *HTTPConfig {
-	MergeListenConfig(&left.ListenConfig, &right.ListenConfig)
-	left.PathPrefix = MergeString(left.PathPrefix, right.PathPrefix)
-	if right.hasDisableXFF {
-		left.DisableXFF = right.DisableXFF
+func DefaultTracingConfig() TracingConfig {
+	return TracingConfig{
+		Enabled:     false,
+		Exporter:    TracingExporterOTLPHTTP,
+		SampleRatio: 1,
+		Propagators: []string{TracingPropagatorTraceContext, TracingPropagatorBaggage},
 	}
+}
 
-	if right.ReadHeaderTimeout != 0 {
-		left.ReadHeaderTimeout = right.ReadHeaderTimeout
+func (cfg *TracingConfig) Flags() []cli.Flag {
+	def := DefaultTracingConfig()
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "tracing-enabled",
+			Usage:   "enable opentelemetry tracing",
+			EnvVars: []string{"TRACING_ENABLED"},
+			Value:   def.Enabled,
+			Action: func(context *cli.Context, b bool) error {
+				cfg.Enabled = b
+				cfg.hasEnabled = true
+				return nil
+			},
+		},
+		&cli.StringFlag{
+			Name:        "tracing-exporter",
+			Usage:       "trace exporter (otlp-http/otlp-grpc)",
+			EnvVars:     []string{"TRACING_EXPORTER"},
+			Destination: &cfg.Exporter,
+			Value:       def.Exporter,
+		},
+		&cli.StringFlag{
+			Name:        "tracing-endpoint",
+			Usage:       "otlp endpoint url or host:port (defaults to OTEL_EXPORTER_OTLP_ENDPOINT)",
+			EnvVars:     []string{"TRACING_ENDPOINT"},
+			Destination: &cfg.Endpoint,
+			Value:       def.Endpoint,
+		},
+		&cli.BoolFlag{
+			Name:    "tracing-insecure",
+			Usage:   "export traces without tls",
+			EnvVars: []string{"TRACING_INSECURE"},
+			Value:   def.Insecure,
+			Action: func(context *cli.Context, b bool) error {
+				cfg.Insecure = b
+				cfg.hasInsecure = true
+				return nil
+			},
+		},
+		&cli.StringSliceFlag{
+			Name:    "tracing-header",
+			Usage:   "header sent to the otlp endpoint, as name=value (may be repeated)",
+			EnvVars: []string{"TRACING_HEADERS"},
+			Action: func(context *cli.Context, headers []string) error {
+				cfg.Headers = map[string]string{}
+				for _, h := range headers {
+					name, value, ok := strings.Cut(h, "=")
+					if !ok {
+						return fmt.Errorf("invalid tracing header: %q", h)
+					}
+
+					cfg.Headers[name] = value
+				}
+				return nil
+			},
+		},
+		&cli.StringFlag{
+			Name:        "tracing-service-name",
+			Usage:       "service name of the traces (defaults to the program name)",
+			EnvVars:     []string{"TRACING_SERVICE_NAME"},
+			Destination: &cfg.ServiceName,
+			Value:       def.ServiceName,
+		},
+		&cli.Float64Flag{
+			Name:    "tracing-sample-ratio",
+			Usage:   "fraction of new traces to sample",
+			EnvVars: []string{"TRACING_SAMPLE_RATIO"},
+			Value:   def.SampleRatio,
+			Action: func(context *cli.Context, f float64) error {
+				cfg.SampleRatio = f
+				cfg.hasSampleRatio = true
+				return nil
+			},
+		},
+		&cli.StringSliceFlag{
+			Name:    "tracing-propagator",
+			Usage:   "trace context format (tracecontext/baggage/b3/b3multi, may be repeated)",
+			EnvVars: []string{"TRACING_PROPAGATORS"},
+			Value:   cli.NewStringSlice(def.Propagators...),
+			Action: func(context *cli.Context, propagators []string) error {
+				cfg.Propagators = propagators
+				return nil
+			},
+		},
+	}
+}
+
+func DefaultMetricsConfig() MetricsConfig {
+	return MetricsConfig{}
+}
+
+func (cfg *MetricsConfig) Flags() []cli.Flag {
+	def := DefaultMetricsConfig()
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "metrics-namespace",
+			Usage:       "prefix of the service's metrics",
+			EnvVars:     []string{"METRICS_NAMESPACE"},
+			Destination: &cfg.Namespace,
+			Value:       def.Namespace,
+		},
+		&cli.StringSliceFlag{
+			Name:    "metrics-const-label",
+			Usage:   "label added to every metric, as name=value (may be repeated)",
+			EnvVars: []string{"METRICS_CONST_LABELS"},
+			Action: func(context *cli.Context, labels []string) error {
+				cfg.ConstLabels = map[string]string{}
+				for _, l := range labels {
+					name, value, ok := strings.Cut(l, "=")
+					if !ok {
+						return fmt.Errorf("invalid const label: %q", l)
+					}
+
+					cfg.ConstLabels[name] = value
+				}
+				return nil
+			},
+		},
+		&cli.BoolFlag{
+			Name:    "metrics-disable-go-collector",
+			Usage:   "disable the go runtime metrics",
+			EnvVars: []string{"METRICS_DISABLE_GO_COLLECTOR"},
+			Value:   def.DisableGoCollector,
+			Action: func(context *cli.Context, b bool) error {
+				cfg.DisableGoCollector = b
+				cfg.hasDisableGoCollector = true
+				return nil
+			},
+		},
+		&cli.BoolFlag{
+			Name:    "metrics-disable-process-collector",
+			Usage:   "disable the process metrics",
+			EnvVars: []string{"METRICS_DISABLE_PROCESS_COLLECTOR"},
+			Value:   def.DisableProcessCollector,
+			Action: func(context *cli.Context, b bool) error {
+				cfg.DisableProcessCollector = b
+				cfg.hasDisableProcessCollector = true
+				return nil
+			},
+		},
+		&cli.BoolFlag{
+			Name:    "metrics-disable-build-info-collector",
+			Usage:   "disable the go_build_info metric",
+			EnvVars: []string{"METRICS_DISABLE_BUILD_INFO_COLLECTOR"},
+			Value:   def.DisableBuildInfoCollector,
+			Action: func(context *cli.Context, b bool) error {
+				cfg.DisableBuildInfoCollector = b
+				cfg.hasDisableBuildInfoCollector = true
+				return nil
+			},
+		},
+		&cli.StringSliceFlag{
+			Name:    "metrics-go-runtime-metrics",
+			Usage:   "runtime/metrics to collect, by rule set (all/gc/memory/scheduler/debug) or regexp (may be repeated)",
+			EnvVars: []string{"METRICS_GO_RUNTIME_METRICS"},
+			Action: func(context *cli.Context, rules []string) error {
+				cfg.GoRuntimeMetrics = rules
+				return nil
+			},
+		},
+		&cli.Float64SliceFlag{
+			Name:    "metrics-histogram-buckets",
+			Usage:   "buckets of latency histograms, in seconds",
+			EnvVars: []string{"METRICS_HISTOGRAM_BUCKETS"},
+			Action: func(context *cli.Context, buckets []float64) error {
+				cfg.Histograms.Buckets = buckets
+				return nil
+			},
+		},
+		&cli.Float64Flag{
+			Name:        "metrics-native-histogram-bucket-factor",
+			Usage:       "growth factor of native histogram buckets (enables native histograms if above 1)",
+			EnvVars:     []string{"METRICS_NATIVE_HISTOGRAM_BUCKET_FACTOR"},
+			Destination: &cfg.Histograms.NativeBucketFactor,
+			Value:       def.Histograms.NativeBucketFactor,
+		},
+		&cli.UintFlag{
+			Name:    "metrics-native-histogram-max-buckets",
+			Usage:   "maximum number of native histogram buckets",
+			EnvVars: []string{"METRICS_NATIVE_HISTOGRAM_MAX_BUCKETS"},
+			Value:   uint(def.Histograms.NativeMaxBuckets),
+			Action: func(context *cli.Context, n uint) error {
+				if n > math.MaxUint32 {
+					return fmt.Errorf("too many native histogram buckets: %d", n)
+				}
+
+				cfg.Histograms.NativeMaxBuckets = uint32(n)
+				return nil
+			},
+		},
+		&cli.DurationFlag{
+			Name:        "metrics-native-histogram-min-reset-duration",
+			Usage:       "minimum time between native histogram resets, upon reaching the maximum buckets",
+			EnvVars:     []string{"METRICS_NATIVE_HISTOGRAM_MIN_RESET_DURATION"},
+			Destination: &cfg.Histograms.NativeMinResetDuration,
+			Value:       def.Histograms.NativeMinResetDuration,
+		},
+	}
+}
+
+func DefaultPushConfig() PushConfig {
+	return PushConfig{
+		Interval: 15 * time.Second,
+		Timeout:  10 * time.Second,
+		Retries:  3,
+	}
+}
+
+func DefaultMetricsPushConfig() MetricsPushConfig {
+	return MetricsPushConfig{
+		Pushgateway: DefaultPushConfig(),
+		RemoteWrite: DefaultPushConfig(),
+	}
+}
+
+func (cfg *MetricsPushConfig) Flags() []cli.Flag {
+	flags := cfg.Pushgateway.flags("metrics-pushgateway", "prometheus pushgateway")
+	return append(flags, cfg.RemoteWrite.flags("metrics-remote-write", "prometheus remote-write")...)
+}
+
+// flags returns the flags for a push endpoint, e.g. "metrics-pushgateway-url".
+func (cfg *PushConfig) flags(name, desc string) []cli.Flag {
+	def := DefaultPushConfig()
+	env := strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        name + "-url",
+			Usage:       "push metrics to this " + desc + " url",
+			EnvVars:     []string{env + "_URL"},
+			Destination: &cfg.URL,
+		},
+		&cli.StringFlag{
+			Name:        name + "-job",
+			Usage:       desc + " job label (defaults to the program name)",
+			EnvVars:     []string{env + "_JOB"},
+			Destination: &cfg.Job,
+		},
+		&cli.StringSliceFlag{
+			Name:    name + "-grouping",
+			Usage:   desc + " grouping label, as name=value (may be repeated)",
+			EnvVars: []string{env + "_GROUPING"},
+			Action: func(context *cli.Context, labels []string) error {
+				cfg.Grouping = map[string]string{}
+				for _, l := range labels {
+					name, value, ok := strings.Cut(l, "=")
+					if !ok {
+						return fmt.Errorf("invalid grouping label: %q", l)
+					}
+
+					cfg.Grouping[name] = value
+				}
+				return nil
+			},
+		},
+		&cli.DurationFlag{
+			Name:        name + "-interval",
+			Usage:       "interval between pushes to the " + desc,
+			EnvVars:     []string{env + "_INTERVAL"},
+			Destination: &cfg.Interval,
+			Value:       def.Interval,
+		},
+		&cli.DurationFlag{
+			Name:        name + "-timeout",
+			Usage:       "timeout of each push to the " + desc,
+			EnvVars:     []string{env + "_TIMEOUT"},
+			Destination: &cfg.Timeout,
+			Value:       def.Timeout,
+		},
+		&cli.IntFlag{
+			Name:    name + "-retries",
+			Usage:   "retries of a failed push to the " + desc,
+			EnvVars: []string{env + "_RETRIES"},
+			Value:   def.Retries,
+			Action: func(context *cli.Context, n int) error {
+				cfg.Retries = n
+				cfg.hasRetries = true
+				return nil
+			},
+		},
+	}
+}
+
+func DefaultShutdownConfig() ShutdownConfig {
+	return ShutdownConfig{}
+}
+
+func (cfg *ShutdownConfig) Flags() []cli.Flag {
+	def := DefaultShutdownConfig()
+	return []cli.Flag{
+		&cli.DurationFlag{
+			Name:        "shutdown-http-timeout",
+			Usage:       "time to wait for http connections to drain during shutdown",
+			EnvVars:     []string{"SERVICE_SHUTDOWN_HTTP_TIMEOUT"},
+			Destination: &cfg.HTTPTimeout,
+			Value:       def.HTTPTimeout,
+		},
+		&cli.DurationFlag{
+			Name:        "shutdown-grpc-timeout",
+			Usage:       "time to wait for grpc streams to drain during shutdown",
+			EnvVars:     []string{"SERVICE_SHUTDOWN_GRPC_TIMEOUT"},
+			Destination: &cfg.GRPCTimeout,
+			Value:       def.GRPCTimeout,
+		},
+		&cli.DurationFlag{
+			Name:        "shutdown-worker-timeout",
+			Usage:       "time to wait for background workers to stop during shutdown",
+			EnvVars:     []string{"SERVICE_SHUTDOWN_WORKER_TIMEOUT"},
+			Destination: &cfg.WorkerTimeout,
+			Value:       def.WorkerTimeout,
+		},
+		&cli.DurationFlag{
+			Name:        "shutdown-service-timeout",
+			Usage:       "time to wait for the service to close during shutdown",
+			EnvVars:     []string{"SERVICE_SHUTDOWN_SERVICE_TIMEOUT"},
+			Destination: &cfg.ServiceTimeout,
+			Value:       def.ServiceTimeout,
+		},
+		&cli.DurationFlag{
+			Name:        "shutdown-log-timeout",
+			Usage:       "time to wait for logs to flush during shutdown",
+			EnvVars:     []string{"SERVICE_SHUTDOWN_LOG_TIMEOUT"},
+			Destination: &cfg.LogTimeout,
+			Value:       def.LogTimeout,
+		},
+	}
+}
+
+func DefaultServiceConfig() ServiceConfig {
+	return ServiceConfig{
+		LogLevel:        slog.LevelInfo,
+		LogFormat:       "text",
+		ShutdownTimeout: 10 * time.Second,
+		Shutdown:        DefaultShutdownConfig(),
+		UpgradeTimeout:  1 * time.Minute,
+		HTTP:            DefaultHTTPConfig(),
+		GRPC:            DefaultGRPCConfig(),
+		Admin:           DefaultAdminConfig(),
+		Workers:         DefaultWorkerConfig(),
+		Metrics:         DefaultMetricsConfig(),
+		Tracing:         DefaultTracingConfig(),
+		MetricsPush:     DefaultMetricsPushConfig(),
+		RequestID:       DefaultRequestIDConfig(),
+	}
+}
+
+func (cfg *ServiceConfig) Flags() []cli.Flag {
+	def := DefaultServiceConfig()
+
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:        "log-level",
+			Usage:       "logging level",
+			EnvVars:     []string{"SERVICE_LOG_LEVEL"},
+			Destination: &cfg.logLevel,
+			Value:       def.logLevel,
+			Action: func(context *cli.Context, s string) error {
+				cfg.logLevel = s
+				return cfg.LogLevel.UnmarshalText([]byte(s))
+			},
+		},
+		&cli.StringFlag{
+			Name:        "log-format",
+			Usage:       "logging format (text/json)",
+			EnvVars:     []string{"SERVICE_LOG_FORMAT"},
+			Destination: &cfg.LogFormat,
+			Value:       def.LogFormat,
+		},
+		&cli.DurationFlag{
+			Name:        "shutdown-timeout",
+			Usage:       "shutdown timeout",
+			EnvVars:     []string{"SERVICE_SHUTDOWN_TIMEOUT"},
+			Destination: &cfg.ShutdownTimeout,
+			Value:       def.ShutdownTimeout,
+		},
+		&cli.DurationFlag{
+			Name:        "drain-delay",
+			Usage:       "time to fail readiness checks before stopping on SIGTERM",
+			EnvVars:     []string{"SERVICE_DRAIN_DELAY"},
+			Destination: &cfg.DrainDelay,
+			Value:       def.DrainDelay,
+		},
+		&cli.StringFlag{
+			Name:        "upgrade-signal",
+			Usage:       "signal (SIGUSR1/SIGUSR2) that re-executes the binary, handing over the listeners",
+			EnvVars:     []string{"SERVICE_UPGRADE_SIGNAL"},
+			Destination: &cfg.UpgradeSignal,
+			Value:       def.UpgradeSignal,
+		},
+		&cli.DurationFlag{
+			Name:        "upgrade-timeout",
+			Usage:       "time to wait for the new process to become ready during an upgrade (0 waits forever)",
+			EnvVars:     []string{"SERVICE_UPGRADE_TIMEOUT"},
+			Destination: &cfg.UpgradeTimeout,
+			Value:       def.UpgradeTimeout,
+		},
+	}
+
+	flags = append(flags, cfg.Shutdown.Flags()...)
+	flags = append(flags, cfg.HTTP.Flags()...)
+	flags = append(flags, cfg.GRPC.Flags()...)
+	flags = append(flags, cfg.Admin.Flags()...)
+	flags = append(flags, cfg.Workers.Flags()...)
+	flags = append(flags, cfg.Metrics.Flags()...)
+	flags = append(flags, cfg.Tracing.Flags()...)
+	flags = append(flags, cfg.MetricsPush.Flags()...)
+	flags = append(flags, cfg.RequestID.Flags()...)
+	flags = append(flags, &cli.BoolFlag{
+		Name:    "disable-request-id",
+		Usage:   "disable request id handling (for both HTTP and GRPC)",
+		EnvVars: []string{"SERVICE_DISABLE_REQUEST_ID"},
+		Value:   def.DisableRequestID,
+		Action: func(context *cli.Context, b bool) error {
+			cfg.DisableRequestID = b
+			cfg.hasDisableRequestID = true
+			return nil
+		},
+	}, &cli.BoolFlag{
+		Name:    "crash-on-panic",
+		Usage:   "crash upon a panic in a handler, instead of recovering (for debugging)",
+		EnvVars: []string{"SERVICE_CRASH_ON_PANIC"},
+		Value:   def.CrashOnPanic,
+		Action: func(context *cli.Context, b bool) error {
+			cfg.CrashOnPanic = b
+			cfg.hasCrashOnPanic = true
+			return nil
+		},
+	}, &cli.BoolFlag{
+		Name:    "dev-tls",
+		Usage:   "serve over tls with generated development certificates (not for production)",
+		EnvVars: []string{"SERVICE_DEV_TLS"},
+		Value:   def.DevTLS,
+		Action: func(context *cli.Context, b bool) error {
+			cfg.DevTLS = b
+			cfg.hasDevTLS = true
+			return nil
+		},
+	}, &cli.StringFlag{
+		Name:        "dev-tls-dir",
+		Usage:       "directory to keep the development certificates in",
+		EnvVars:     []string{"SERVICE_DEV_TLS_DIR"},
+		Destination: &cfg.DevTLSDir,
+		Value:       def.DevTLSDir,
+	})
+	return flags
+}
+
+// Validate checks the configuration for errors.
+func (cfg *ServiceConfig) Validate() error {
+	switch cfg.LogFormat {
+	case "", LogFormatText, LogFormatJSON:
+	default:
+		return fmt.Errorf("invalid log format: %q", cfg.LogFormat)
+	}
+
+	if cfg.ShutdownTimeout < 0 {
+		return errors.New("shutdown timeout must not be negative")
+	}
+
+	if cfg.Shutdown.HTTPTimeout < 0 || cfg.Shutdown.GRPCTimeout < 0 || cfg.Shutdown.WorkerTimeout < 0 ||
+		cfg.Shutdown.ServiceTimeout < 0 || cfg.Shutdown.LogTimeout < 0 {
+		return errors.New("shutdown phase timeouts must not be negative")
+	}
+
+	if cfg.DrainDelay < 0 {
+		return errors.New("drain delay must not be negative")
+	}
+
+	if _, ok := upgradeSignals[cfg.UpgradeSignal]; cfg.UpgradeSignal != "" && !ok {
+		return fmt.Errorf("unsupported upgrade signal: %q", cfg.UpgradeSignal)
+	}
+
+	if cfg.UpgradeTimeout < 0 {
+		return errors.New("upgrade timeout must not be negative")
+	}
+
+	if cfg.Workers.MinBackoff < 0 || cfg.Workers.MaxBackoff < cfg.Workers.MinBackoff {
+		return errors.New("invalid worker backoff")
+	}
+
+	if cfg.HTTP.ReadHeaderTimeout < 0 {
+		return errors.New("http read header timeout must not be negative")
+	}
+
+	for status, code := range cfg.HTTP.HealthStatusCodes {
+		if _, ok := defaultHealthStatusCodes[status]; !ok {
+			return fmt.Errorf("invalid health status: %q", status)
+		}
+
+		if code < 100 || code > 599 {
+			return fmt.Errorf("invalid http status code for %s: %d", status, code)
+		}
+	}
+
+	if cfg.GRPC.EnableWeb && !strings.HasPrefix(cfg.GRPC.WebPath, "/") {
+		return fmt.Errorf("invalid grpc-web path: %q", cfg.GRPC.WebPath)
+	}
+
+	if cfg.GRPC.EnableTranscoding && !strings.HasPrefix(cfg.GRPC.TranscodingPrefix, "/") {
+		return fmt.Errorf("invalid grpc transcoding prefix: %q", cfg.GRPC.TranscodingPrefix)
+	}
+
+	if !cfg.DisableRequestID {
+		if err := cfg.RequestID.validate(); err != nil {
+			return fmt.Errorf("request id: %w", err)
+		}
+	}
+
+	if err := cfg.Tracing.validate(); err != nil {
+		return fmt.Errorf("tracing: %w", err)
+	}
+
+	if err := cfg.Metrics.validate(); err != nil {
+		return fmt.Errorf("metrics: %w", err)
+	}
+
+	for name, pushCfg := range map[string]*PushConfig{"pushgateway": &cfg.MetricsPush.Pushgateway, "remote write": &cfg.MetricsPush.RemoteWrite} {
+		if err := pushCfg.validate(); err != nil {
+			return fmt.Errorf("metrics %s: %w", name, err)
+		}
+	}
+
+	for name, tlsCfg := range map[string]*TLSConfig{"http": &cfg.HTTP.TLS, "grpc": &cfg.GRPC.TLS, "admin": &cfg.Admin.TLS} {
+		if err := tlsCfg.validate(); err != nil {
+			return fmt.Errorf("%s tls: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// jsonKeys returns the set of keys present in a JSON object.
+func jsonKeys(data []byte) (map[string]json.RawMessage, error) {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func hasKey(keys map[string]json.RawMessage, key string) bool {
+	_, ok := keys[key]
+	return ok
+}
+
+// UnmarshalJSON decodes the configuration, noting which fields were specified
+// so it may be merged with MergeServiceConfig().
+func (cfg *ServiceConfig) UnmarshalJSON(data []byte) error {
+	type plain ServiceConfig
+	if err := json.Unmarshal(data, (*plain)(cfg)); err != nil {
+		return err
+	}
+
+	keys, err := jsonKeys(data)
+	if err != nil {
+		return err
+	}
+
+	if hasKey(keys, "log_level") {
+		cfg.logLevel = cfg.LogLevel.String()
+	}
+
+	cfg.hasDisableRequestID = cfg.hasDisableRequestID || hasKey(keys, "disable_request_id")
+	cfg.hasCrashOnPanic = cfg.hasCrashOnPanic || hasKey(keys, "crash_on_panic")
+	cfg.hasDevTLS = cfg.hasDevTLS || hasKey(keys, "dev_tls")
+	return nil
+}
+
+func (cfg *HTTPConfig) UnmarshalJSON(data []byte) error {
+	type plain HTTPConfig
+	if err := json.Unmarshal(data, (*plain)(cfg)); err != nil {
+		return err
+	}
+
+	keys, err := jsonKeys(data)
+	if err != nil {
+		return err
+	}
+
+	cfg.hasEnabled = cfg.hasEnabled || hasKey(keys, "enabled")
+	cfg.hasDisableXFF = cfg.hasDisableXFF || hasKey(keys, "disable_xff")
+	cfg.hasDisableMetrics = cfg.hasDisableMetrics || hasKey(keys, "disable_metrics")
+	cfg.hasDisableHealth = cfg.hasDisableHealth || hasKey(keys, "disable_health")
+	cfg.hasEnableDebug = cfg.hasEnableDebug || hasKey(keys, "enable_debug")
+	cfg.hasDisableAccessLog = cfg.hasDisableAccessLog || hasKey(keys, "disable_access_log")
+	cfg.hasServeGRPC = cfg.hasServeGRPC || hasKey(keys, "serve_grpc")
+	cfg.hasLegacyMetrics = cfg.hasLegacyMetrics || hasKey(keys, "legacy_request_metrics")
+	return nil
+}
+
+func (cfg *AdminConfig) UnmarshalJSON(data []byte) error {
+	type plain AdminConfig
+	if err := json.Unmarshal(data, (*plain)(cfg)); err != nil {
+		return err
+	}
+
+	keys, err := jsonKeys(data)
+	if err != nil {
+		return err
+	}
+
+	cfg.hasEnabled = cfg.hasEnabled || hasKey(keys, "enabled")
+	return nil
+}
+
+func (cfg *WorkerConfig) UnmarshalJSON(data []byte) error {
+	type plain WorkerConfig
+	if err := json.Unmarshal(data, (*plain)(cfg)); err != nil {
+		return err
+	}
+
+	keys, err := jsonKeys(data)
+	if err != nil {
+		return err
+	}
+
+	cfg.hasRestartOnFailure = cfg.hasRestartOnFailure || hasKey(keys, "restart_on_failure")
+	return nil
+}
+
+func (cfg *TracingConfig) UnmarshalJSON(data []byte) error {
+	type plain TracingConfig
+	if err := json.Unmarshal(data, (*plain)(cfg)); err != nil {
+		return err
+	}
+
+	keys, err := jsonKeys(data)
+	if err != nil {
+		return err
+	}
+
+	cfg.hasEnabled = cfg.hasEnabled || hasKey(keys, "enabled")
+	cfg.hasInsecure = cfg.hasInsecure || hasKey(keys, "insecure")
+	cfg.hasSampleRatio = cfg.hasSampleRatio || hasKey(keys, "sample_ratio")
+	return nil
+}
+
+func (cfg *MetricsConfig) UnmarshalJSON(data []byte) error {
+	type plain MetricsConfig
+	if err := json.Unmarshal(data, (*plain)(cfg)); err != nil {
+		return err
+	}
+
+	keys, err := jsonKeys(data)
+	if err != nil {
+		return err
+	}
+
+	cfg.hasDisableGoCollector = cfg.hasDisableGoCollector || hasKey(keys, "disable_go_collector")
+	cfg.hasDisableProcessCollector = cfg.hasDisableProcessCollector || hasKey(keys, "disable_process_collector")
+	cfg.hasDisableBuildInfoCollector = cfg.hasDisableBuildInfoCollector || hasKey(keys, "disable_build_info_collector")
+	return nil
+}
+
+func (cfg *PushConfig) UnmarshalJSON(data []byte) error {
+	type plain PushConfig
+	if err := json.Unmarshal(data, (*plain)(cfg)); err != nil {
+		return err
+	}
+
+	keys, err := jsonKeys(data)
+	if err != nil {
+		return err
+	}
+
+	cfg.hasRetries = cfg.hasRetries || hasKey(keys, "retries")
+	return nil
+}
+
+func (cfg *GRPCConfig) UnmarshalJSON(data []byte) error {
+	type plain GRPCConfig
+	if err := json.Unmarshal(data, (*plain)(cfg)); err != nil {
+		return err
+	}
+
+	keys, err := jsonKeys(data)
+	if err != nil {
+		return err
+	}
+
+	cfg.hasEnabled = cfg.hasEnabled || hasKey(keys, "enabled")
+	cfg.hasDisableMetrics = cfg.hasDisableMetrics || hasKey(keys, "disable_metrics")
+	cfg.hasEnableReflection = cfg.hasEnableReflection || hasKey(keys, "enable_reflection")
+	cfg.hasEnableWeb = cfg.hasEnableWeb || hasKey(keys, "enable_web")
+	cfg.hasEnableTranscoding = cfg.hasEnableTranscoding || hasKey(keys, "enable_transcoding")
+	return nil
+}
+
+// JSONConfigFile is a ConfigSource that reads a JSON configuration file.
+func JSONConfigFile(path string) ConfigSource {
+	return func(_ context.Context) (*ServiceConfig, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg := &ServiceConfig{}
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		return cfg, nil
+	}
+}
+
+func MergeMap[T comparable, V any](left, right map[T]V) map[T]V {
+	if left == nil && right != nil {
+		left = map[T]V{}
+	}
+
+	for k, v := range right {
+		left[k] = v
+	}
+
+	return left
+}
+
+func MergeString(left, right string) string {
+	if right != "" {
+		return right
+	}
+
+	return left
+}
+
+func MergeServiceConfig(left, right *ServiceConfig) *ServiceConfig {
+	if right.logLevel != "" {
+		left.LogLevel = right.LogLevel
+	}
+
+	left.LogFormat = MergeString(left.LogFormat, right.LogFormat)
+
+	if right.ShutdownTimeout != 0 {
+		left.ShutdownTimeout = right.ShutdownTimeout
+	}
+
+	MergeShutdownConfig(&left.Shutdown, &right.Shutdown)
+
+	if right.DrainDelay != 0 {
+		left.DrainDelay = right.DrainDelay
+	}
+
+	left.UpgradeSignal = MergeString(left.UpgradeSignal, right.UpgradeSignal)
+
+	if right.UpgradeTimeout != 0 {
+		left.UpgradeTimeout = right.UpgradeTimeout
+	}
+
+	MergeHTTPConfig(&left.HTTP, &right.HTTP)
+	MergeGRPCConfig(&left.GRPC, &right.GRPC)
+	MergeAdminConfig(&left.Admin, &right.Admin)
+	MergeWorkerConfig(&left.Workers, &right.Workers)
+	MergeMetricsConfig(&left.Metrics, &right.Metrics)
+	MergeTracingConfig(&left.Tracing, &right.Tracing)
+	MergeMetricsPushConfig(&left.MetricsPush, &right.MetricsPush)
+	MergeRequestIDConfig(&left.RequestID, &right.RequestID)
+
+	if right.hasDisableRequestID {
+		left.DisableRequestID = right.DisableRequestID
+	}
+
+	if right.hasCrashOnPanic {
+		left.CrashOnPanic = right.CrashOnPanic
+	}
+
+	if right.hasDevTLS {
+		left.DevTLS = right.DevTLS
+	}
+
+	left.DevTLSDir = MergeString(left.DevTLSDir, right.DevTLSDir)
+
+	return left
+}
+
+func MergeRequestIDConfig(left, right *RequestIDConfig) *RequestIDConfig {
+	left.Header = MergeString(left.Header, right.Header)
+	left.MetadataKey = MergeString(left.MetadataKey, right.MetadataKey)
+
+	if len(right.Aliases) > 0 {
+		left.Aliases = right.Aliases
+	}
+
+	left.Generator = MergeString(left.Generator, right.Generator)
+
+	if right.MaxLength != 0 {
+		left.MaxLength = right.MaxLength
+	}
+
+	return left
+}
+
+func MergeTracingConfig(left, right *TracingConfig) *TracingConfig {
+	if right.hasEnabled {
+		left.Enabled = right.Enabled
+	}
+
+	left.Exporter = MergeString(left.Exporter, right.Exporter)
+	left.Endpoint = MergeString(left.Endpoint, right.Endpoint)
+
+	if right.hasInsecure {
+		left.Insecure = right.Insecure
+	}
+
+	if len(right.Headers) > 0 {
+		left.Headers = right.Headers
+	}
+
+	left.ServiceName = MergeString(left.ServiceName, right.ServiceName)
+
+	if right.hasSampleRatio {
+		left.SampleRatio = right.SampleRatio
+	}
+
+	if len(right.Propagators) > 0 {
+		left.Propagators = right.Propagators
+	}
+
+	return left
+}
+
+func MergeMetricsConfig(left, right *MetricsConfig) *MetricsConfig {
+	left.Namespace = MergeString(left.Namespace, right.Namespace)
+	if len(right.ConstLabels) > 0 {
+		left.ConstLabels = right.ConstLabels
+	}
+
+	if right.hasDisableGoCollector {
+		left.DisableGoCollector = right.DisableGoCollector
+	}
+
+	if right.hasDisableProcessCollector {
+		left.DisableProcessCollector = right.DisableProcessCollector
+	}
+
+	if right.hasDisableBuildInfoCollector {
+		left.DisableBuildInfoCollector = right.DisableBuildInfoCollector
+	}
+
+	if len(right.GoRuntimeMetrics) > 0 {
+		left.GoRuntimeMetrics = right.GoRuntimeMetrics
+	}
+
+	MergeHistogramConfig(&left.Histograms, &right.Histograms)
+	return left
+}
+
+func MergeHistogramConfig(left, right *HistogramConfig) *HistogramConfig {
+	if len(right.Buckets) > 0 {
+		left.Buckets = right.Buckets
+	}
+
+	if right.NativeBucketFactor != 0 {
+		left.NativeBucketFactor = right.NativeBucketFactor
+	}
+
+	if right.NativeMaxBuckets != 0 {
+		left.NativeMaxBuckets = right.NativeMaxBuckets
+	}
+
+	if right.NativeMinResetDuration != 0 {
+		left.NativeMinResetDuration = right.NativeMinResetDuration
+	}
+
+	return left
+}
+
+func MergeMetricsPushConfig(left, right *MetricsPushConfig) *MetricsPushConfig {
+	MergePushConfig(&left.Pushgateway, &right.Pushgateway)
+	MergePushConfig(&left.RemoteWrite, &right.RemoteWrite)
+	return left
+}
+
+func MergePushConfig(left, right *PushConfig) *PushConfig {
+	left.URL = MergeString(left.URL, right.URL)
+	left.Job = MergeString(left.Job, right.Job)
+	if len(right.Grouping) > 0 {
+		left.Grouping = right.Grouping
+	}
+
+	if right.Interval != 0 {
+		left.Interval = right.Interval
+	}
+
+	if right.Timeout != 0 {
+		left.Timeout = right.Timeout
+	}
+
+	if right.hasRetries {
+		left.Retries = right.Retries
+	}
+
+	return left
+}
+
+func MergeShutdownConfig(left, right *ShutdownConfig) *ShutdownConfig {
+	if right.HTTPTimeout != 0 {
+		left.HTTPTimeout = right.HTTPTimeout
+	}
+
+	if right.GRPCTimeout != 0 {
+		left.GRPCTimeout = right.GRPCTimeout
+	}
+
+	if right.WorkerTimeout != 0 {
+		left.WorkerTimeout = right.WorkerTimeout
+	}
+
+	if right.ServiceTimeout != 0 {
+		left.ServiceTimeout = right.ServiceTimeout
+	}
+
+	if right.LogTimeout != 0 {
+		left.LogTimeout = right.LogTimeout
+	}
+
+	return left
+}
+
+func MergeTLSConfig(left, right *TLSConfig) *TLSConfig {
+	left.CertFile = MergeString(left.CertFile, right.CertFile)
+	left.KeyFile = MergeString(left.KeyFile, right.KeyFile)
+	left.ClientCAFile = MergeString(left.ClientCAFile, right.ClientCAFile)
+	left.ClientAuth = MergeString(left.ClientAuth, right.ClientAuth)
+	left.MinVersion = MergeString(left.MinVersion, right.MinVersion)
+	if len(right.CipherSuites) > 0 {
+		left.CipherSuites = right.CipherSuites
+	}
+
+	return left
+}
+
+func MergeListenConfig(left, right *ListenConfig) *ListenConfig {
+	left.BindNetwork = MergeString(left.BindNetwork, right.BindNetwork)
+	left.BindAddress = MergeString(left.BindAddress, right.BindAddress)
+	if len(right.AdditionalBinds) > 0 {
+		left.AdditionalBinds = right.AdditionalBinds
+	}
+
+	if right.SocketPermissions != 0 {
+		left.SocketPermissions = right.SocketPermissions
+	}
+
+	MergeTLSConfig(&left.TLS, &right.TLS)
+
+	if right.hasEnabled {
+		left.Enabled = right.Enabled
+	}
+
+	return left
+}
+
+func MergeHTTPConfig(left, right *HTTPConfig) *HTTPConfig {
+	MergeListenConfig(&left.ListenConfig, &right.ListenConfig)
+	left.PathPrefix = MergeString(left.PathPrefix, right.PathPrefix)
+	if right.hasDisableXFF {
+		left.DisableXFF = right.DisableXFF
+	}
+
+	if right.hasDisableAccessLog {
+		left.DisableAccessLog = right.DisableAccessLog
+	}
+
+	if right.ReadHeaderTimeout != 0 {
+		left.ReadHeaderTimeout = right.ReadHeaderTimeout
+	}
+
+	if right.hasServeGRPC {
+		left.ServeGRPC = right.ServeGRPC
+	}
+
+	if right.hasLegacyMetrics {
+		left.LegacyRequestMetrics = right.LegacyRequestMetrics
+	}
+
+	if len(right.HealthStatusCodes) > 0 {
+		left.HealthStatusCodes = right.HealthStatusCodes
 	}
 
 	return left
@@ -386,5 +1785,41 @@ func MergeGRPCConfig(left, right *GRPCConfig) *GRPCConfig {
 		left.EnableReflection = right.EnableReflection
 	}
 
+	if right.hasEnableWeb {
+		left.EnableWeb = right.EnableWeb
+	}
+
+	left.WebPath = MergeString(left.WebPath, right.WebPath)
+	if len(right.WebAllowedOrigins) > 0 {
+		left.WebAllowedOrigins = right.WebAllowedOrigins
+	}
+
+	if right.hasEnableTranscoding {
+		left.EnableTranscoding = right.EnableTranscoding
+	}
+
+	left.TranscodingPrefix = MergeString(left.TranscodingPrefix, right.TranscodingPrefix)
+
+	return left
+}
+
+func MergeAdminConfig(left, right *AdminConfig) *AdminConfig {
+	MergeListenConfig(&left.ListenConfig, &right.ListenConfig)
+	return left
+}
+
+func MergeWorkerConfig(left, right *WorkerConfig) *WorkerConfig {
+	if right.hasRestartOnFailure {
+		left.RestartOnFailure = right.RestartOnFailure
+	}
+
+	if right.MinBackoff != 0 {
+		left.MinBackoff = right.MinBackoff
+	}
+
+	if right.MaxBackoff != 0 {
+		left.MaxBackoff = right.MaxBackoff
+	}
+
 	return left
 }
@@ -0,0 +1,249 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	devCAValidity   = 365 * 24 * time.Hour
+	devCertValidity = 30 * 24 * time.Hour
+)
+
+// DefaultDevTLSDir returns the directory the development certificates are kept in.
+func DefaultDevTLSDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	return filepath.Join(dir, "servicebase", "dev-tls")
+}
+
+// devTLSFiles are the files written by setupDevTLS().
+type devTLSFiles struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// devTLSHosts returns the names the development certificate should be valid for.
+func devTLSHosts(cfgs ...*ListenConfig) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+
+	add := func(network, address string) {
+		if network == "unix" {
+			return
+		}
+
+		host, _, err := net.SplitHostPort(address)
+		if err != nil || host == "" || slices.Contains(hosts, host) {
+			return
+		}
+
+		// Wildcard binds are covered by the defaults.
+		if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+			return
+		}
+
+		hosts = append(hosts, host)
+	}
+
+	for _, cfg := range cfgs {
+		add(cfg.BindNetwork, cfg.BindAddress)
+		for _, bind := range cfg.AdditionalBinds {
+			if network, address, err := ParseBind(bind); err == nil {
+				add(network, address)
+			}
+		}
+	}
+
+	return hosts
+}
+
+func writePEM(path string, blockType string, der []byte) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+}
+
+// loadDevCA loads the CA, if it exists and is still valid.
+func loadDevCA(certFile, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("unexpected ca key type")
+	}
+
+	// Leave enough time for the leaf to be valid.
+	if time.Now().Add(devCertValidity).After(pair.Leaf.NotAfter) {
+		return nil, nil, errors.New("ca expiring")
+	}
+
+	return pair.Leaf, key, nil
+}
+
+func newDevCertificate(tmpl *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) ([]byte, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tmpl.SerialNumber = serial
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return der, key, nil
+}
+
+func writeDevKey(path string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	return writePEM(path, "EC PRIVATE KEY", der)
+}
+
+// setupDevTLS creates a leaf certificate for the given hosts, signed by a CA kept in dir.
+// The CA is reused while it remains valid, so clients only need to trust it once.
+func setupDevTLS(dir string, hosts []string) (*devTLSFiles, error) {
+	files := &devTLSFiles{
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CertFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:  filepath.Join(dir, "key.pem"),
+	}
+	caKeyFile := filepath.Join(dir, "ca-key.pem")
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	ca, caKey, err := loadDevCA(files.CAFile, caKeyFile)
+	if err != nil {
+		der, key, err := newDevCertificate(&x509.Certificate{
+			Subject:               pkix.Name{CommonName: "servicebase development CA"},
+			NotAfter:              time.Now().Add(devCAValidity),
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if ca, err = x509.ParseCertificate(der); err != nil {
+			return nil, err
+		}
+
+		if err := writeDevKey(caKeyFile, key); err != nil {
+			return nil, err
+		}
+
+		if err := writePEM(files.CAFile, "CERTIFICATE", der); err != nil {
+			return nil, err
+		}
+
+		caKey = key
+	}
+
+	leaf := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: hosts[0]},
+		NotAfter:    time.Now().Add(devCertValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			leaf.IPAddresses = append(leaf.IPAddresses, ip)
+		} else {
+			leaf.DNSNames = append(leaf.DNSNames, h)
+		}
+	}
+
+	der, key, err := newDevCertificate(leaf, ca, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeDevKey(files.KeyFile, key); err != nil {
+		return nil, err
+	}
+
+	if err := writePEM(files.CertFile, "CERTIFICATE", der); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// applyDevTLS enables TLS on every listener without it, using development certificates.
+func applyDevTLS(cfg *ServiceConfig, logger *slog.Logger) error {
+	dir := cfg.DevTLSDir
+	if dir == "" {
+		dir = DefaultDevTLSDir()
+	}
+
+	listeners := []*ListenConfig{&cfg.HTTP.ListenConfig, &cfg.GRPC.ListenConfig, &cfg.Admin.ListenConfig}
+
+	files, err := setupDevTLS(dir, devTLSHosts(listeners...))
+	if err != nil {
+		logger.Error("unable to create development certificates", slog.Any("error", err))
+		return fmt.Errorf("dev tls: %w", err)
+	}
+
+	for _, lcfg := range listeners {
+		if !lcfg.TLS.Enabled() {
+			lcfg.TLS.CertFile = files.CertFile
+			lcfg.TLS.KeyFile = files.KeyFile
+		}
+	}
+
+	// Clients should trust the CA, e.g. curl --cacert or grpcurl -cacert.
+	logger.Warn("development tls enabled, do not use in production", slog.String("ca_file", files.CAFile))
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDevTLSHosts(t *testing.T) {
+	t.Parallel()
+
+	hosts := devTLSHosts(
+		&ListenConfig{BindNetwork: "tcp", BindAddress: "0.0.0.0:8080", AdditionalBinds: []string{"tcp://myhost:8081", "unix:///run/x.sock"}},
+		&ListenConfig{BindNetwork: "tcp", BindAddress: "10.0.0.1:50051"},
+		&ListenConfig{BindNetwork: "unix", BindAddress: "/run/y.sock"},
+	)
+
+	assert.Equal(t, []string{"localhost", "127.0.0.1", "::1", "myhost", "10.0.0.1"}, hosts)
+}
+
+func TestSetupDevTLS(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	hosts := []string{"localhost", "127.0.0.1", "myhost"}
+
+	files, err := setupDevTLS(dir, hosts)
+	require.NoError(t, err)
+
+	ca, err := os.ReadFile(files.CAFile)
+	require.NoError(t, err)
+
+	verify := func() {
+		roots := x509.NewCertPool()
+		require.True(t, roots.AppendCertsFromPEM(ca))
+
+		pair, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+		require.NoError(t, err)
+
+		for _, host := range hosts {
+			_, err := pair.Leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots})
+			assert.NoError(t, err, host)
+		}
+	}
+
+	verify()
+
+	// The CA is reused, so clients needn't trust it again.
+	files, err = setupDevTLS(dir, hosts)
+	require.NoError(t, err)
+	verify()
+}
@@ -15,43 +15,77 @@
 package servicebase
 
 import (
+	"net/http"
+	"slices"
+
 	grpcprommetrics "github.com/grpc-ecosystem/go-grpc-middleware/providers/prometheus"
 	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 
+	"github.com/vs49688/servicebase/internal/grpcweb"
+	"github.com/vs49688/servicebase/internal/middleware/recovery"
 	"github.com/vs49688/servicebase/internal/middleware/requestid"
+	"github.com/vs49688/servicebase/internal/middleware/tracing"
+	"github.com/vs49688/servicebase/internal/transcode"
 )
 
-func createGRPCServer(cfg *ServiceConfig, registry *prometheus.Registry) (*grpc.Server, error) {
+// createGRPCServer creates the GRPC server and, if GRPC-Web or transcoding is enabled,
+// another with the same options to serve requests bridged from HTTP.
+// grpc.Server.GracefulStop() doesn't support requests via ServeHTTP(), so they can't share.
+func createGRPCServer(cfg *ServiceConfig, m *Metrics, tr *Tracing, listeners *grpcListeners, onPanic recovery.PanicFunc) (*grpc.Server, *grpc.Server, error) {
 	var metrics *grpcprommetrics.ServerMetrics
-	opts := cfg.GRPC.Options
+	// Appended to, so mustn't share the caller's backing array.
+	opts := slices.Clone(cfg.GRPC.Options)
 
-	var unaryInterceptors []grpc.UnaryServerInterceptor
-	var streamInterceptors []grpc.StreamServerInterceptor
+	// The listener first, so it's logged by everything else.
+	unaryInterceptors := []grpc.UnaryServerInterceptor{listeners.unaryServerInterceptor}
+	streamInterceptors := []grpc.StreamServerInterceptor{listeners.streamServerInterceptor}
 
-	if !cfg.GRPC.DisableMetrics {
-		metrics = grpcprommetrics.NewServerMetrics()
+	// Before the metrics, so their exemplars can refer to the request ID.
+	if !cfg.DisableRequestID {
+		ids, err := cfg.RequestID.options()
+		if err != nil {
+			return nil, nil, err
+		}
 
-		unaryInterceptors = append(unaryInterceptors, metrics.UnaryServerInterceptor())
-		streamInterceptors = append(streamInterceptors, metrics.StreamServerInterceptor())
+		unaryInterceptors = append(unaryInterceptors, requestid.UnaryServerInterceptor(ids))
+		streamInterceptors = append(streamInterceptors, requestid.StreamServerInterceptor(ids))
 	}
 
-	if !cfg.DisableRequestID {
-		unaryInterceptors = append(unaryInterceptors, requestid.UnaryServerInterceptor)
-		streamInterceptors = append(streamInterceptors, requestid.StreamServerInterceptor)
+	// Before the metrics, so their exemplars can refer to the trace.
+	if tr.enabled() {
+		unaryInterceptors = append(unaryInterceptors, tracing.UnaryServerInterceptor(tr.Tracer, tr.Propagator))
+		streamInterceptors = append(streamInterceptors, tracing.StreamServerInterceptor(tr.Tracer, tr.Propagator))
 	}
 
-	if len(unaryInterceptors) > 0 {
-		opts = append(opts, grpc.ChainUnaryInterceptor(unaryInterceptors...))
-	}
+	if !cfg.GRPC.DisableMetrics {
+		histogramOpts := m.histograms.apply(prometheus.HistogramOpts{})
+		metrics = grpcprommetrics.NewServerMetrics(grpcprommetrics.WithServerHandlingTimeHistogram(
+			grpcprommetrics.WithHistogramOpts(&histogramOpts),
+		))
+		exemplar := grpcprommetrics.WithExemplarFromContext(m.exemplar)
 
-	if len(streamInterceptors) > 0 {
-		opts = append(opts, grpc.ChainStreamInterceptor(streamInterceptors...))
+		unaryInterceptors = append(unaryInterceptors, metrics.UnaryServerInterceptor(exemplar))
+		streamInterceptors = append(streamInterceptors, metrics.StreamServerInterceptor(exemplar))
 	}
 
+	// Last, so the request ID is available and the metrics see codes.Internal.
+	unaryInterceptors = append(unaryInterceptors, recovery.UnaryServerInterceptor(onPanic))
+	streamInterceptors = append(streamInterceptors, recovery.StreamServerInterceptor(onPanic))
+
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	)
+
 	srv := grpc.NewServer(opts...)
 
+	var bridgeSrv *grpc.Server
+	if cfg.GRPC.EnableWeb || cfg.GRPC.EnableTranscoding {
+		bridgeSrv = grpc.NewServer(opts...)
+	}
+
 	if cfg.GRPC.EnableReflection {
 		reflection.Register(srv)
 	}
@@ -59,10 +93,47 @@ func createGRPCServer(cfg *ServiceConfig, registry *prometheus.Registry) (*grpc.
 	if !cfg.GRPC.DisableMetrics {
 		metrics.InitializeMetrics(srv)
 
-		if err := registry.Register(metrics); err != nil {
-			return nil, err
+		if err := m.Registerer.Register(metrics); err != nil {
+			return nil, nil, err
 		}
 	}
 
-	return srv, nil
+	return srv, bridgeSrv, nil
+}
+
+// grpcRegistrars registers services with every server.
+type grpcRegistrars []grpc.ServiceRegistrar
+
+func (r grpcRegistrars) RegisterService(desc *grpc.ServiceDesc, impl any) {
+	for _, reg := range r {
+		reg.RegisterService(desc, impl)
+	}
+}
+
+// newGRPCBridgeHandler serves GRPC requests bridged from HTTP using srv. The HTTP
+// request ID is passed on, so both refer to the same request, as is its span, so the
+// GRPC call's span is its child.
+func newGRPCBridgeHandler(srv *grpc.Server, cfg *RequestIDConfig, tr *Tracing) http.Handler {
+	key := cfg.withDefaults().MetadataKey
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := requestid.FromContext(r.Context()); id != "" {
+			r.Header.Set(key, id)
+		}
+
+		tr.InjectHTTP(r.Context(), r.Header)
+
+		srv.ServeHTTP(w, r)
+	})
+}
+
+// newGRPCWebHandler serves GRPC-Web requests using srv.
+func newGRPCWebHandler(srv *grpc.Server, cfg *ServiceConfig, tr *Tracing) http.Handler {
+	return grpcweb.NewHandler(newGRPCBridgeHandler(srv, &cfg.RequestID, tr), cfg.GRPC.WebAllowedOrigins)
+}
+
+// newTranscoder serves the unary methods of srv as REST/JSON under basePath. Services
+// must be registered with it once the service has registered them with srv.
+func newTranscoder(srv *grpc.Server, cfg *ServiceConfig, tr *Tracing, basePath string) *transcode.Transcoder {
+	return transcode.New(newGRPCBridgeHandler(srv, &cfg.RequestID, tr), basePath, cfg.GRPC.TranscodingPrefix)
 }
@@ -0,0 +1,45 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func TestCreateGRPCServerOptions(t *testing.T) {
+	t.Parallel()
+
+	cfg := DefaultServiceConfig()
+	cfg.GRPC.EnableWeb = true
+	cfg.GRPC.DisableMetrics = true
+
+	// Spare capacity, which appending to would overwrite.
+	cfg.GRPC.Options = make([]grpc.ServerOption, 1, 4)
+	cfg.GRPC.Options[0] = grpc.MaxRecvMsgSize(1024)
+
+	metrics, _, err := configureMetrics(&cfg, slog.Default())
+	require.NoError(t, err)
+
+	_, _, err = createGRPCServer(&cfg, &metrics, &Tracing{}, &grpcListeners{}, nil)
+	require.NoError(t, err)
+
+	assert.Len(t, cfg.GRPC.Options, 1)
+	assert.Nil(t, cfg.GRPC.Options[:2][1])
+}
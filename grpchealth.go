@@ -0,0 +1,171 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// How often Watch() re-checks the health, absent any explicit changes.
+const grpcHealthWatchInterval = 5 * time.Second
+
+// GRPCHealth serves grpc.health.v1.Health from Service.GetHealth(). The overall status
+// is the "" service, and each dependency is a service of the same name. Healthy and
+// degraded are SERVING, unhealthy is NOT_SERVING. Statuses set with SetServingStatus()
+// take precedence.
+type GRPCHealth struct {
+	grpc_health_v1.UnimplementedHealthServer
+
+	check    healthFunc
+	draining *atomic.Bool
+	interval time.Duration
+
+	mu       sync.Mutex
+	statuses map[string]grpc_health_v1.HealthCheckResponse_ServingStatus
+	changed  chan struct{} // Closed and replaced upon any change
+}
+
+func newGRPCHealth(check healthFunc, draining *atomic.Bool) *GRPCHealth {
+	return &GRPCHealth{
+		check:    check,
+		draining: draining,
+		interval: grpcHealthWatchInterval,
+		statuses: map[string]grpc_health_v1.HealthCheckResponse_ServingStatus{},
+		changed:  make(chan struct{}),
+	}
+}
+
+// SetServingStatus explicitly sets the status of a GRPC service, overriding GetHealth().
+func (h *GRPCHealth) SetServingStatus(service string, st grpc_health_v1.HealthCheckResponse_ServingStatus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.statuses[service] = st
+	h.notifyLocked()
+}
+
+// ClearServingStatus reverts a GRPC service to its status from GetHealth().
+func (h *GRPCHealth) ClearServingStatus(service string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.statuses, service)
+	h.notifyLocked()
+}
+
+// notify wakes any watchers, so they re-check the health.
+func (h *GRPCHealth) notify() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.notifyLocked()
+}
+
+func (h *GRPCHealth) notifyLocked() {
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// servingStatus returns the status of a GRPC service, or false if it's unknown.
+func (h *GRPCHealth) servingStatus(ctx context.Context, service string) (grpc_health_v1.HealthCheckResponse_ServingStatus, bool) {
+	if h.draining.Load() {
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING, true
+	}
+
+	h.mu.Lock()
+	st, ok := h.statuses[service]
+	h.mu.Unlock()
+
+	if ok {
+		return st, true
+	}
+
+	r, err := h.check(ctx)
+	if err != nil || r == nil {
+		// Dependencies are unknown if the check fails.
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING, service == ""
+	}
+
+	if service != "" {
+		if r = r.Dependencies[service]; r == nil {
+			return grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN, false
+		}
+	}
+
+	switch r.Status {
+	case HealthStatusHealthy, HealthStatusDegraded:
+		return grpc_health_v1.HealthCheckResponse_SERVING, true
+	case HealthStatusUnhealthy:
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING, true
+	default:
+		return grpc_health_v1.HealthCheckResponse_UNKNOWN, true
+	}
+}
+
+func (h *GRPCHealth) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	st, ok := h.servingStatus(ctx, req.Service)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "unknown service")
+	}
+
+	return &grpc_health_v1.HealthCheckResponse{Status: st}, nil
+}
+
+// Watch sends the status whenever it changes. Once draining, the stream is ended after
+// sending NOT_SERVING, so it doesn't hold up a graceful stop.
+func (h *GRPCHealth) Watch(req *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	ctx := stream.Context()
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	var last *grpc_health_v1.HealthCheckResponse_ServingStatus
+	for {
+		h.mu.Lock()
+		changed := h.changed
+		h.mu.Unlock()
+
+		st, ok := h.servingStatus(ctx, req.Service)
+		if !ok {
+			st = grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN
+		}
+
+		if last == nil || *last != st {
+			if err := stream.Send(&grpc_health_v1.HealthCheckResponse{Status: st}); err != nil {
+				return status.Error(codes.Canceled, "stream has ended")
+			}
+
+			last = &st
+		}
+
+		if h.draining.Load() {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.Error(codes.Canceled, "stream has ended")
+		case <-ticker.C:
+		case <-changed:
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/vs49688/servicebase"
+	"github.com/vs49688/servicebase/servicetest"
+)
+
+type dependencyService struct {
+	healthService
+}
+
+func (s *dependencyService) GetHealth(context.Context) (*servicebase.GetHealthResponse, error) {
+	return &servicebase.GetHealthResponse{
+		Status: servicebase.HealthStatusDegraded,
+		Dependencies: map[string]*servicebase.GetHealthResponse{
+			"db":    {Status: servicebase.HealthStatusHealthy},
+			"cache": {Status: servicebase.HealthStatusUnhealthy},
+		},
+	}, nil
+}
+
+func TestGRPCHealth(t *testing.T) {
+	t.Parallel()
+
+	var grpcHealth *servicebase.GRPCHealth
+	h := servicetest.Start(t, servicebase.DefaultServiceConfig(), func(_ context.Context, params servicebase.ServiceParameters) (servicebase.Service, error) {
+		grpcHealth = params.GRPCHealth
+		grpcHealth.SetServingStatus("example.Explicit", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+		return &dependencyService{}, nil
+	}, servicetest.Options{InMemory: true})
+
+	client := grpc_health_v1.NewHealthClient(h.GRPCConn)
+
+	for service, want := range map[string]grpc_health_v1.HealthCheckResponse_ServingStatus{
+		"":                 grpc_health_v1.HealthCheckResponse_SERVING,
+		"db":               grpc_health_v1.HealthCheckResponse_SERVING,
+		"cache":            grpc_health_v1.HealthCheckResponse_NOT_SERVING,
+		"example.Explicit": grpc_health_v1.HealthCheckResponse_NOT_SERVING,
+	} {
+		resp, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: service})
+		require.NoError(t, err, service)
+		assert.Equal(t, want, resp.Status, service)
+	}
+
+	_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	t.Run("Watch", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		stream, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{Service: "db"})
+		require.NoError(t, err)
+
+		resp, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, resp.Status)
+
+		grpcHealth.SetServingStatus("db", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+		resp, err = stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, resp.Status)
+
+		grpcHealth.ClearServingStatus("db")
+		resp, err = stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, resp.Status)
+
+		unknown, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{Service: "missing"})
+		require.NoError(t, err)
+		resp, err = unknown.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN, resp.Status)
+	})
+}
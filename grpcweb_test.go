@@ -0,0 +1,181 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/vs49688/servicebase"
+	"github.com/vs49688/servicebase/servicetest"
+)
+
+// grpcWebFrame encodes a GRPC-Web frame.
+func grpcWebFrame(flags byte, payload []byte) []byte {
+	frame := make([]byte, 5, 5+len(payload))
+	frame[0] = flags
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	return append(frame, payload...)
+}
+
+// readGRPCWebFrames decodes a GRPC-Web response into its message and trailers.
+func readGRPCWebFrames(t *testing.T, body []byte) ([][]byte, map[string]string) {
+	t.Helper()
+
+	var messages [][]byte
+	trailers := map[string]string{}
+
+	for len(body) > 0 {
+		require.GreaterOrEqual(t, len(body), 5)
+		n := binary.BigEndian.Uint32(body[1:5])
+		payload := body[5 : 5+n]
+
+		if body[0]&0x80 == 0 {
+			messages = append(messages, payload)
+		} else {
+			for _, line := range strings.Split(strings.TrimSpace(string(payload)), "\r\n") {
+				k, v, _ := strings.Cut(line, ": ")
+				trailers[k] = v
+			}
+		}
+
+		body = body[5+n:]
+	}
+
+	return messages, trailers
+}
+
+func TestGRPCWeb(t *testing.T) {
+	t.Parallel()
+
+	cfg := servicebase.DefaultServiceConfig()
+	cfg.GRPC.EnableWeb = true
+	cfg.GRPC.WebAllowedOrigins = []string{"https://example.com"}
+
+	h := servicetest.Start(t, cfg, func(_ context.Context, params servicebase.ServiceParameters) (servicebase.Service, error) {
+		grpc_health_v1.RegisterHealthServer(params.GRPCRegistrar, health.NewServer())
+		return &healthService{health: servicebase.HealthStatusHealthy}, nil
+	}, servicetest.Options{InMemory: true})
+
+	url := h.BaseURL + "/grpc-web/grpc.health.v1.Health/Check"
+
+	call := func(t *testing.T, contentType string, req *grpc_health_v1.HealthCheckRequest) (*http.Response, [][]byte, map[string]string) {
+		payload, err := proto.Marshal(req)
+		require.NoError(t, err)
+
+		body := grpcWebFrame(0, payload)
+		text := strings.HasPrefix(contentType, "application/grpc-web-text")
+		if text {
+			body = []byte(base64.StdEncoding.EncodeToString(body))
+		}
+
+		hreq, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		require.NoError(t, err)
+		hreq.Header.Set("Content-Type", contentType)
+		hreq.Header.Set("Origin", "https://example.com")
+
+		resp, err := h.HTTPClient.Do(hreq)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		// Each flush ends a padded segment, so decode 4 characters at a time.
+		if text {
+			var decoded []byte
+			for ; len(b) > 0; b = b[4:] {
+				chunk, err := base64.StdEncoding.DecodeString(string(b[:4]))
+				require.NoError(t, err)
+				decoded = append(decoded, chunk...)
+			}
+
+			b = decoded
+		}
+
+		messages, trailers := readGRPCWebFrames(t, b)
+		return resp, messages, trailers
+	}
+
+	for _, contentType := range []string{"application/grpc-web+proto", "application/grpc-web-text+proto"} {
+		t.Run(contentType, func(t *testing.T) {
+			resp, messages, trailers := call(t, contentType, &grpc_health_v1.HealthCheckRequest{})
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, contentType, resp.Header.Get("Content-Type"))
+			assert.Equal(t, "https://example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+			assert.Contains(t, resp.Header.Get("Access-Control-Expose-Headers"), "Grpc-Status")
+			assert.NotEmpty(t, resp.Header.Get("X-Request-ID"))
+			assert.Equal(t, "0", trailers["grpc-status"])
+
+			require.Len(t, messages, 1)
+			var hresp grpc_health_v1.HealthCheckResponse
+			require.NoError(t, proto.Unmarshal(messages[0], &hresp))
+			assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, hresp.Status)
+		})
+	}
+
+	t.Run("Error", func(t *testing.T) {
+		resp, messages, trailers := call(t, "application/grpc-web+proto", &grpc_health_v1.HealthCheckRequest{Service: "missing"})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, messages)
+		assert.Equal(t, "5", trailers["grpc-status"]) // NOT_FOUND
+	})
+
+	t.Run("Preflight", func(t *testing.T) {
+		for origin, code := range map[string]int{"https://example.com": http.StatusNoContent, "https://evil.com": http.StatusForbidden} {
+			req, err := http.NewRequest(http.MethodOptions, url, nil)
+			require.NoError(t, err)
+			req.Header.Set("Origin", origin)
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web")
+
+			resp, err := h.HTTPClient.Do(req)
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+			assert.Equal(t, code, resp.StatusCode, origin)
+
+			if code == http.StatusNoContent {
+				assert.Equal(t, origin, resp.Header.Get("Access-Control-Allow-Origin"))
+				assert.Equal(t, "content-type,x-grpc-web", resp.Header.Get("Access-Control-Allow-Headers"))
+			}
+		}
+	})
+
+	// The GRPC interceptors still apply.
+	families, err := h.Registry.Gather()
+	require.NoError(t, err)
+
+	var handled float64
+	for _, mf := range families {
+		if mf.GetName() == "grpc_server_handled_total" {
+			for _, m := range mf.GetMetric() {
+				handled += m.GetCounter().GetValue()
+			}
+		}
+	}
+	assert.Equal(t, 3.0, handled)
+}
@@ -15,8 +15,14 @@
 package servicebase
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
 )
 
 type HealthStatus string
@@ -32,42 +38,218 @@ type GetHealthResponse struct {
 	Status       HealthStatus                  `json:"status"`
 	Message      string                        `json:"message"`
 	Dependencies map[string]*GetHealthResponse `json:"dependencies"`
+
+	// Optional details of a dependency, reported in the HTTP response's checks.
+	ComponentType string    `json:"component_type,omitempty"` // Such as "datastore", "component" or "system"
+	ObservedValue any       `json:"observed_value,omitempty"`
+	ObservedUnit  string    `json:"observed_unit,omitempty"`
+	Time          time.Time `json:"time"` // When the status was determined
 }
 
-func healthStatusToHTTP(r *GetHealthResponse) HTTPHealthResponse {
-	hr := HTTPHealthResponse{}
-	switch r.Status {
+// defaultHealthStatusCodes are the HTTP status codes of the health endpoints, unless
+// overridden by HTTPConfig.HealthStatusCodes.
+var defaultHealthStatusCodes = map[HealthStatus]int{
+	HealthStatusHealthy:   http.StatusOK,
+	HealthStatusDegraded:  http.StatusOK,
+	HealthStatusUnknown:   http.StatusOK,
+	HealthStatusUnhealthy: http.StatusServiceUnavailable,
+}
+
+func healthStatusToHTTPStatus(s HealthStatus) HTTPHealthStatus {
+	switch s {
 	case HealthStatusUnhealthy:
-		hr.Status = HTTPHealthStatusFail
+		return HTTPHealthStatusFail
 	case HealthStatusDegraded, HealthStatusUnknown:
-		hr.Status = HTTPHealthStatusWarn
+		return HTTPHealthStatusWarn
 	default:
-		hr.Status = HTTPHealthStatusPass
+		return HTTPHealthStatusPass
 	}
+}
+
+func healthStatusToHTTP(r *GetHealthResponse) HTTPHealthResponse {
+	hr := HTTPHealthResponse{Status: healthStatusToHTTPStatus(r.Status)}
 
 	if r.Message != "" {
 		hr.Notes = []string{r.Message}
 	}
 
+	addHealthChecks(&hr, "", r.Dependencies)
 	return hr
 }
 
-func checkHealth(impl Service, logger *slog.Logger) http.HandlerFunc {
+// addHealthChecks adds the dependencies as checks. Nested dependencies are named
+// "parent/child".
+func addHealthChecks(hr *HTTPHealthResponse, prefix string, deps map[string]*GetHealthResponse) {
+	for name, dep := range deps {
+		if dep == nil {
+			continue
+		}
+
+		check := HTTPHealthCheck{
+			ComponentType: dep.ComponentType,
+			ObservedValue: dep.ObservedValue,
+			ObservedUnit:  dep.ObservedUnit,
+			Status:        healthStatusToHTTPStatus(dep.Status),
+		}
+
+		if !dep.Time.IsZero() {
+			check.Time = dep.Time.UTC().Format(time.RFC3339)
+		}
+
+		if check.Status != HTTPHealthStatusPass {
+			check.Output = dep.Message
+		}
+
+		if hr.Checks == nil {
+			hr.Checks = map[string][]HTTPHealthCheck{}
+		}
+
+		hr.Checks[prefix+name] = append(hr.Checks[prefix+name], check)
+		addHealthChecks(hr, prefix+name+"/", dep.Dependencies)
+	}
+}
+
+// buildInfo identifies the service in health responses.
+var buildInfo = sync.OnceValue(func() HTTPHealthResponse {
+	var hr HTTPHealthResponse
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return hr
+	}
+
+	hr.ServiceID = bi.Path
+	if bi.Main.Version != "(devel)" {
+		hr.Version = bi.Main.Version
+	}
+
+	for _, s := range bi.Settings {
+		if s.Key == "vcs.revision" {
+			hr.ReleaseID = s.Value
+		}
+	}
+
+	return hr
+})
+
+type healthFunc func(ctx context.Context) (*GetHealthResponse, error)
+
+// checkHealth serves a health endpoint. Only the status is returned, unless ?verbose
+// is given.
+func (sw *serviceBase) checkHealth(check healthFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		var hr HTTPHealthResponse
+		status := HealthStatusUnhealthy
 
-		r, err := impl.GetHealth(req.Context())
+		r, err := check(req.Context())
 		if err != nil || r == nil {
-			logger.Error("health check failed", slog.Any("error", err))
+			sw.logger.Error("health check failed", slog.Any("error", err))
 			hr = HTTPHealthResponse{
 				Status: HTTPHealthStatusFail,
 				Notes:  []string{"health check failed"},
-				Output: err.Error(), // ehhhhh
+			}
+
+			if err != nil {
+				hr.Output = err.Error()
 			}
 		} else {
 			hr = healthStatusToHTTP(r)
+			status = r.Status
+		}
+
+		if verbose(req) {
+			bi := buildInfo()
+			hr.Version, hr.ReleaseID, hr.ServiceID = bi.Version, bi.ReleaseID, bi.ServiceID
+		} else {
+			hr = HTTPHealthResponse{Status: hr.Status}
 		}
 
-		hr.ServeHTTP(w, req)
+		hr.write(w, sw.healthStatusCode(status))
+	}
+}
+
+// verbose returns true if ?verbose is given, and isn't false.
+func verbose(req *http.Request) bool {
+	v, ok := req.URL.Query()["verbose"]
+	if !ok {
+		return false
+	}
+
+	b, err := strconv.ParseBool(v[0])
+	return v[0] == "" || (err == nil && b)
+}
+
+func (sw *serviceBase) healthStatusCode(s HealthStatus) int {
+	if code, ok := (*sw.healthStatusCodes.Load())[s]; ok {
+		return code
+	}
+
+	if code, ok := defaultHealthStatusCodes[s]; ok {
+		return code
+	}
+
+	return defaultHealthStatusCodes[HealthStatusUnknown]
+}
+
+func (sw *serviceBase) getLiveness(_ context.Context) (*GetHealthResponse, error) {
+	// If we can answer, we're alive.
+	return &GetHealthResponse{Status: HealthStatusHealthy}, nil
+}
+
+func (sw *serviceBase) getReadiness(ctx context.Context) (*GetHealthResponse, error) {
+	if sw.draining.Load() {
+		return &GetHealthResponse{Status: HealthStatusUnhealthy, Message: "shutting down"}, nil
+	}
+
+	if !sw.started.Load() {
+		return &GetHealthResponse{Status: HealthStatusUnhealthy, Message: "starting"}, nil
+	}
+
+	if rc, ok := sw.svc.(ReadinessCheckable); ok {
+		return rc.GetReadiness(ctx)
+	}
+
+	return sw.getHealth(ctx)
+}
+
+func (sw *serviceBase) getStartup(ctx context.Context) (*GetHealthResponse, error) {
+	if !sw.started.Load() {
+		return &GetHealthResponse{Status: HealthStatusUnhealthy, Message: "starting"}, nil
+	}
+
+	if sc, ok := sw.svc.(StartupCheckable); ok {
+		return sc.GetStartup(ctx)
+	}
+
+	return &GetHealthResponse{Status: HealthStatusHealthy}, nil
+}
+
+func (sw *serviceBase) getHealth(ctx context.Context) (*GetHealthResponse, error) {
+	r, err := sw.svc.GetHealth(ctx)
+	if err != nil || r == nil {
+		msg := "no health response"
+		if err != nil {
+			msg = err.Error()
+		}
+
+		sw.healthHistory.observe("", HealthStatusUnhealthy, msg)
+		return r, err
+	}
+
+	r = sw.healthChecks.apply(r)
+	sw.healthHistory.observeResponse("", r)
+	return r, nil
+}
+
+// beginDrain fails readiness checks and stops keep-alives, so load balancers
+// move traffic away before the listeners are closed.
+func (sw *serviceBase) beginDrain() {
+	sw.draining.Store(true)
+	sw.grpcHealth.notify()
+
+	// This also causes in-flight HTTP/1.x responses to be sent with "Connection: close".
+	// Those replaced by a reload may still be serving their connections.
+	for _, srv := range slices.Concat(sw.httpServers, sw.retiredServers) {
+		srv.SetKeepAlivesEnabled(false)
 	}
 }
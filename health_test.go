@@ -0,0 +1,265 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase_test
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vs49688/servicebase"
+	"github.com/vs49688/servicebase/servicetest"
+)
+
+type healthService struct {
+	health    servicebase.HealthStatus
+	readiness servicebase.HealthStatus
+}
+
+func (s *healthService) GetHealth(context.Context) (*servicebase.GetHealthResponse, error) {
+	return &servicebase.GetHealthResponse{Status: s.health}, nil
+}
+
+func (s *healthService) GetReadiness(context.Context) (*servicebase.GetHealthResponse, error) {
+	return &servicebase.GetHealthResponse{Status: s.readiness}, nil
+}
+
+func (s *healthService) Close(context.Context) error {
+	return nil
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	t.Parallel()
+
+	svc := &healthService{
+		health:    servicebase.HealthStatusUnhealthy,
+		readiness: servicebase.HealthStatusHealthy,
+	}
+
+	cfg := servicebase.DefaultServiceConfig()
+	cfg.GRPC.Enabled = false
+
+	h := servicetest.Start(t, cfg, func(context.Context, servicebase.ServiceParameters) (servicebase.Service, error) {
+		return svc, nil
+	}, servicetest.Options{InMemory: true})
+
+	for path, status := range map[string]int{
+		"/health":         http.StatusServiceUnavailable,
+		"/health/live":    http.StatusOK,
+		"/health/ready":   http.StatusOK,
+		"/health/startup": http.StatusOK,
+	} {
+		resp, err := h.HTTPClient.Get(h.BaseURL + path)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+
+		assert.Equal(t, status, resp.StatusCode, path)
+		assert.Equal(t, "application/health+json", resp.Header.Get("Content-Type"), path)
+	}
+}
+
+type funcService struct {
+	healthService
+	getHealth func() (*servicebase.GetHealthResponse, error)
+}
+
+func (s *funcService) GetHealth(context.Context) (*servicebase.GetHealthResponse, error) {
+	return s.getHealth()
+}
+
+func TestHealthResponse(t *testing.T) {
+	t.Parallel()
+
+	cfg := servicebase.DefaultServiceConfig()
+	cfg.GRPC.Enabled = false
+	cfg.HTTP.HealthStatusCodes = map[servicebase.HealthStatus]int{servicebase.HealthStatusDegraded: http.StatusTooManyRequests}
+
+	var failing atomic.Bool
+	svc := &funcService{getHealth: func() (*servicebase.GetHealthResponse, error) {
+		if failing.Load() {
+			return nil, nil
+		}
+
+		return &servicebase.GetHealthResponse{
+			Status:  servicebase.HealthStatusDegraded,
+			Message: "mostly fine",
+			Dependencies: map[string]*servicebase.GetHealthResponse{
+				"cache": {Status: servicebase.HealthStatusUnhealthy, Message: "connection refused", ComponentType: "datastore"},
+			},
+		}, nil
+	}}
+
+	h := servicetest.Start(t, cfg, func(_ context.Context, params servicebase.ServiceParameters) (servicebase.Service, error) {
+		return svc, params.HealthChecks.Register("db", servicebase.HealthCheck{
+			Check:         func(context.Context) error { return nil },
+			Interval:      time.Millisecond,
+			ComponentType: "datastore",
+			Critical:      true,
+		})
+	}, servicetest.Options{InMemory: true})
+
+	get := func(path string) (int, servicebase.HTTPHealthResponse) {
+		resp, err := h.HTTPClient.Get(h.BaseURL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var hr servicebase.HTTPHealthResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&hr))
+		return resp.StatusCode, hr
+	}
+
+	require.Eventually(t, func() bool {
+		_, hr := get("/health?verbose")
+		return len(hr.Checks["db"]) == 1 && hr.Checks["db"][0].Status == servicebase.HTTPHealthStatusPass
+	}, 5*time.Second, time.Millisecond)
+
+	// The status code of degraded is overridden.
+	code, hr := get("/health?verbose")
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	assert.Equal(t, servicebase.HTTPHealthStatus(servicebase.HTTPHealthStatusWarn), hr.Status)
+	assert.Equal(t, []string{"mostly fine"}, hr.Notes)
+	assert.NotEmpty(t, hr.ServiceID)
+
+	db := hr.Checks["db"][0]
+	assert.Equal(t, "datastore", db.ComponentType)
+	assert.Equal(t, "ms", db.ObservedUnit)
+	assert.NotNil(t, db.ObservedValue)
+	assert.Empty(t, db.Output)
+	_, err := time.Parse(time.RFC3339, db.Time)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []servicebase.HTTPHealthCheck{{
+		ComponentType: "datastore",
+		Status:        servicebase.HTTPHealthStatusFail,
+		Output:        "connection refused",
+	}}, hr.Checks["cache"])
+
+	// Public callers only see the status.
+	for _, path := range []string{"/health", "/health?verbose=false"} {
+		code, hr = get(path)
+		assert.Equal(t, http.StatusTooManyRequests, code, path)
+		assert.Equal(t, servicebase.HTTPHealthResponse{Status: servicebase.HTTPHealthStatusWarn}, hr, path)
+	}
+
+	// A nil response is a failure, not a crash.
+	failing.Store(true)
+	code, hr = get("/health?verbose=true")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, servicebase.HTTPHealthStatus(servicebase.HTTPHealthStatusFail), hr.Status)
+	assert.Empty(t, hr.Output)
+}
+
+func TestHealthHistory(t *testing.T) {
+	t.Parallel()
+
+	cfg := servicebase.DefaultServiceConfig()
+	cfg.GRPC.Enabled = false
+	cfg.HTTP.EnableDebug = true
+
+	var status atomic.Value
+	status.Store(servicebase.HealthStatusHealthy)
+	svc := &funcService{getHealth: func() (*servicebase.GetHealthResponse, error) {
+		return &servicebase.GetHealthResponse{
+			Status:       status.Load().(servicebase.HealthStatus),
+			Message:      "database unreachable",
+			Dependencies: map[string]*servicebase.GetHealthResponse{"db": {Status: status.Load().(servicebase.HealthStatus)}},
+		}, nil
+	}}
+
+	h := servicetest.Start(t, cfg, func(context.Context, servicebase.ServiceParameters) (servicebase.Service, error) {
+		return svc, nil
+	}, servicetest.Options{InMemory: true})
+
+	get := func(path string) *http.Response {
+		resp, err := h.HTTPClient.Get(h.BaseURL + path)
+		require.NoError(t, err)
+		return resp
+	}
+
+	for _, s := range []servicebase.HealthStatus{servicebase.HealthStatusHealthy, servicebase.HealthStatusHealthy, servicebase.HealthStatusUnhealthy} {
+		status.Store(s)
+		_ = get("/health").Body.Close()
+	}
+
+	resp := get("/debug/health/history")
+	defer resp.Body.Close()
+
+	var history []servicebase.HealthTransition
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&history))
+	require.Len(t, history, 4)
+
+	for i, want := range []servicebase.HealthTransition{
+		{Component: "", From: servicebase.HealthStatusUnknown, To: servicebase.HealthStatusHealthy},
+		{Component: "db", From: servicebase.HealthStatusUnknown, To: servicebase.HealthStatusHealthy},
+		{Component: "", From: servicebase.HealthStatusHealthy, To: servicebase.HealthStatusUnhealthy},
+		{Component: "db", From: servicebase.HealthStatusHealthy, To: servicebase.HealthStatusUnhealthy},
+	} {
+		assert.Equal(t, want.Component, history[i].Component, i)
+		assert.Equal(t, want.From, history[i].From, i)
+		assert.Equal(t, want.To, history[i].To, i)
+		assert.False(t, history[i].Time.IsZero(), i)
+	}
+	assert.Equal(t, "database unreachable", history[2].Message)
+
+	var logged int
+	for _, r := range h.Logs.Records() {
+		if r.Message != "health status changed" {
+			continue
+		}
+
+		// Recoveries are info, and anything else a warning.
+		want := slog.LevelInfo
+		if logged >= 2 {
+			want = slog.LevelWarn
+		}
+
+		assert.Equal(t, want, r.Level)
+		logged++
+	}
+	assert.Equal(t, 4, logged)
+
+	families, err := h.Registry.Gather()
+	require.NoError(t, err)
+
+	values := map[string]float64{}
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+
+			switch mf.GetName() {
+			case "health_status":
+				values["status "+labels["component"]+" "+labels["status"]] = m.GetGauge().GetValue()
+			case "health_transitions_total":
+				values["transitions "+labels["component"]+" "+labels["status"]] = m.GetCounter().GetValue()
+			}
+		}
+	}
+
+	assert.Equal(t, 1.0, values["status db unhealthy"])
+	assert.Equal(t, 0.0, values["status db healthy"])
+	assert.Equal(t, 1.0, values["status  unhealthy"])
+	assert.Equal(t, 1.0, values["transitions  healthy"])
+	assert.Equal(t, 1.0, values["transitions db unhealthy"])
+}
@@ -0,0 +1,269 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 5 * time.Second
+)
+
+// HealthCheck checks a dependency, such as a database, in the background.
+type HealthCheck struct {
+	// Check fails if it returns an error, or doesn't return within Timeout. One that
+	// ignores its context isn't run again until it returns.
+	Check func(ctx context.Context) error
+
+	Interval time.Duration // Between checks, 10s if zero
+	Timeout  time.Duration // Of each check, 5s if zero
+
+	// FailureThreshold consecutive failures make a healthy check unhealthy, and
+	// SuccessThreshold consecutive successes make it healthy again. Both are 1 if zero.
+	// The first result always applies.
+	FailureThreshold int
+	SuccessThreshold int
+
+	// Critical checks make the service unhealthy when failing. Others degrade it.
+	Critical bool
+
+	// ComponentType is reported in the health response, such as "datastore".
+	ComponentType string
+}
+
+type healthCheckState struct {
+	HealthCheck
+	name string
+
+	status      HealthStatus
+	message     string // The last error, if any
+	consecutive int    // Consecutive results disagreeing with status
+	duration    time.Duration
+	time        time.Time
+}
+
+// HealthChecks runs dependency checks in the background and caches the results. They
+// are added to the service's GetHealth() response as dependencies, and worsen its
+// status accordingly, so the service needn't aggregate them itself. Until its first
+// result, a check's status is unknown.
+type HealthChecks struct {
+	workers  *WorkerGroup
+	onChange func(name string, status HealthStatus, message string)
+
+	mu     sync.RWMutex
+	checks map[string]*healthCheckState
+}
+
+func newHealthChecks(workers *WorkerGroup, onChange func(name string, status HealthStatus, message string)) *HealthChecks {
+	return &HealthChecks{
+		workers:  workers,
+		onChange: onChange,
+		checks:   map[string]*healthCheckState{},
+	}
+}
+
+// Register adds a named check. Like workers, checks must be added before the
+// ServiceFactory returns. They're started with the servers and stopped upon shutdown.
+func (hc *HealthChecks) Register(name string, check HealthCheck) error {
+	if check.Check == nil {
+		return errors.New("health check has no check function")
+	}
+
+	if check.Interval <= 0 {
+		check.Interval = defaultHealthCheckInterval
+	}
+
+	if check.Timeout <= 0 {
+		check.Timeout = defaultHealthCheckTimeout
+	}
+
+	check.FailureThreshold = max(check.FailureThreshold, 1)
+	check.SuccessThreshold = max(check.SuccessThreshold, 1)
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if _, ok := hc.checks[name]; ok {
+		return fmt.Errorf("duplicate health check: %q", name)
+	}
+
+	s := &healthCheckState{HealthCheck: check, name: name, status: HealthStatusUnknown}
+	hc.checks[name] = s
+
+	hc.workers.GoWithRestart("health check "+name, func(ctx context.Context) error {
+		return hc.run(ctx, s)
+	})
+
+	return nil
+}
+
+func (hc *HealthChecks) run(ctx context.Context, s *healthCheckState) error {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	// The result of a check that overran its timeout, while it's still running.
+	var pending chan error
+
+	for {
+		start := time.Now()
+		var err error
+		pending, err = s.check(ctx, pending)
+
+		// Don't fail the check because we're stopping.
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		hc.record(s, start, err)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// check runs the check, failing it if it doesn't return within the timeout. A check
+// ignoring its context is left running, and its result is awaited by the next check
+// instead of starting another, which is returned as pending.
+func (s *healthCheckState) check(ctx context.Context, pending chan error) (chan error, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	if pending == nil {
+		pending = make(chan error, 1)
+		go func(result chan<- error) {
+			result <- s.Check(ctx)
+		}(pending)
+	}
+
+	select {
+	case err := <-pending:
+		return nil, err
+	case <-ctx.Done():
+	}
+
+	// It may have returned in the meantime.
+	select {
+	case err := <-pending:
+		return nil, err
+	default:
+		return pending, fmt.Errorf("check did not return within %s", s.Timeout)
+	}
+}
+
+func (hc *HealthChecks) record(s *healthCheckState, start time.Time, err error) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	s.duration = time.Since(start)
+	s.time = start
+
+	status, threshold := HealthStatusHealthy, s.SuccessThreshold
+	s.message = ""
+	if err != nil {
+		status, threshold = HealthStatusUnhealthy, s.FailureThreshold
+		s.message = err.Error()
+	}
+
+	prev := s.status
+	switch {
+	case s.status == status:
+		s.consecutive = 0
+	case s.status == HealthStatusUnknown:
+		s.status = status
+	default:
+		s.consecutive++
+		if s.consecutive >= threshold {
+			s.status, s.consecutive = status, 0
+		}
+	}
+
+	// Transitions are noticed even if nobody is checking the service's health.
+	if s.status != prev {
+		hc.onChange(s.name, s.status, s.message)
+	}
+}
+
+// apply adds the cached results to a GetHealth() response. The response is copied,
+// so the service may reuse it. Dependencies reported by the service take precedence.
+func (hc *HealthChecks) apply(r *GetHealthResponse) *GetHealthResponse {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	if len(hc.checks) == 0 {
+		return r
+	}
+
+	out := *r
+	out.Dependencies = make(map[string]*GetHealthResponse, len(r.Dependencies)+len(hc.checks))
+	for name, s := range hc.checks {
+		dep := &GetHealthResponse{
+			Status:        s.status,
+			Message:       s.message,
+			ComponentType: s.ComponentType,
+		}
+
+		// The response time, per the IETF health check format.
+		if !s.time.IsZero() {
+			dep.ObservedValue = float64(s.duration.Microseconds()) / 1000
+			dep.ObservedUnit = "ms"
+			dep.Time = s.time
+		}
+
+		out.Dependencies[name] = dep
+
+		status := s.status
+		if !s.Critical && status != HealthStatusHealthy {
+			status = HealthStatusDegraded
+		}
+
+		out.Status = worseHealthStatus(out.Status, status)
+	}
+
+	maps.Copy(out.Dependencies, r.Dependencies)
+	return &out
+}
+
+// worseHealthStatus returns the worse of two statuses. Unknown is worse than degraded,
+// but not unhealthy.
+func worseHealthStatus(a, b HealthStatus) HealthStatus {
+	rank := func(s HealthStatus) int {
+		switch s {
+		case HealthStatusHealthy:
+			return 0
+		case HealthStatusDegraded:
+			return 1
+		case HealthStatusUnhealthy:
+			return 3
+		default:
+			return 2
+		}
+	}
+
+	if rank(b) > rank(a) {
+		return b
+	}
+
+	return a
+}
@@ -0,0 +1,136 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/vs49688/servicebase"
+	"github.com/vs49688/servicebase/servicetest"
+)
+
+func TestHealthChecks(t *testing.T) {
+	t.Parallel()
+
+	var dbFailing, cacheFailing atomic.Bool
+	var dbChecks atomic.Int32
+	check := func(failing *atomic.Bool, calls *atomic.Int32) func(context.Context) error {
+		return func(context.Context) error {
+			if calls != nil {
+				calls.Add(1)
+			}
+
+			if failing.Load() {
+				return errors.New("connection refused")
+			}
+			return nil
+		}
+	}
+
+	h := servicetest.Start(t, servicebase.DefaultServiceConfig(), func(_ context.Context, params servicebase.ServiceParameters) (servicebase.Service, error) {
+		if err := params.HealthChecks.Register("db", servicebase.HealthCheck{
+			Check:            check(&dbFailing, &dbChecks),
+			Interval:         5 * time.Millisecond,
+			FailureThreshold: 3,
+			Critical:         true,
+		}); err != nil {
+			return nil, err
+		}
+
+		if err := params.HealthChecks.Register("cache", servicebase.HealthCheck{
+			Check:    check(&cacheFailing, nil),
+			Interval: 5 * time.Millisecond,
+		}); err != nil {
+			return nil, err
+		}
+
+		assert.Error(t, params.HealthChecks.Register("db", servicebase.HealthCheck{Check: check(&dbFailing, nil)}))
+		return &healthService{health: servicebase.HealthStatusHealthy}, nil
+	}, servicetest.Options{InMemory: true})
+
+	client := grpc_health_v1.NewHealthClient(h.GRPCConn)
+	statusOf := func(service string) grpc_health_v1.HealthCheckResponse_ServingStatus {
+		resp, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		return resp.Status
+	}
+
+	eventually := func(service string, want grpc_health_v1.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		assert.Eventually(t, func() bool { return statusOf(service) == want }, 5*time.Second, 5*time.Millisecond, service)
+	}
+
+	eventually("", grpc_health_v1.HealthCheckResponse_SERVING)
+	eventually("db", grpc_health_v1.HealthCheckResponse_SERVING)
+	eventually("cache", grpc_health_v1.HealthCheckResponse_SERVING)
+
+	// Non-critical checks only degrade the service, which is still serving.
+	cacheFailing.Store(true)
+	eventually("cache", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, statusOf(""))
+
+	// Critical checks fail the service, once past the threshold.
+	dbFailing.Store(true)
+	start := dbChecks.Load()
+	eventually("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	assert.GreaterOrEqual(t, dbChecks.Load()-start, int32(3))
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, statusOf("db"))
+
+	dbFailing.Store(false)
+	cacheFailing.Store(false)
+	eventually("", grpc_health_v1.HealthCheckResponse_SERVING)
+	eventually("cache", grpc_health_v1.HealthCheckResponse_SERVING)
+}
+
+func TestHealthCheckTimeout(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	defer close(release)
+
+	h := servicetest.Start(t, servicebase.DefaultServiceConfig(), func(_ context.Context, params servicebase.ServiceParameters) (servicebase.Service, error) {
+		// Ignores its context, so never returns by itself.
+		return &healthService{health: servicebase.HealthStatusHealthy}, params.HealthChecks.Register("stuck", servicebase.HealthCheck{
+			Check: func(context.Context) error {
+				calls.Add(1)
+				<-release
+				return nil
+			},
+			Interval: 5 * time.Millisecond,
+			Timeout:  10 * time.Millisecond,
+			Critical: true,
+		})
+	}, servicetest.Options{InMemory: true})
+
+	client := grpc_health_v1.NewHealthClient(h.GRPCConn)
+	assert.Eventually(t, func() bool {
+		resp, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: "stuck"})
+		require.NoError(t, err)
+		return resp.Status == grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	}, 5*time.Second, 5*time.Millisecond)
+
+	// Still running, so not started again.
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), calls.Load())
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// The number of transitions kept for /debug/health/history.
+const healthHistorySize = 100
+
+// HealthTransition is a change in the status of the service, or one of its dependencies.
+type HealthTransition struct {
+	Time      time.Time    `json:"time"`
+	Component string       `json:"component"` // Empty for the service itself
+	From      HealthStatus `json:"from"`
+	To        HealthStatus `json:"to"`
+	Message   string       `json:"message,omitempty"`
+}
+
+// healthHistory notices transitions whenever the health is determined, logging them
+// and recording them in the metrics. Nested dependencies are named "parent/child".
+type healthHistory struct {
+	logger  *slog.Logger
+	metrics *Metrics
+
+	mu      sync.Mutex
+	current map[string]HealthStatus
+	recent  []HealthTransition
+}
+
+func newHealthHistory(logger *slog.Logger, metrics *Metrics) *healthHistory {
+	return &healthHistory{
+		logger:  logger,
+		metrics: metrics,
+		current: map[string]HealthStatus{},
+		recent:  []HealthTransition{},
+	}
+}
+
+func (h *healthHistory) observe(component string, status HealthStatus, message string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	prev, ok := h.current[component]
+	if !ok {
+		prev = HealthStatusUnknown
+	}
+
+	h.current[component] = status
+	h.metrics.RecordHealthStatus(component, status)
+
+	if prev == status {
+		return
+	}
+
+	t := HealthTransition{
+		Time:      time.Now(),
+		Component: component,
+		From:      prev,
+		To:        status,
+		Message:   message,
+	}
+
+	if len(h.recent) >= healthHistorySize {
+		h.recent = slices.Delete(h.recent, 0, len(h.recent)-healthHistorySize+1)
+	}
+	h.recent = append(h.recent, t)
+
+	h.metrics.RecordHealthTransition(component, status)
+
+	level := slog.LevelWarn
+	if status == HealthStatusHealthy {
+		level = slog.LevelInfo
+	}
+
+	h.logger.Log(context.Background(), level, "health status changed",
+		slog.String("component", component),
+		slog.String("from", string(prev)),
+		slog.String("to", string(status)),
+		slog.String("message", message),
+	)
+}
+
+// observeResponse observes the service and each of its dependencies.
+func (h *healthHistory) observeResponse(prefix string, r *GetHealthResponse) {
+	h.observe(prefix, r.Status, r.Message)
+
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	for name, dep := range r.Dependencies {
+		if dep != nil {
+			h.observeResponse(prefix+name, dep)
+		}
+	}
+}
+
+// ServeHTTP serves the recent transitions, oldest first.
+func (h *healthHistory) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	h.mu.Lock()
+	b, _ := json.Marshal(h.recent)
+	h.mu.Unlock()
+
+	w.Header().Set("Content-Type", ContentTypeApplicationJSONUTF8)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
@@ -32,9 +32,23 @@ const (
 
 // https://www.ietf.org/archive/id/draft-inadarei-api-health-check-06.html
 type HTTPHealthResponse struct {
-	Status HTTPHealthStatus `json:"status"`
-	Notes  []string         `json:"notes,omitempty"`
-	Output string           `json:"output,omitempty"`
+	Status    HTTPHealthStatus             `json:"status"`
+	Version   string                       `json:"version,omitempty"`
+	ReleaseID string                       `json:"releaseId,omitempty"`
+	ServiceID string                       `json:"serviceId,omitempty"`
+	Notes     []string                     `json:"notes,omitempty"`
+	Output    string                       `json:"output,omitempty"`
+	Checks    map[string][]HTTPHealthCheck `json:"checks,omitempty"`
+}
+
+// HTTPHealthCheck is the status of a dependency, in HTTPHealthResponse.Checks.
+type HTTPHealthCheck struct {
+	ComponentType string           `json:"componentType,omitempty"`
+	ObservedValue any              `json:"observedValue,omitempty"`
+	ObservedUnit  string           `json:"observedUnit,omitempty"`
+	Status        HTTPHealthStatus `json:"status"`
+	Time          string           `json:"time,omitempty"` // RFC 3339
+	Output        string           `json:"output,omitempty"`
 }
 
 const (
@@ -83,17 +97,19 @@ func listen(cfg *ListenConfig, logger *slog.Logger) (net.Listener, error) {
 }
 
 func (r *HTTPHealthResponse) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
-	b, _ := json.Marshal(r)
-
-	w.Header().Set("Content-Type", "application/health+json")
-	w.Header().Set("Cache-Control", "max-age=60")
-
 	switch r.Status {
 	case HTTPHealthStatusFail:
-		w.WriteHeader(http.StatusServiceUnavailable)
+		r.write(w, http.StatusServiceUnavailable)
 	default:
-		w.WriteHeader(http.StatusOK)
+		r.write(w, http.StatusOK)
 	}
+}
+
+func (r *HTTPHealthResponse) write(w http.ResponseWriter, code int) {
+	b, _ := json.Marshal(r)
 
+	w.Header().Set("Content-Type", "application/health+json")
+	w.Header().Set("Cache-Control", "max-age=60")
+	w.WriteHeader(code)
 	_, _ = w.Write(b)
 }
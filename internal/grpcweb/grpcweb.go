@@ -0,0 +1,238 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package grpcweb translates GRPC-Web requests, in binary and text modes, to GRPC
+// requests for a handler such as grpc.Server.ServeHTTP().
+package grpcweb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	contentTypeGRPC     = "application/grpc"
+	contentTypeGRPCWeb  = "application/grpc-web"
+	contentTypeGRPCText = "application/grpc-web-text"
+
+	// Marks a trailer frame in the response body
+	trailerFlag = 0x80
+)
+
+var (
+	// Headers browsers must be allowed to read
+	exposedHeaders = "Grpc-Status, Grpc-Message, Grpc-Status-Details-Bin"
+
+	// The trailers grpc.Server declares
+	grpcTrailers = []string{"Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin"}
+)
+
+// NewHandler translates GRPC-Web requests to GRPC requests for handler. Cross-origin
+// requests are allowed from the given origins, "*" allowing any.
+func NewHandler(handler http.Handler, allowedOrigins []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && (slices.Contains(allowedOrigins, "*") || slices.Contains(allowedOrigins, origin))
+
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Methods", http.MethodPost)
+			w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		ct := r.Header.Get("Content-Type")
+		text := strings.HasPrefix(ct, contentTypeGRPCText)
+		if !text && !strings.HasPrefix(ct, contentTypeGRPCWeb) {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		if allowed {
+			w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
+		}
+
+		rw := newResponseWriter(w, text)
+		handler.ServeHTTP(rw, toGRPCRequest(r, text))
+		rw.finish()
+	})
+}
+
+// toGRPCRequest makes a GRPC-Web request look like a GRPC request.
+func toGRPCRequest(r *http.Request, text bool) *http.Request {
+	r = r.Clone(r.Context())
+
+	prefix := contentTypeGRPCWeb
+	if text {
+		prefix = contentTypeGRPCText
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{base64.NewDecoder(base64.StdEncoding, r.Body), r.Body}
+	}
+
+	r.Header.Set("Content-Type", contentTypeGRPC+strings.TrimPrefix(r.Header.Get("Content-Type"), prefix))
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+
+	// GRPC requires HTTP/2, though nothing here depends on it.
+	r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/2.0", 2, 0
+	return r
+}
+
+// responseWriter writes a GRPC response as GRPC-Web, sending trailers in the body.
+type responseWriter struct {
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	header http.Header
+	text   bool
+
+	// Set once the headers are written
+	wroteHeader bool
+	status      int
+	body        io.Writer
+	encoder     io.WriteCloser
+	trailers    []string
+}
+
+func newResponseWriter(w http.ResponseWriter, text bool) *responseWriter {
+	return &responseWriter{
+		w:      w,
+		rc:     http.NewResponseController(w),
+		header: http.Header{},
+		text:   text,
+	}
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+
+	w.wroteHeader = true
+	w.status = status
+
+	h := w.w.Header()
+	for k, v := range w.header {
+		switch k {
+		case "Trailer":
+			for _, t := range v {
+				w.trailers = append(w.trailers, http.CanonicalHeaderKey(t))
+			}
+		case "Content-Type":
+			prefix := contentTypeGRPCWeb
+			if w.text {
+				prefix = contentTypeGRPCText
+			}
+
+			if ct, ok := strings.CutPrefix(v[0], contentTypeGRPC); ok {
+				h.Set(k, prefix+ct)
+			} else {
+				h[k] = v
+			}
+		default:
+			h[k] = v
+		}
+	}
+
+	// Errors before reaching GRPC are plain HTTP responses.
+	w.body = w.w
+	if w.text && status == http.StatusOK {
+		w.encoder = base64.NewEncoder(base64.StdEncoding, w.w)
+		w.body = w.encoder
+	}
+
+	w.w.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+// Flush writes what has been buffered. In text mode, this ends the base64 segment.
+func (w *responseWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+
+	if w.encoder != nil {
+		_ = w.encoder.Close()
+		w.encoder = base64.NewEncoder(base64.StdEncoding, w.w)
+		w.body = w.encoder
+	}
+
+	_ = w.rc.Flush()
+}
+
+// finish writes the trailers, being the declared ones and any with http.TrailerPrefix.
+// Nothing is written if the request was rejected before reaching GRPC.
+func (w *responseWriter) finish() {
+	w.WriteHeader(http.StatusOK)
+	if w.status != http.StatusOK {
+		return
+	}
+
+	var block bytes.Buffer
+	writeTrailer := func(k string, v []string) {
+		for _, vv := range v {
+			block.WriteString(strings.ToLower(k) + ": " + vv + "\r\n")
+		}
+	}
+
+	for _, k := range slices.Concat(w.trailers, grpcTrailers) {
+		if v, ok := w.header[k]; ok {
+			writeTrailer(k, v)
+			delete(w.header, k)
+		}
+	}
+
+	for k, v := range w.header {
+		if t, ok := strings.CutPrefix(k, http.TrailerPrefix); ok {
+			writeTrailer(t, v)
+		}
+	}
+
+	frame := make([]byte, 5, 5+block.Len())
+	frame[0] = trailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(block.Len()))
+	frame = append(frame, block.Bytes()...)
+
+	_, _ = w.body.Write(frame)
+	w.Flush()
+}
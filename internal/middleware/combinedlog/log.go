@@ -91,6 +91,10 @@ func (r *combinedLogRecord) WriteHeader(status int) {
 	r.ResponseWriter.WriteHeader(status)
 }
 
+func (r *combinedLogRecord) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 type combinedLoggingHandler struct {
 	handler http.Handler
 	logger  *slog.Logger
@@ -0,0 +1,119 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PanicFunc is called with each recovered panic. If it returns true, the panic
+// is re-raised such that the process crashes.
+type PanicFunc func(ctx context.Context, protocol string, value any, stack []byte) bool
+
+// crash re-raises a panic on a new goroutine, so it can't be recovered by net/http.
+func crash(value any, stack []byte) {
+	go func() {
+		panic(fmt.Sprintf("%v\n\noriginal stack:\n%s", value, stack))
+	}()
+
+	select {}
+}
+
+type recoveryWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *recoveryWriter) WriteHeader(status int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recoveryWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recoveryWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// NewHandler recovers from panics, responding with a 500 if nothing has been written.
+func NewHandler(handler http.Handler, onPanic PanicFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := &recoveryWriter{ResponseWriter: w}
+
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+
+			// Used by net/http to abort a response, not an actual panic.
+			if err, ok := v.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(v)
+			}
+
+			stack := debug.Stack()
+			if onPanic(r.Context(), "http", v, stack) {
+				crash(v, stack)
+			}
+
+			if !ww.wroteHeader {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}()
+
+		handler.ServeHTTP(ww, r)
+	})
+}
+
+func recoverGRPC(ctx context.Context, onPanic PanicFunc, err *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+
+	stack := debug.Stack()
+	if onPanic(ctx, "grpc", v, stack) {
+		crash(v, stack)
+	}
+
+	*err = status.Error(codes.Internal, "internal error")
+}
+
+// UnaryServerInterceptor recovers from panics, returning codes.Internal.
+func UnaryServerInterceptor(onPanic PanicFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (_ interface{}, err error) {
+		defer recoverGRPC(ctx, onPanic, &err)
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor recovers from panics, returning codes.Internal.
+func StreamServerInterceptor(onPanic PanicFunc) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer recoverGRPC(ss.Context(), onPanic, &err)
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requestid
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Generator generates a new request ID.
+type Generator func() string
+
+// NewUUIDv4 generates a random UUID.
+func NewUUIDv4() string {
+	return uuid.New().String()
+}
+
+// NewUUIDv7 generates a time-ordered UUID.
+func NewUUIDv7() string {
+	return uuid.Must(uuid.NewV7()).String()
+}
+
+// NewULID generates a ULID: a millisecond timestamp and 80 random bits, in
+// Crockford's base32. See https://github.com/ulid/spec.
+func NewULID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[6:]); err != nil {
+		panic(err)
+	}
+
+	return encodeULID(uint64(time.Now().UnixMilli()), b)
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// encodeULID encodes the timestamp and random bytes of b, ignoring the first 6.
+func encodeULID(ms uint64, b [16]byte) string {
+	// The timestamp is the first 48 bits, big-endian.
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], ms)
+	copy(b[:6], ts[2:])
+
+	// 26 characters of 5 bits is 130 bits, so the first has 2 bits of padding.
+	var out [26]byte
+	for i := range out {
+		var v byte
+		for bit := i*5 - 2; bit < i*5+3; bit++ {
+			v <<= 1
+			if bit >= 0 {
+				v |= b[bit/8] >> (7 - bit%8) & 1
+			}
+		}
+
+		out[i] = crockford[v]
+	}
+
+	return string(out[:])
+}
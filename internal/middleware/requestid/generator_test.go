@@ -0,0 +1,38 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requestid
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeULID(t *testing.T) {
+	var b [16]byte
+	assert.Equal(t, "00000000000000000000000000", encodeULID(0, b))
+
+	// From the spec, the maximum timestamp.
+	assert.Equal(t, "7ZZZZZZZZZ0000000000000000", encodeULID(1<<48-1, b))
+
+	for i := 6; i < len(b); i++ {
+		b[i] = 0xff
+	}
+	assert.Equal(t, "01ARYZ6S41ZZZZZZZZZZZZZZZZ", encodeULID(1469918176385, b))
+
+	id := NewULID()
+	assert.Len(t, id, 26)
+	assert.NotEqual(t, id, NewULID())
+}
@@ -17,47 +17,116 @@ package requestid
 import (
 	"context"
 	"net/http"
+	"strings"
 
-	"github.com/google/uuid"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 )
 
 const (
-	HeaderName      = "X-Request-ID"
-	ContextKey      = "servicebase_request_id"
-	GRPCMetadataKey = ContextKey
+	DefaultHeaderName = "X-Request-ID"
+	DefaultMaxLength  = 128
+
+	// DefaultMetadataKey is kept from earlier versions, so their services still
+	// receive the IDs passed on.
+	DefaultMetadataKey = "servicebase_request_id"
+
+	ContextKey = "servicebase_request_id"
 )
 
+// Options configures where request IDs are read from and returned in, and how new
+// ones are generated.
+type Options struct {
+	HeaderName  string
+	MetadataKey string
+
+	// Aliases are also accepted from the headers and metadata, after HeaderName
+	// and MetadataKey.
+	Aliases []string
+
+	// MaxLength is the longest ID accepted. Longer IDs, or those with characters other
+	// than letters, digits and "-_.:+/=", are replaced with new ones.
+	MaxLength int
+
+	Generator Generator
+}
+
+// DefaultOptions returns the default options, accepting X-Correlation-ID and the
+// x-request-id metadata key as well, and generating UUIDv4s.
+func DefaultOptions() *Options {
+	return &Options{
+		HeaderName:  DefaultHeaderName,
+		MetadataKey: DefaultMetadataKey,
+		Aliases:     []string{"X-Correlation-ID", DefaultHeaderName},
+		MaxLength:   DefaultMaxLength,
+		Generator:   NewUUIDv4,
+	}
+}
+
+func validChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+
+	return strings.IndexByte("-_.:+/=", c) >= 0
+}
+
+// sanitize trims the ID, returning false if it's invalid.
+func (o *Options) sanitize(id string) (string, bool) {
+	id = strings.TrimSpace(id)
+	if id == "" || len(id) > o.MaxLength {
+		return "", false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if !validChar(id[i]) {
+			return "", false
+		}
+	}
+
+	return id, true
+}
+
+// find returns the first valid ID, or a new one if there are none.
+func (o *Options) find(names []string, get func(name string) string) string {
+	for _, name := range names {
+		if id, ok := o.sanitize(get(name)); ok {
+			return id
+		}
+	}
+
+	return o.Generator()
+}
+
 type requestIDHandler struct {
 	handler http.Handler
+	opts    *Options
+	names   []string
 }
 
-func NewHandler(handler http.Handler) http.Handler {
+func NewHandler(handler http.Handler, opts *Options) http.Handler {
 	return &requestIDHandler{
 		handler: handler,
+		opts:    opts,
+		names:   append([]string{opts.HeaderName}, opts.Aliases...),
 	}
 }
 
-func newID() string {
-	return uuid.New().String()
-}
-
 func (h *requestIDHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// If the user's supplied one use it, otherwise generate one.
-	requestID := r.Header.Get(HeaderName)
-	if requestID == "" {
-		r.Header.Clone()
-		requestID = newID()
-	}
+	// If the user's supplied a valid one use it, otherwise generate one.
+	requestID := h.opts.find(h.names, r.Header.Get)
 
-	ctx := context.WithValue(r.Context(), ContextKey, requestID)
+	// Set before serving, so it's returned however the response is written.
+	w.Header().Set(h.opts.HeaderName, requestID)
 
-	ww := requestIDWriter{
-		ResponseWriter: w,
-		requestID:      requestID,
-	}
-	h.handler.ServeHTTP(&ww, r.WithContext(ctx))
+	r = r.WithContext(context.WithValue(r.Context(), ContextKey, requestID))
+
+	// The headers are shared with the original request.
+	r.Header = r.Header.Clone()
+	r.Header.Set(h.opts.HeaderName, requestID)
+
+	h.handler.ServeHTTP(w, r)
 }
 
 func FromContext(ctx context.Context) string {
@@ -67,39 +136,50 @@ func FromContext(ctx context.Context) string {
 	return ""
 }
 
-type requestIDWriter struct {
-	http.ResponseWriter
-	requestID string
-}
+func (o *Options) metadataKeys() []string {
+	keys := make([]string, 0, 1+len(o.Aliases))
+	keys = append(keys, o.MetadataKey)
+	for _, alias := range o.Aliases {
+		keys = append(keys, strings.ToLower(alias))
+	}
 
-func (r *requestIDWriter) WriteHeader(status int) {
-	r.Header().Set(HeaderName, r.requestID)
-	r.ResponseWriter.WriteHeader(status)
+	return keys
 }
 
-func injectRequestIDGRPC(ctx context.Context) context.Context {
-	inMeta, _ := metadata.FromIncomingContext(ctx)
-	if inMeta == nil {
+// injectRequestIDGRPC adds the request ID to the context, the incoming metadata and the
+// outgoing metadata, so it's passed on to any calls made with the context.
+func injectRequestIDGRPC(ctx context.Context, opts *Options, keys []string) (context.Context, string) {
+	inMeta, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
 		inMeta = metadata.MD{}
-		ctx = metadata.NewIncomingContext(ctx, inMeta)
 	}
 
-	var requestID string
-	vals := inMeta.Get(GRPCMetadataKey)
-	if len(vals) > 0 {
-		requestID = vals[0]
-	} else {
-		requestID = newID()
-		inMeta.Set(GRPCMetadataKey, requestID)
-	}
+	requestID := opts.find(keys, func(key string) string {
+		if vals := inMeta.Get(key); len(vals) > 0 {
+			return vals[0]
+		}
+		return ""
+	})
+
+	inMeta = inMeta.Copy()
+	inMeta.Set(opts.MetadataKey, requestID)
+	ctx = metadata.NewIncomingContext(ctx, inMeta)
 
 	ctx = context.WithValue(ctx, ContextKey, requestID)
 
-	return metadata.AppendToOutgoingContext(ctx, GRPCMetadataKey, requestID)
+	return metadata.AppendToOutgoingContext(ctx, opts.MetadataKey, requestID), requestID
 }
 
-func UnaryServerInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	return handler(injectRequestIDGRPC(ctx), req)
+// UnaryServerInterceptor adds the request ID to the context of each call, returning
+// it to the client in the response headers.
+func UnaryServerInterceptor(opts *Options) grpc.UnaryServerInterceptor {
+	keys := opts.metadataKeys()
+
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, requestID := injectRequestIDGRPC(ctx, opts, keys)
+		_ = grpc.SetHeader(ctx, metadata.Pairs(opts.MetadataKey, requestID))
+		return handler(ctx, req)
+	}
 }
 
 type wrappedStream struct {
@@ -111,6 +191,13 @@ func (s *wrappedStream) Context() context.Context {
 	return s.ctx
 }
 
-func StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-	return handler(srv, &wrappedStream{ServerStream: ss, ctx: injectRequestIDGRPC(ss.Context())})
+// StreamServerInterceptor is UnaryServerInterceptor, for streams.
+func StreamServerInterceptor(opts *Options) grpc.StreamServerInterceptor {
+	keys := opts.metadataKeys()
+
+	return func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, requestID := injectRequestIDGRPC(ss.Context(), opts, keys)
+		_ = ss.SetHeader(metadata.Pairs(opts.MetadataKey, requestID))
+		return handler(srv, &wrappedStream{ServerStream: ss, ctx: ctx})
+	}
 }
@@ -0,0 +1,60 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	TraceIDLoggerFieldName = "trace_id"
+	SpanIDLoggerFieldName  = "span_id"
+)
+
+type slogHandler struct {
+	slog.Handler
+}
+
+// Handle adds the IDs to the record, rather than with WithAttrs(), so any handlers
+// within that do the same aren't bypassed.
+func (h *slogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r = r.Clone()
+		r.AddAttrs(
+			slog.String(TraceIDLoggerFieldName, sc.TraceID().String()),
+			slog.String(SpanIDLoggerFieldName, sc.SpanID().String()),
+		)
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &slogHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	return &slogHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// NewLogHandler adds the trace and span IDs of the context to each record.
+func NewLogHandler(h slog.Handler) slog.Handler {
+	return &slogHandler{
+		Handler: h,
+	}
+}
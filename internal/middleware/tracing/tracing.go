@@ -0,0 +1,227 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RouteFunc names the route of a request, once it has been served. Requests without
+// a route are named by their method alone.
+type RouteFunc func(r *http.Request) string
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	// Ignore informational responses
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// NewHandler serves each request in a server span, continuing the caller's trace if
+// the propagator finds one in the headers. The span is named "{method} {route}".
+func NewHandler(handler http.Handler, tracer trace.Tracer, propagator propagation.TextMapPropagator, route RouteFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+
+		attrs := []trace.SpanStartOption{
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.URLScheme(scheme),
+				semconv.ServerAddress(r.Host),
+			),
+		}
+
+		if ua := r.UserAgent(); ua != "" {
+			attrs = append(attrs, trace.WithAttributes(semconv.UserAgentOriginal(ua)))
+		}
+
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			attrs = append(attrs, trace.WithAttributes(semconv.ClientAddress(host)))
+		}
+
+		ctx, span := tracer.Start(ctx, r.Method, attrs...)
+		defer span.End()
+
+		r = r.WithContext(ctx)
+		ww := &statusWriter{ResponseWriter: w}
+		handler.ServeHTTP(ww, r)
+
+		if name := route(r); name != "" {
+			span.SetName(r.Method + " " + name)
+			span.SetAttributes(semconv.HTTPRoute(name))
+		}
+
+		if ww.status == 0 {
+			ww.status = http.StatusOK
+		}
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(ww.status))
+
+		// Client errors aren't the server's fault.
+		if ww.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(ww.status))
+		}
+	})
+}
+
+// metadataCarrier adapts GRPC metadata for propagators.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if vals := metadata.MD(c).Get(key); len(vals) > 0 {
+		return vals[0]
+	}
+
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+// Extract returns the context of the caller's trace, if any, from GRPC metadata.
+func Extract(ctx context.Context, propagator propagation.TextMapPropagator) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return propagator.Extract(ctx, metadataCarrier(md))
+}
+
+// Inject replaces any trace context in the outgoing GRPC metadata with that of the
+// current span.
+func Inject(ctx context.Context, propagator propagation.TextMapPropagator) context.Context {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	propagator.Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+func startGRPCSpan(ctx context.Context, tracer trace.Tracer, propagator propagation.TextMapPropagator, fullMethod string) (context.Context, trace.Span) {
+	name := strings.TrimPrefix(fullMethod, "/")
+	service, method, _ := strings.Cut(name, "/")
+
+	return tracer.Start(Extract(ctx, propagator), name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.RPCSystemGRPC,
+			semconv.RPCService(service),
+			semconv.RPCMethod(method),
+		),
+	)
+}
+
+func endGRPCSpan(span trace.Span, err error) {
+	st, _ := status.FromError(err)
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(st.Code())))
+
+	// Those codes that are the server's fault, per the semantic conventions.
+	switch st.Code() {
+	case grpccodes.Unknown, grpccodes.DeadlineExceeded, grpccodes.Unimplemented,
+		grpccodes.Internal, grpccodes.Unavailable, grpccodes.DataLoss:
+		span.SetStatus(codes.Error, st.Message())
+	}
+
+	span.End()
+}
+
+// UnaryServerInterceptor serves each call in a server span named after the method,
+// continuing the caller's trace if the propagator finds one in the metadata.
+func UnaryServerInterceptor(tracer trace.Tracer, propagator propagation.TextMapPropagator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, span := startGRPCSpan(ctx, tracer, propagator, info.FullMethod)
+		resp, err := handler(ctx, req)
+		endGRPCSpan(span, err)
+		return resp, err
+	}
+}
+
+type wrappedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *wrappedStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor, for streams.
+func StreamServerInterceptor(tracer trace.Tracer, propagator propagation.TextMapPropagator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := startGRPCSpan(ss.Context(), tracer, propagator, info.FullMethod)
+		err := handler(srv, &wrappedStream{ServerStream: ss, ctx: ctx})
+		endGRPCSpan(span, err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor propagates the trace of the call's context, as it is when
+// the call is made.
+func UnaryClientInterceptor(propagator propagation.TextMapPropagator) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(Inject(ctx, propagator), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor is UnaryClientInterceptor, for streams.
+func StreamClientInterceptor(propagator propagation.TextMapPropagator) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(Inject(ctx, propagator), desc, cc, method, opts...)
+	}
+}
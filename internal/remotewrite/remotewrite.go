@@ -0,0 +1,263 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package remotewrite encodes metrics as Prometheus remote-write 1.0 requests.
+package remotewrite
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The fields of prometheus.WriteRequest and the messages within. They're encoded by
+// hand so we needn't depend on github.com/prometheus/prometheus.
+const (
+	writeRequestTimeseries = 1
+
+	timeSeriesLabels  = 1
+	timeSeriesSamples = 2
+
+	labelName  = 1
+	labelValue = 2
+
+	sampleValue     = 1
+	sampleTimestamp = 2
+)
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Sample struct {
+	Value     float64
+	Timestamp int64 // Milliseconds since the epoch
+}
+
+type TimeSeries struct {
+	Labels  []Label // Sorted by name
+	Samples []Sample
+}
+
+// Label returns the value of a label, or "" if it's absent.
+func (ts *TimeSeries) Label(name string) string {
+	for _, l := range ts.Labels {
+		if l.Name == name {
+			return l.Value
+		}
+	}
+
+	return ""
+}
+
+// FromMetricFamilies flattens gathered metrics into series, as Prometheus would when
+// scraping them. Histograms and summaries become their _bucket, _sum and _count series.
+// External labels are added to each series, unless it already has them. Metrics without
+// a timestamp are given now.
+func FromMetricFamilies(mfs []*dto.MetricFamily, external map[string]string, now time.Time) []TimeSeries {
+	var series []TimeSeries
+
+	for _, mf := range mfs {
+		name := mf.GetName()
+
+		for _, m := range mf.GetMetric() {
+			ts := now.UnixMilli()
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+
+			add := func(name string, value float64, extra ...Label) {
+				labels := make([]Label, 0, len(m.GetLabel())+len(external)+len(extra)+1)
+				labels = append(labels, Label{Name: "__name__", Value: name})
+				for _, lp := range m.GetLabel() {
+					labels = append(labels, Label{Name: lp.GetName(), Value: lp.GetValue()})
+				}
+				labels = append(labels, extra...)
+
+				for k, v := range external {
+					if !slices.ContainsFunc(labels, func(l Label) bool { return l.Name == k }) {
+						labels = append(labels, Label{Name: k, Value: v})
+					}
+				}
+
+				slices.SortFunc(labels, func(a, b Label) int { return strings.Compare(a.Name, b.Name) })
+				series = append(series, TimeSeries{
+					Labels:  labels,
+					Samples: []Sample{{Value: value, Timestamp: ts}},
+				})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add(name, q.GetValue(), Label{Name: "quantile", Value: formatFloat(q.GetQuantile())})
+				}
+				add(name+"_sum", s.GetSampleSum())
+				add(name+"_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				// Native histograms are sent as their classic buckets, if any.
+				h := m.GetHistogram()
+				hasInf := false
+				for _, b := range h.GetBucket() {
+					hasInf = hasInf || math.IsInf(b.GetUpperBound(), +1)
+					add(name+"_bucket", float64(b.GetCumulativeCount()), Label{Name: "le", Value: formatFloat(b.GetUpperBound())})
+				}
+
+				if !hasInf {
+					add(name+"_bucket", float64(h.GetSampleCount()), Label{Name: "le", Value: "+Inf"})
+				}
+				add(name+"_sum", h.GetSampleSum())
+				add(name+"_count", float64(h.GetSampleCount()))
+			}
+		}
+	}
+
+	return series
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, +1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+// Marshal encodes a prometheus.WriteRequest. It must be snappy-compressed before sending.
+func Marshal(series []TimeSeries) []byte {
+	var b []byte
+	for _, ts := range series {
+		var tsb []byte
+		for _, l := range ts.Labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, labelName, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.Name)
+			lb = protowire.AppendTag(lb, labelValue, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.Value)
+
+			tsb = protowire.AppendTag(tsb, timeSeriesLabels, protowire.BytesType)
+			tsb = protowire.AppendBytes(tsb, lb)
+		}
+
+		for _, s := range ts.Samples {
+			var sb []byte
+			sb = protowire.AppendTag(sb, sampleValue, protowire.Fixed64Type)
+			sb = protowire.AppendFixed64(sb, math.Float64bits(s.Value))
+			sb = protowire.AppendTag(sb, sampleTimestamp, protowire.VarintType)
+			sb = protowire.AppendVarint(sb, uint64(s.Timestamp))
+
+			tsb = protowire.AppendTag(tsb, timeSeriesSamples, protowire.BytesType)
+			tsb = protowire.AppendBytes(tsb, sb)
+		}
+
+		b = protowire.AppendTag(b, writeRequestTimeseries, protowire.BytesType)
+		b = protowire.AppendBytes(b, tsb)
+	}
+
+	return b
+}
+
+// Unmarshal decodes the series of a prometheus.WriteRequest, ignoring any other fields.
+func Unmarshal(b []byte) ([]TimeSeries, error) {
+	var series []TimeSeries
+
+	err := forEachField(b, func(num protowire.Number, _ protowire.Type, value []byte) error {
+		if num != writeRequestTimeseries {
+			return nil
+		}
+
+		var ts TimeSeries
+		err := forEachField(value, func(num protowire.Number, _ protowire.Type, value []byte) error {
+			switch num {
+			case timeSeriesLabels:
+				ts.Labels = append(ts.Labels, Label{})
+				return forEachField(value, func(num protowire.Number, _ protowire.Type, value []byte) error {
+					switch num {
+					case labelName:
+						ts.Labels[len(ts.Labels)-1].Name = string(value)
+					case labelValue:
+						ts.Labels[len(ts.Labels)-1].Value = string(value)
+					}
+					return nil
+				})
+			case timeSeriesSamples:
+				var s Sample
+				err := forEachField(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+					switch {
+					case num == sampleValue && typ == protowire.Fixed64Type:
+						v, _ := protowire.ConsumeFixed64(value)
+						s.Value = math.Float64frombits(v)
+					case num == sampleTimestamp && typ == protowire.VarintType:
+						v, _ := protowire.ConsumeVarint(value)
+						s.Timestamp = int64(v)
+					}
+					return nil
+				})
+				ts.Samples = append(ts.Samples, s)
+				return err
+			}
+			return nil
+		})
+
+		series = append(series, ts)
+		return err
+	})
+
+	return series, err
+}
+
+// forEachField calls fn with each field of an encoded message. Length-delimited values
+// are unwrapped, others are passed as encoded.
+func forEachField(b []byte, fn func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+
+		value := b[:n]
+		if typ == protowire.BytesType {
+			value, _ = protowire.ConsumeBytes(value)
+		}
+		b = b[n:]
+
+		if err := fn(num, typ, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	hist := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "test_duration_seconds",
+		Buckets: []float64{0.1, 1},
+	}, []string{"job"})
+	require.NoError(t, reg.Register(hist))
+	hist.WithLabelValues("own").Observe(0.5)
+
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{
+		Name:       "test_size_bytes",
+		Objectives: map[float64]float64{0.5: 0.05},
+	})
+	require.NoError(t, reg.Register(summary))
+	summary.Observe(10)
+
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+
+	now := time.UnixMilli(1700000000000)
+	series, err := Unmarshal(Marshal(FromMetricFamilies(mfs, map[string]string{"job": "ext", "instance": "a"}, now)))
+	require.NoError(t, err)
+
+	values := map[string]float64{}
+	for _, ts := range series {
+		assert.IsIncreasing(t, func() []string {
+			var names []string
+			for _, l := range ts.Labels {
+				names = append(names, l.Name)
+			}
+			return names
+		}())
+		assert.Equal(t, "a", ts.Label("instance"))
+		require.Len(t, ts.Samples, 1)
+		assert.Equal(t, now.UnixMilli(), ts.Samples[0].Timestamp)
+
+		key := ts.Label("__name__") + "{" + ts.Label("job") + "," + ts.Label("le") + ts.Label("quantile") + "}"
+		values[key] = ts.Samples[0].Value
+	}
+
+	assert.Equal(t, map[string]float64{
+		"test_duration_seconds_bucket{own,0.1}":  0,
+		"test_duration_seconds_bucket{own,1}":    1,
+		"test_duration_seconds_bucket{own,+Inf}": 1,
+		"test_duration_seconds_sum{own,}":        0.5,
+		"test_duration_seconds_count{own,}":      1,
+		"test_size_bytes{ext,0.5}":               10,
+		"test_size_bytes_sum{ext,}":              10,
+		"test_size_bytes_count{ext,}":            1,
+	}, values)
+}
@@ -0,0 +1,144 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package systemd implements the parts of the systemd service protocol we care about:
+// socket activation (sd_listen_fds) and notifications (sd_notify).
+package systemd
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ListenFDsStart is the first file descriptor passed by systemd.
+	ListenFDsStart = 3
+
+	StateReady     = "READY=1"
+	StateReloading = "RELOADING=1"
+	StateStopping  = "STOPPING=1"
+	StateWatchdog  = "WATCHDOG=1"
+)
+
+// Files returns the files passed via socket activation. Each file's Name() is
+// its name from LISTEN_FDNAMES, or "unknown" if unnamed. If unsetEnv is set, the
+// environment variables are cleared so they aren't inherited by children.
+func Files(unsetEnv bool) []*os.File {
+	if unsetEnv {
+		defer func() {
+			_ = os.Unsetenv("LISTEN_PID")
+			_ = os.Unsetenv("LISTEN_FDS")
+			_ = os.Unsetenv("LISTEN_FDNAMES")
+		}()
+	}
+
+	return ParseFiles(
+		os.Getenv("LISTEN_PID"),
+		os.Getenv("LISTEN_FDS"),
+		os.Getenv("LISTEN_FDNAMES"),
+		ListenFDsStart,
+	)
+}
+
+// ParseFiles interprets LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES, with the
+// descriptors starting at start. An empty pid skips the pid check.
+func ParseFiles(pid, fds, names string, start int) []*os.File {
+	if pid != "" {
+		if p, err := strconv.Atoi(pid); err != nil || p != os.Getpid() {
+			return nil
+		}
+	}
+
+	n, err := strconv.Atoi(fds)
+	if err != nil || n <= 0 {
+		return nil
+	}
+
+	var fdNames []string
+	if names != "" {
+		fdNames = strings.Split(names, ":")
+	}
+
+	files := make([]*os.File, 0, n)
+	for i := 0; i < n; i++ {
+		fd := start + i
+		closeOnExec(fd)
+
+		name := "unknown"
+		if i < len(fdNames) && fdNames[i] != "" {
+			name = fdNames[i]
+		}
+
+		files = append(files, os.NewFile(uintptr(fd), name))
+	}
+
+	return files
+}
+
+// Notify sends a notification to the service manager. The returned bool is
+// false if notifications aren't supported, i.e. NOTIFY_SOCKET is unset.
+func Notify(state ...string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err := conn.Write([]byte(strings.Join(state, "\n"))); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Status formats a STATUS= notification.
+func Status(status string) string {
+	return "STATUS=" + status
+}
+
+// MainPID formats a MAINPID= notification.
+func MainPID(pid int) string {
+	return "MAINPID=" + strconv.Itoa(pid)
+}
+
+// WatchdogInterval returns the watchdog interval, if enabled for this process.
+// Pings should be sent at least twice per interval.
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" {
+		if p, err := strconv.Atoi(pid); err != nil || p != os.Getpid() {
+			return 0, nil
+		}
+	}
+
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, errors.New("invalid WATCHDOG_USEC")
+	}
+
+	return time.Duration(n) * time.Microsecond, nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build unix
+
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", "")
+	ok, err := Notify(StateReady)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	ok, err = Notify(StateReady, Status("serving"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	buf := make([]byte, 1024)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "READY=1\nSTATUS=serving", string(buf[:n]))
+}
+
+// dupListener returns a raw descriptor for the listener's socket, as if inherited.
+func dupListener(t *testing.T, lis net.Listener) int {
+	f, err := lis.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer f.Close()
+
+	fd, err := syscall.Dup(int(f.Fd()))
+	require.NoError(t, err)
+	return fd
+}
+
+func TestParseFiles(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+
+	fd := dupListener(t, lis)
+
+	// Wrong pid
+	assert.Empty(t, ParseFiles(strconv.Itoa(os.Getpid()+1), "1", "http", fd))
+
+	files := ParseFiles(strconv.Itoa(os.Getpid()), "1", "http", fd)
+	require.Len(t, files, 1)
+	assert.Equal(t, "http", files[0].Name())
+
+	inherited, err := net.FileListener(files[0])
+	require.NoError(t, err)
+	defer inherited.Close()
+
+	assert.Equal(t, lis.Addr().String(), inherited.Addr().String())
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	interval, err := WatchdogInterval()
+	require.NoError(t, err)
+	assert.Zero(t, interval)
+
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	interval, err = WatchdogInterval()
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, interval)
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	interval, err = WatchdogInterval()
+	require.NoError(t, err)
+	assert.Zero(t, interval)
+}
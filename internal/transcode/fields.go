@@ -0,0 +1,296 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transcode
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// The google.api.http method option, and the fields of google.api.HttpRule. They're
+// decoded by hand so services needn't link google.golang.org/genproto/googleapis/api.
+const (
+	httpRuleExtension = 72295728
+
+	httpRuleGet                = 2
+	httpRulePut                = 3
+	httpRulePost               = 4
+	httpRuleDelete             = 5
+	httpRulePatch              = 6
+	httpRuleBody               = 7
+	httpRuleCustom             = 8
+	httpRuleAdditionalBindings = 11
+	httpRuleResponseBody       = 12
+
+	customPatternKind = 1
+	customPatternPath = 2
+)
+
+type httpRule struct {
+	method       string
+	path         string
+	body         string
+	responseBody string
+}
+
+// httpRules returns the google.api.http bindings of a method, if any.
+func httpRules(md protoreflect.MethodDescriptor) ([]httpRule, error) {
+	opts, err := proto.Marshal(md.Options())
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []httpRule
+	err = forEachField(opts, func(num protowire.Number, value []byte) error {
+		if num != httpRuleExtension {
+			return nil
+		}
+
+		r, err := parseHTTPRule(value, true)
+		rules = append(rules, r...)
+		return err
+	})
+
+	return rules, err
+}
+
+// parseHTTPRule decodes a google.api.HttpRule, and its additional bindings if top-level.
+func parseHTTPRule(b []byte, topLevel bool) ([]httpRule, error) {
+	var rule httpRule
+	var additional []httpRule
+
+	err := forEachField(b, func(num protowire.Number, value []byte) error {
+		methods := map[protowire.Number]string{
+			httpRuleGet:    http.MethodGet,
+			httpRulePut:    http.MethodPut,
+			httpRulePost:   http.MethodPost,
+			httpRuleDelete: http.MethodDelete,
+			httpRulePatch:  http.MethodPatch,
+		}
+
+		switch num {
+		case httpRuleGet, httpRulePut, httpRulePost, httpRuleDelete, httpRulePatch:
+			rule.method, rule.path = methods[num], string(value)
+		case httpRuleCustom:
+			return forEachField(value, func(num protowire.Number, value []byte) error {
+				switch num {
+				case customPatternKind:
+					rule.method = string(value)
+				case customPatternPath:
+					rule.path = string(value)
+				}
+				return nil
+			})
+		case httpRuleBody:
+			rule.body = string(value)
+		case httpRuleResponseBody:
+			rule.responseBody = string(value)
+		case httpRuleAdditionalBindings:
+			if !topLevel {
+				return errors.New("nested additional bindings")
+			}
+
+			r, err := parseHTTPRule(value, false)
+			additional = append(additional, r...)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if rule.method == "" || rule.path == "" {
+		return nil, errors.New("http rule without a method or path")
+	}
+
+	return append([]httpRule{rule}, additional...), nil
+}
+
+// forEachField calls fn with each length-delimited field of an encoded message.
+func forEachField(b []byte, fn func(num protowire.Number, value []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+
+		value, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := fn(num, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findField resolves a dotted path of field names, or JSON names, within a message.
+func findField(md protoreflect.MessageDescriptor, path string) (protoreflect.FieldDescriptor, error) {
+	var fd protoreflect.FieldDescriptor
+
+	for i, name := range strings.Split(path, ".") {
+		if i > 0 {
+			if fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+				return nil, fmt.Errorf("field %q is not a message", fd.Name())
+			}
+			md = fd.Message()
+		}
+
+		if fd = md.Fields().ByName(protoreflect.Name(name)); fd == nil {
+			if fd = md.Fields().ByJSONName(name); fd == nil {
+				return nil, fmt.Errorf("no field %q in %s", name, md.FullName())
+			}
+		}
+	}
+
+	return fd, nil
+}
+
+// parent returns the message containing the field at path, creating it if needed.
+func parent(msg protoreflect.Message, path string) (protoreflect.Message, protoreflect.FieldDescriptor, error) {
+	parts := strings.Split(path, ".")
+	for _, name := range parts[:len(parts)-1] {
+		fd, err := findField(msg.Descriptor(), name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+			return nil, nil, fmt.Errorf("field %q is not a message", name)
+		}
+
+		msg = msg.Mutable(fd).Message()
+	}
+
+	fd, err := findField(msg.Descriptor(), parts[len(parts)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return msg, fd, nil
+}
+
+// mutableField returns the field at path, which must have been validated.
+func mutableField(msg protoreflect.Message, path string) protoreflect.Value {
+	m, fd, _ := parent(msg, path)
+	return m.Mutable(fd)
+}
+
+// getField returns the field at path, which must have been validated.
+func getField(msg protoreflect.Message, path string) protoreflect.Value {
+	for _, name := range strings.Split(path, ".") {
+		fd, _ := findField(msg.Descriptor(), name)
+		if fd.Kind() != protoreflect.MessageKind {
+			return msg.Get(fd)
+		}
+
+		msg = msg.Get(fd).Message()
+	}
+
+	return protoreflect.ValueOfMessage(msg)
+}
+
+// setField parses a scalar from a path variable or query parameter. Repeated fields
+// are appended to.
+func setField(msg protoreflect.Message, path, s string) error {
+	m, fd, err := parent(msg, path)
+	if err != nil {
+		return err
+	}
+
+	if fd.IsMap() {
+		return fmt.Errorf("field %q is a map", path)
+	}
+
+	v, err := parseScalar(fd, s)
+	if err != nil {
+		return fmt.Errorf("field %q: %w", path, err)
+	}
+
+	if fd.IsList() {
+		m.Mutable(fd).List().Append(v)
+		return nil
+	}
+
+	m.Set(fd, v)
+	return nil
+}
+
+func parseScalar(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BytesKind:
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			b, err = base64.URLEncoding.DecodeString(s)
+		}
+		return protoreflect.ValueOfBytes(b), err
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(b), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		i, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(i)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		i, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(i), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		i, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(i)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		i, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(i), err
+	case protoreflect.FloatKind:
+		f, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(f)), err
+	case protoreflect.DoubleKind:
+		f, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(f), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+
+		i, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(i)), err
+	default:
+		return protoreflect.Value{}, fmt.Errorf("unsupported field type %s", fd.Kind())
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transcode
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type segment struct {
+	literal  string
+	wildcard string // "*" or "**", if not a literal
+	field    string // The field the segment binds to, if any
+}
+
+// pathTemplate is a google.api.http path template, such as
+// /v1/{name=shelves/*/books/*}:get. "**" may only be the last segment.
+type pathTemplate struct {
+	segments []segment
+	verb     string
+}
+
+func parseTemplate(tmpl string) (*pathTemplate, error) {
+	rest, ok := strings.CutPrefix(tmpl, "/")
+	if !ok {
+		return nil, fmt.Errorf("template must begin with /: %q", tmpl)
+	}
+
+	t := &pathTemplate{}
+
+	// The verb follows the last segment, which may be a variable.
+	if i := strings.LastIndexByte(rest, ':'); i >= 0 && !strings.ContainsAny(rest[i:], "/}") {
+		rest, t.verb = rest[:i], rest[i+1:]
+	}
+
+	for rest != "" {
+		var field, pattern string
+
+		if strings.HasPrefix(rest, "{") {
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated variable: %q", tmpl)
+			}
+
+			field, pattern, ok = strings.Cut(rest[1:end], "=")
+			if !ok {
+				pattern = "*"
+			}
+
+			rest = rest[end+1:]
+		} else {
+			pattern, rest, _ = strings.Cut(rest, "/")
+			rest = "/" + rest
+		}
+
+		for _, p := range strings.Split(pattern, "/") {
+			switch p {
+			case "":
+				return nil, fmt.Errorf("empty segment: %q", tmpl)
+			case "*", "**":
+				t.segments = append(t.segments, segment{wildcard: p, field: field})
+			default:
+				t.segments = append(t.segments, segment{literal: p, field: field})
+			}
+		}
+
+		switch {
+		case rest == "/":
+			rest = ""
+		case rest == "":
+		case strings.HasPrefix(rest, "/"):
+			rest = rest[1:]
+		default:
+			return nil, fmt.Errorf("invalid template: %q", tmpl)
+		}
+	}
+
+	for i, s := range t.segments {
+		if s.wildcard == "**" && i != len(t.segments)-1 {
+			return nil, fmt.Errorf("** must be the last segment: %q", tmpl)
+		}
+	}
+
+	return t, nil
+}
+
+// match matches an unescaped path against the template, returning the value of each variable.
+func (t *pathTemplate) match(path string) (map[string]string, bool) {
+	rest, ok := strings.CutPrefix(path, "/")
+	if !ok {
+		return nil, false
+	}
+
+	if t.verb != "" {
+		if rest, ok = strings.CutSuffix(rest, ":"+t.verb); !ok {
+			return nil, false
+		}
+	}
+
+	parts := strings.Split(rest, "/")
+	vars := map[string]string{}
+	bind := func(field string, values ...string) {
+		if field == "" {
+			return
+		}
+
+		if v, ok := vars[field]; ok {
+			values = append([]string{v}, values...)
+		}
+
+		vars[field] = strings.Join(values, "/")
+	}
+
+	for i, s := range t.segments {
+		if s.wildcard == "**" {
+			bind(s.field, parts[i:]...)
+			return vars, true
+		}
+
+		if i >= len(parts) || parts[i] == "" || (s.literal != "" && parts[i] != s.literal) {
+			return nil, false
+		}
+
+		bind(s.field, parts[i])
+	}
+
+	if len(parts) != len(t.segments) {
+		return nil, false
+	}
+
+	for k, v := range vars {
+		if vars[k], ok = unescape(v); !ok {
+			return nil, false
+		}
+	}
+
+	return vars, true
+}
+
+func unescape(s string) (string, bool) {
+	s, err := url.PathUnescape(s)
+	return s, err == nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transcode
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		path     string
+		vars     map[string]string
+	}{
+		{"/v1/shelves", "/v1/shelves", map[string]string{}},
+		{"/v1/shelves", "/v1/shelves/1", nil},
+		{"/v1/shelves/{shelf}", "/v1/shelves/1", map[string]string{"shelf": "1"}},
+		{"/v1/shelves/{shelf}", "/v1/shelves/a%2Fb", map[string]string{"shelf": "a/b"}},
+		{"/v1/shelves/{shelf}", "/v1/shelves/", nil},
+		{"/v1/{name=shelves/*/books/*}", "/v1/shelves/1/books/2", map[string]string{"name": "shelves/1/books/2"}},
+		{"/v1/{name=shelves/*/books/*}", "/v1/shelves/1/books", nil},
+		{"/v1/{shelf.id}/books/{book}", "/v1/1/books/2", map[string]string{"shelf.id": "1", "book": "2"}},
+		{"/v1/files/{path=**}", "/v1/files/a/b/c", map[string]string{"path": "a/b/c"}},
+		{"/v1/shelves/{shelf}:clear", "/v1/shelves/1:clear", map[string]string{"shelf": "1"}},
+		{"/v1/shelves/{shelf}:clear", "/v1/shelves/1", nil},
+	}
+
+	for _, tt := range tests {
+		tmpl, err := parseTemplate(tt.template)
+		require.NoError(t, err, tt.template)
+
+		vars, ok := tmpl.match(tt.path)
+		assert.Equal(t, tt.vars != nil, ok, "%s %s", tt.template, tt.path)
+		if tt.vars != nil {
+			assert.Equal(t, tt.vars, vars, "%s %s", tt.template, tt.path)
+		}
+	}
+
+	for _, invalid := range []string{"v1", "/v1/{name", "/v1/**/x", "/v1//x"} {
+		_, err := parseTemplate(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
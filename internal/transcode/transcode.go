@@ -0,0 +1,464 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package transcode serves unary GRPC methods as REST/JSON, honouring google.api.http
+// annotations. Requests are translated to GRPC requests for a handler such as
+// grpc.Server.ServeHTTP(), so the server's interceptors apply.
+package transcode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/multierr"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// maxRequestSize bounds the size of a request body.
+const maxRequestSize = 4 << 20
+
+type binding struct {
+	method       string // HTTP method
+	path         *pathTemplate
+	body         string // Request field the body is bound to, "*" for all, "" for none
+	responseBody string // Response field to respond with, "" for all
+
+	route    string // The path template, for instrumentation
+	grpcPath string // /package.Service/Method
+	input    protoreflect.MessageType
+	output   protoreflect.MessageType
+}
+
+// Transcoder serves REST/JSON requests for GRPC methods.
+type Transcoder struct {
+	handler  http.Handler
+	basePath string
+	prefix   string
+	bindings []*binding
+}
+
+// New creates a transcoder, forwarding requests to handler. Each method is served at
+// POST {basePath}{prefix}/{package.Service}/{Method}, and at {basePath}{path} for
+// each of its google.api.http bindings.
+func New(handler http.Handler, basePath, prefix string) *Transcoder {
+	return &Transcoder{
+		handler:  handler,
+		basePath: strings.TrimSuffix(basePath, "/"),
+		prefix:   strings.TrimSuffix(prefix, "/"),
+	}
+}
+
+// Register adds the unary methods of each service, as returned by grpc.Server.GetServiceInfo().
+// Services must have generated code, so their descriptors are in protoregistry.GlobalFiles.
+// It must be called before serving. Methods that can't be served are skipped, and returned as errors.
+func (t *Transcoder) Register(services map[string]grpc.ServiceInfo) error {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+
+		sd, ok := desc.(protoreflect.ServiceDescriptor)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: not a service", name))
+			continue
+		}
+
+		for _, mi := range services[name].Methods {
+			if mi.IsClientStream || mi.IsServerStream {
+				continue
+			}
+
+			md := sd.Methods().ByName(protoreflect.Name(mi.Name))
+			if md == nil {
+				errs = append(errs, fmt.Errorf("%s/%s: no descriptor", name, mi.Name))
+				continue
+			}
+
+			if err := t.register(md); err != nil {
+				errs = append(errs, fmt.Errorf("%s/%s: %w", name, mi.Name, err))
+			}
+		}
+	}
+
+	return multierr.Combine(errs...)
+}
+
+func (t *Transcoder) register(md protoreflect.MethodDescriptor) error {
+	input, err := protoregistry.GlobalTypes.FindMessageByName(md.Input().FullName())
+	if err != nil {
+		return err
+	}
+
+	output, err := protoregistry.GlobalTypes.FindMessageByName(md.Output().FullName())
+	if err != nil {
+		return err
+	}
+
+	grpcPath := fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name())
+
+	rules, err := httpRules(md)
+	if err != nil {
+		return err
+	}
+
+	rules = append(rules, httpRule{method: http.MethodPost, path: t.prefix + grpcPath, body: "*"})
+
+	for _, rule := range rules {
+		tmpl, err := parseTemplate(rule.path)
+		if err != nil {
+			return err
+		}
+
+		b := &binding{
+			method:       rule.method,
+			path:         tmpl,
+			body:         rule.body,
+			responseBody: rule.responseBody,
+			route:        t.basePath + rule.path,
+			grpcPath:     grpcPath,
+			input:        input,
+			output:       output,
+		}
+
+		if err := b.validate(); err != nil {
+			return err
+		}
+
+		t.bindings = append(t.bindings, b)
+	}
+
+	return nil
+}
+
+// validate checks that the fields a binding refers to can be bound.
+func (b *binding) validate() error {
+	for _, s := range b.path.segments {
+		if s.field == "" {
+			continue
+		}
+
+		fd, err := findField(b.input.Descriptor(), s.field)
+		if err != nil {
+			return err
+		}
+
+		if fd.Kind() == protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+			return fmt.Errorf("path variable %q must be a scalar field", s.field)
+		}
+	}
+
+	for field, md := range map[string]protoreflect.MessageDescriptor{b.body: b.input.Descriptor(), b.responseBody: b.output.Descriptor()} {
+		if field == "" || field == "*" {
+			continue
+		}
+
+		fd, err := findField(md, field)
+		if err != nil {
+			return err
+		}
+
+		if fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+			return fmt.Errorf("body field %q must be a message", field)
+		}
+	}
+
+	return nil
+}
+
+// match returns the binding for a request, if any, and the value of its path variables.
+func (t *Transcoder) match(r *http.Request) (*binding, map[string]string) {
+	p, ok := strings.CutPrefix(r.URL.EscapedPath(), t.basePath)
+	if !ok {
+		return nil, nil
+	}
+
+	for _, b := range t.bindings {
+		if b.method != r.Method {
+			continue
+		}
+
+		if vars, ok := b.path.match(p); ok {
+			return b, vars
+		}
+	}
+
+	return nil, nil
+}
+
+// Match returns true if the request is for a registered method.
+func (t *Transcoder) Match(r *http.Request) bool {
+	b, _ := t.match(r)
+	return b != nil
+}
+
+// Route returns the path template of the binding a request is for, or "" if there's none.
+func (t *Transcoder) Route(r *http.Request) string {
+	if b, _ := t.match(r); b != nil {
+		return b.route
+	}
+
+	return ""
+}
+
+func (t *Transcoder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, vars := t.match(r)
+	if b == nil {
+		writeError(w, &status.Status{Code: int32(codes.NotFound), Message: "not found"})
+		return
+	}
+
+	req, err := b.decode(r, vars)
+	if err != nil {
+		writeError(w, &status.Status{Code: int32(codes.InvalidArgument), Message: err.Error()})
+		return
+	}
+
+	payload, err := proto.Marshal(req.Interface())
+	if err != nil {
+		writeError(w, &status.Status{Code: int32(codes.Internal), Message: err.Error()})
+		return
+	}
+
+	rec := &recorder{header: http.Header{}}
+	t.handler.ServeHTTP(rec, toGRPCRequest(r, b.grpcPath, payload))
+
+	resp, st := b.decodeResponse(rec)
+	if st != nil {
+		writeError(w, st)
+		return
+	}
+
+	var msg proto.Message = resp.Interface()
+	if b.responseBody != "" {
+		msg = getField(resp, b.responseBody).Message().Interface()
+	}
+
+	data, err := protojson.Marshal(msg)
+	if err != nil {
+		writeError(w, &status.Status{Code: int32(codes.Internal), Message: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// decode builds the GRPC request from the body, path variables and query parameters.
+func (b *binding) decode(r *http.Request, vars map[string]string) (protoreflect.Message, error) {
+	msg := b.input.New()
+
+	if b.body != "" {
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize+1))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(data) > maxRequestSize {
+			return nil, errors.New("request body too large")
+		}
+
+		target := msg
+		if b.body != "*" {
+			target = mutableField(msg, b.body).Message()
+		}
+
+		if len(bytes.TrimSpace(data)) > 0 {
+			if err := protojson.Unmarshal(data, target.Interface()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for field, value := range vars {
+		if err := setField(msg, field, value); err != nil {
+			return nil, err
+		}
+	}
+
+	// Everything not bound to the body or path may be given as a query parameter.
+	if b.body != "*" {
+		for field, values := range r.URL.Query() {
+			if _, ok := vars[field]; ok {
+				continue
+			}
+
+			if b.body != "" && (field == b.body || strings.HasPrefix(field, b.body+".")) {
+				return nil, fmt.Errorf("query parameter %q is bound to the body", field)
+			}
+
+			for _, value := range values {
+				if err := setField(msg, field, value); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return msg, nil
+}
+
+// toGRPCRequest makes a GRPC request with the same headers and context.
+func toGRPCRequest(r *http.Request, grpcPath string, payload []byte) *http.Request {
+	frame := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	frame = append(frame, payload...)
+
+	greq := r.Clone(r.Context())
+	greq.Method = http.MethodPost
+	greq.URL = &url.URL{Path: grpcPath}
+	greq.RequestURI = grpcPath
+	greq.Body = io.NopCloser(bytes.NewReader(frame))
+	greq.ContentLength = int64(len(frame))
+	greq.Proto, greq.ProtoMajor, greq.ProtoMinor = "HTTP/2.0", 2, 0
+
+	for _, h := range []string{"Content-Length", "Content-Type", "Grpc-Encoding", "Grpc-Accept-Encoding", "Te"} {
+		greq.Header.Del(h)
+	}
+
+	greq.Header.Set("Content-Type", "application/grpc+proto")
+	return greq
+}
+
+// decodeResponse returns the response message, or the status upon failure.
+func (b *binding) decodeResponse(rec *recorder) (protoreflect.Message, *status.Status) {
+	if rec.status != http.StatusOK {
+		return nil, &status.Status{Code: int32(codes.Internal), Message: fmt.Sprintf("unexpected http status %d", rec.status)}
+	}
+
+	st := &status.Status{Code: int32(codes.Unknown)}
+	if code, err := strconv.Atoi(rec.header.Get("Grpc-Status")); err == nil {
+		st.Code = int32(code)
+	}
+
+	if msg, err := url.PathUnescape(rec.header.Get("Grpc-Message")); err == nil {
+		st.Message = msg
+	}
+
+	if details := rec.header.Get("Grpc-Status-Details-Bin"); details != "" {
+		if data, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(details, "=")); err == nil {
+			full := &status.Status{}
+			if proto.Unmarshal(data, full) == nil {
+				st.Details = full.Details
+			}
+		}
+	}
+
+	if st.Code != int32(codes.OK) {
+		return nil, st
+	}
+
+	body := rec.body.Bytes()
+	if len(body) < 5 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 || body[0] != 0 {
+		return nil, &status.Status{Code: int32(codes.Internal), Message: "malformed response"}
+	}
+
+	msg := b.output.New()
+	if err := proto.Unmarshal(body[5:], msg.Interface()); err != nil {
+		return nil, &status.Status{Code: int32(codes.Internal), Message: err.Error()}
+	}
+
+	return msg, nil
+}
+
+func writeError(w http.ResponseWriter, st *status.Status) {
+	data, err := protojson.Marshal(st)
+	if err != nil {
+		data = []byte(`{"code":13,"message":"unable to marshal error"}`)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(HTTPStatusFromCode(codes.Code(st.Code)))
+	_, _ = w.Write(data)
+}
+
+// HTTPStatusFromCode maps a GRPC status code to an HTTP status code.
+func HTTPStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499 // Client Closed Request
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// recorder captures a GRPC response. Trailers end up in the header.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(b)
+}
+
+func (r *recorder) Flush() {
+	r.WriteHeader(http.StatusOK)
+}
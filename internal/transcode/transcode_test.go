@@ -0,0 +1,159 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transcode
+
+import (
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// annotatedMethod describes a method taking and returning the health messages, with
+// the given google.api.http rule.
+func annotatedMethod(t *testing.T, rule []byte) *Transcoder {
+	t.Helper()
+
+	opts := &descriptorpb.MethodOptions{}
+	opts.ProtoReflect().SetUnknown(protowire.AppendBytes(protowire.AppendTag(nil, httpRuleExtension, protowire.BytesType), rule))
+
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("transcode_test.proto"),
+		Package:    proto.String("transcode.test"),
+		Dependency: []string{"grpc/health/v1/health.proto"},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Books"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("Check"),
+				InputType:  proto.String(".grpc.health.v1.HealthCheckRequest"),
+				OutputType: proto.String(".grpc.health.v1.HealthCheckResponse"),
+				Options:    opts,
+			}},
+		}},
+	}
+
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	require.NoError(t, err)
+
+	// Responds SERVING for books/1, and NOT_FOUND otherwise.
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/transcode.test.Books/Check", r.URL.Path)
+		assert.Equal(t, "application/grpc+proto", r.Header.Get("Content-Type"))
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		var req grpc_health_v1.HealthCheckRequest
+		require.NoError(t, proto.Unmarshal(body[5:], &req))
+
+		if req.Service != "books/1" {
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "no%20such%20book")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		payload, err := proto.Marshal(&grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING})
+		require.NoError(t, err)
+
+		frame := make([]byte, 5, 5+len(payload))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+		_, _ = w.Write(append(frame, payload...))
+		w.Header().Set("Grpc-Status", "0")
+	})
+
+	tc := New(handler, "/api/", "/rpc")
+	require.NoError(t, tc.register(fd.Services().Get(0).Methods().Get(0)))
+	return tc
+}
+
+func TestAnnotations(t *testing.T) {
+	var additional []byte
+	additional = protowire.AppendTag(additional, httpRulePost, protowire.BytesType)
+	additional = protowire.AppendString(additional, "/v1/check")
+	additional = protowire.AppendTag(additional, httpRuleBody, protowire.BytesType)
+	additional = protowire.AppendString(additional, "*")
+
+	var rule []byte
+	rule = protowire.AppendTag(rule, httpRuleGet, protowire.BytesType)
+	rule = protowire.AppendString(rule, "/v1/{service=books/*}")
+	rule = protowire.AppendTag(rule, httpRuleAdditionalBindings, protowire.BytesType)
+	rule = protowire.AppendBytes(rule, additional)
+
+	tc := annotatedMethod(t, rule)
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+		match  bool
+		status int
+		resp   string
+	}{
+		{http.MethodGet, "/api/v1/books/1", "", true, http.StatusOK, `{"status":"SERVING"}`},
+		{http.MethodGet, "/api/v1/books/2", "", true, http.StatusNotFound, `{"code":5,"message":"no such book"}`},
+		{http.MethodPost, "/api/v1/check", `{"service":"books/1"}`, true, http.StatusOK, `{"status":"SERVING"}`},
+		{http.MethodPost, "/api/rpc/transcode.test.Books/Check", `{"service":"books/1"}`, true, http.StatusOK, `{"status":"SERVING"}`},
+		{http.MethodPost, "/api/v1/check", `{"service":`, true, http.StatusBadRequest, ""},
+		{http.MethodGet, "/api/v1/check", "", false, http.StatusNotFound, ""},
+		{http.MethodGet, "/v1/books/1", "", false, http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		assert.Equal(t, tt.match, tc.Match(req), "%s %s", tt.method, tt.target)
+
+		w := httptest.NewRecorder()
+		tc.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.status, w.Code, "%s %s", tt.method, tt.target)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		if tt.resp != "" {
+			assert.JSONEq(t, tt.resp, w.Body.String(), "%s %s", tt.method, tt.target)
+		}
+	}
+}
+
+func TestHTTPRuleValidation(t *testing.T) {
+	var rule []byte
+	rule = protowire.AppendTag(rule, httpRuleGet, protowire.BytesType)
+	rule = protowire.AppendString(rule, "/v1/{nope}")
+
+	rules, err := parseHTTPRule(rule, true)
+	require.NoError(t, err)
+	assert.Equal(t, []httpRule{{method: http.MethodGet, path: "/v1/{nope}"}}, rules)
+
+	tmpl, err := parseTemplate(rules[0].path)
+	require.NoError(t, err)
+
+	b := &binding{
+		method: http.MethodGet,
+		path:   tmpl,
+		input:  (&grpc_health_v1.HealthCheckRequest{}).ProtoReflect().Type(),
+		output: (&grpc_health_v1.HealthCheckResponse{}).ProtoReflect().Type(),
+	}
+	assert.Error(t, b.validate())
+}
@@ -0,0 +1,190 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+
+	"github.com/vs49688/servicebase/multilistener"
+)
+
+// ListenerInfo identifies the listener a request was received on.
+type ListenerInfo struct {
+	Protocol string
+	Index    int
+	Address  string
+}
+
+type listenerInfoKey struct{}
+
+func withListenerInfo(ctx context.Context, info ListenerInfo) context.Context {
+	return context.WithValue(ctx, listenerInfoKey{}, info)
+}
+
+// ListenerFromContext returns the listener an HTTP request or GRPC call was received on.
+func ListenerFromContext(ctx context.Context) (ListenerInfo, bool) {
+	info, ok := ctx.Value(listenerInfoKey{}).(ListenerInfo)
+	return info, ok
+}
+
+// grpcListeners finds the listener of a GRPC call by the local address of its connection,
+// as GRPC servers have no equivalent of http.Server.BaseContext. Listeners must be added
+// before serving.
+type grpcListeners struct {
+	addrs []net.Addr
+	infos []ListenerInfo
+}
+
+func (l *grpcListeners) add(addr net.Addr, info ListenerInfo) {
+	l.addrs = append(l.addrs, addr)
+	l.infos = append(l.infos, info)
+}
+
+func (l *grpcListeners) lookup(addr net.Addr) (ListenerInfo, bool) {
+	for i, a := range l.addrs {
+		if a.Network() == addr.Network() && a.String() == addr.String() {
+			return l.infos[i], true
+		}
+	}
+
+	// Connections to a wildcard listener have a specific local address.
+	local, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return ListenerInfo{}, false
+	}
+
+	for i, a := range l.addrs {
+		if ta, ok := a.(*net.TCPAddr); ok && ta.IP.IsUnspecified() && ta.Port == local.Port {
+			return l.infos[i], true
+		}
+	}
+
+	return ListenerInfo{}, false
+}
+
+// withListener adds the call's listener to the context. Calls bridged from HTTP already
+// have it.
+func (l *grpcListeners) withListener(ctx context.Context) context.Context {
+	if _, ok := ListenerFromContext(ctx); ok {
+		return ctx
+	}
+
+	if p, ok := peer.FromContext(ctx); ok && p.LocalAddr != nil {
+		if info, ok := l.lookup(p.LocalAddr); ok {
+			return withListenerInfo(ctx, info)
+		}
+	}
+
+	return ctx
+}
+
+func (l *grpcListeners) unaryServerInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(l.withListener(ctx), req)
+}
+
+type listenerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *listenerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (l *grpcListeners) streamServerInterceptor(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &listenerStream{ServerStream: ss, ctx: l.withListener(ss.Context())})
+}
+
+// listenerLogHandler adds the listener to log records.
+type listenerLogHandler struct {
+	slog.Handler
+}
+
+func (h *listenerLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if info, ok := ListenerFromContext(ctx); ok {
+		r = r.Clone()
+		r.AddAttrs(slog.Group("listener",
+			slog.String("protocol", info.Protocol),
+			slog.Int("index", info.Index),
+			slog.String("address", info.Address),
+		))
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *listenerLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &listenerLogHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *listenerLogHandler) WithGroup(name string) slog.Handler {
+	return &listenerLogHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// ParseBind parses an additional bind of the form "network://address", e.g.
+// "unix:///run/service.sock" or "tcp6://[::1]:8080". If no network is given, tcp is assumed.
+func ParseBind(bind string) (network string, address string, err error) {
+	network, address, ok := strings.Cut(bind, "://")
+	if !ok {
+		network, address = "tcp", bind
+	}
+
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		return "", "", fmt.Errorf("unsupported bind network: %q", network)
+	}
+
+	if address == "" {
+		return "", "", fmt.Errorf("missing bind address: %q", bind)
+	}
+
+	return network, address, nil
+}
+
+// listenConfigs returns the configuration of each listener. The first is named name,
+// and each additional bind "{name}-{n}", counting from 1.
+func (cfg *ListenConfig) listenConfigs(name string) ([]multilistener.ListenConfig, error) {
+	cfgs := []multilistener.ListenConfig{{
+		Name:              name,
+		BindAddress:       cfg.BindAddress,
+		BindNetwork:       cfg.BindNetwork,
+		SocketPermissions: fs.FileMode(cfg.SocketPermissions),
+	}}
+
+	for i, bind := range cfg.AdditionalBinds {
+		network, address, err := ParseBind(bind)
+		if err != nil {
+			return nil, err
+		}
+
+		cfgs = append(cfgs, multilistener.ListenConfig{
+			Name:              fmt.Sprintf("%s-%d", name, i+1),
+			BindAddress:       address,
+			BindNetwork:       network,
+			SocketPermissions: fs.FileMode(cfg.SocketPermissions),
+		})
+	}
+
+	return cfgs, nil
+}
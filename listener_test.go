@@ -0,0 +1,134 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/vs49688/servicebase"
+	"github.com/vs49688/servicebase/servicetest"
+)
+
+func TestParseBind(t *testing.T) {
+	t.Parallel()
+
+	for bind, want := range map[string][2]string{
+		"127.0.0.1:8080":           {"tcp", "127.0.0.1:8080"},
+		"tcp6://[::1]:8080":        {"tcp6", "[::1]:8080"},
+		"unix:///run/service.sock": {"unix", "/run/service.sock"},
+	} {
+		network, address, err := servicebase.ParseBind(bind)
+		require.NoError(t, err, bind)
+		assert.Equal(t, want, [2]string{network, address}, bind)
+	}
+
+	for _, bind := range []string{"udp://127.0.0.1:53", "tcp://"} {
+		_, _, err := servicebase.ParseBind(bind)
+		assert.Error(t, err, bind)
+	}
+}
+
+// listenerService records the listener of each GetHealth() call made by a request.
+type listenerService struct {
+	healthService
+
+	mu        sync.Mutex
+	listeners []servicebase.ListenerInfo
+}
+
+func (s *listenerService) GetHealth(ctx context.Context) (*servicebase.GetHealthResponse, error) {
+	if info, ok := servicebase.ListenerFromContext(ctx); ok {
+		s.mu.Lock()
+		s.listeners = append(s.listeners, info)
+		s.mu.Unlock()
+	}
+
+	return s.healthService.GetHealth(ctx)
+}
+
+func TestMultipleListeners(t *testing.T) {
+	t.Parallel()
+
+	cfg := servicebase.DefaultServiceConfig()
+	cfg.HTTP.AdditionalBinds = []string{"tcp://127.0.0.1:0"}
+	cfg.GRPC.AdditionalBinds = []string{"tcp://127.0.0.1:0"}
+
+	svc := &listenerService{healthService: healthService{health: servicebase.HealthStatusHealthy}}
+	h := servicetest.Start(t, cfg, func(_ context.Context, params servicebase.ServiceParameters) (servicebase.Service, error) {
+		params.ApplicationRouter.HandleFunc("/listener", func(w http.ResponseWriter, req *http.Request) {
+			info, _ := servicebase.ListenerFromContext(req.Context())
+			_, _ = fmt.Fprintf(w, "%d %s", info.Index, info.Address)
+		})
+		return svc, nil
+	}, servicetest.Options{})
+
+	require.Len(t, h.HTTPAddrs, 2)
+	require.Len(t, h.GRPCAddrs, 2)
+
+	for i, addr := range h.HTTPAddrs {
+		resp, err := h.HTTPClient.Get("http://" + addr.String() + "/listener")
+		require.NoError(t, err)
+
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		require.NoError(t, err)
+
+		assert.Equal(t, fmt.Sprintf("%d %s", i, addr), string(body))
+	}
+
+	var want []servicebase.ListenerInfo
+	for i, addr := range h.GRPCAddrs {
+		conn, err := grpc.NewClient("passthrough:///"+addr.String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		require.NoError(t, err)
+
+		_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+		_ = conn.Close()
+		require.NoError(t, err)
+
+		want = append(want, servicebase.ListenerInfo{Protocol: "grpc", Index: i, Address: addr.String()})
+	}
+
+	svc.mu.Lock()
+	assert.Equal(t, want, svc.listeners)
+	svc.mu.Unlock()
+
+	var listeners []string
+	for _, r := range h.Logs.Records() {
+		r.Attrs(func(a slog.Attr) bool {
+			if a.Key == "listener" {
+				attrs := map[string]slog.Value{}
+				for _, ga := range a.Value.Group() {
+					attrs[ga.Key] = ga.Value
+				}
+				listeners = append(listeners, fmt.Sprintf("%s %d", attrs["protocol"], attrs["index"].Int64()))
+			}
+			return true
+		})
+	}
+
+	assert.Equal(t, []string{"http 0", "http 1"}, listeners)
+}
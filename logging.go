@@ -0,0 +1,103 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync/atomic"
+)
+
+func newFormatHandler(w io.Writer, format string, level slog.Leveler) slog.Handler {
+	hopts := slog.HandlerOptions{Level: level}
+	if format == LogFormatJSON {
+		return slog.NewJSONHandler(w, &hopts)
+	}
+
+	return slog.NewTextHandler(w, &hopts)
+}
+
+type rootHandler struct {
+	handler slog.Handler
+}
+
+type derivedHandler struct {
+	root    *rootHandler
+	handler slog.Handler
+}
+
+// swapHandler is a slog.Handler whose underlying handler may be replaced at runtime.
+// Handlers derived via WithAttrs() and WithGroup() follow the replacement.
+type swapHandler struct {
+	root  *atomic.Pointer[rootHandler]
+	ops   []func(slog.Handler) slog.Handler
+	cache atomic.Pointer[derivedHandler]
+}
+
+func newSwapHandler(h slog.Handler) *swapHandler {
+	sh := &swapHandler{root: &atomic.Pointer[rootHandler]{}}
+	sh.Swap(h)
+	return sh
+}
+
+func (h *swapHandler) Swap(handler slog.Handler) {
+	h.root.Store(&rootHandler{handler: handler})
+}
+
+func (h *swapHandler) current() slog.Handler {
+	root := h.root.Load()
+	if d := h.cache.Load(); d != nil && d.root == root {
+		return d.handler
+	}
+
+	handler := root.handler
+	for _, op := range h.ops {
+		handler = op(handler)
+	}
+
+	h.cache.Store(&derivedHandler{root: root, handler: handler})
+	return handler
+}
+
+func (h *swapHandler) derive(op func(slog.Handler) slog.Handler) *swapHandler {
+	ops := make([]func(slog.Handler) slog.Handler, 0, len(h.ops)+1)
+	ops = append(ops, h.ops...)
+
+	return &swapHandler{
+		root: h.root,
+		ops:  append(ops, op),
+	}
+}
+
+func (h *swapHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.current().Enabled(ctx, level)
+}
+
+func (h *swapHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.current().Handle(ctx, r)
+}
+
+func (h *swapHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.derive(func(handler slog.Handler) slog.Handler {
+		return handler.WithAttrs(attrs)
+	})
+}
+
+func (h *swapHandler) WithGroup(name string) slog.Handler {
+	return h.derive(func(handler slog.Handler) slog.Handler {
+		return handler.WithGroup(name)
+	})
+}
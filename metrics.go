@@ -15,18 +15,64 @@
 package servicebase
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/model"
+	"go.opentelemetry.io/otel/trace"
 	"log/slog"
 	"net"
 	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vs49688/servicebase/internal/middleware/requestid"
+)
+
+const (
+	// The exemplar labels holding the request and trace IDs
+	exemplarRequestID = "request_id"
+	exemplarTraceID   = "trace_id"
+
+	// The route of requests that didn't match one
+	routeUnmatched = "unmatched"
+
+	// The route of requests matching a route without a path template or name
+	routeUnnamed = "unnamed"
 )
 
 type Metrics struct {
+	// Registry holds every metric. Those registered with it directly aren't given the
+	// namespace or constant labels of MetricsConfig.
 	Registry *prometheus.Registry
-	requests *prometheus.CounterVec
+
+	// Registerer registers metrics with the namespace and constant labels applied.
+	Registerer prometheus.Registerer
+
+	histograms HistogramConfig
+
+	requests *prometheus.CounterVec // nil unless HTTPConfig.LegacyRequestMetrics
+
+	httpDuration *prometheus.HistogramVec
+	httpSize     *prometheus.HistogramVec
+	httpInFlight prometheus.Gauge
+	shutdown     *prometheus.GaugeVec
+	panics       *prometheus.CounterVec
+	certs        *prometheus.GaugeVec
+
+	healthStatus      *prometheus.GaugeVec
+	healthTransitions *prometheus.CounterVec
+
+	exemplars *exemplarSampler
 }
 
 type metricsLogger struct {
@@ -37,40 +83,288 @@ func (m metricsLogger) Println(v ...interface{}) {
 	m.logger.Error(fmt.Sprint(v...))
 }
 
-func configureMetrics(logger *slog.Logger) (Metrics, http.Handler, error) {
+func configureMetrics(cfg *ServiceConfig, logger *slog.Logger) (Metrics, http.Handler, error) {
+	mcfg := &cfg.Metrics
 	metricsRegistry := prometheus.NewRegistry()
 
+	// Constant labels apply to every metric, but the namespace only to our own.
+	var labelled prometheus.Registerer = metricsRegistry
+	if len(mcfg.ConstLabels) > 0 {
+		labelled = prometheus.WrapRegistererWith(mcfg.ConstLabels, labelled)
+	}
+
+	registerer := labelled
+	if mcfg.Namespace != "" {
+		registerer = prometheus.WrapRegistererWithPrefix(mcfg.Namespace+"_", labelled)
+	}
+
 	// Add collector for Go stats
-	if err := metricsRegistry.Register(collectors.NewGoCollector()); err != nil {
-		return Metrics{}, nil, err
+	if !mcfg.DisableGoCollector {
+		rules, err := mcfg.goRuntimeMetricsRules()
+		if err != nil {
+			return Metrics{}, nil, err
+		}
+
+		if err := labelled.Register(collectors.NewGoCollector(collectors.WithGoCollectorRuntimeMetrics(rules...))); err != nil {
+			return Metrics{}, nil, err
+		}
 	}
 
 	// Add collector for process stats
-	if err := metricsRegistry.Register(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{})); err != nil {
-		return Metrics{}, nil, err
+	if !mcfg.DisableProcessCollector {
+		if err := labelled.Register(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{})); err != nil {
+			return Metrics{}, nil, err
+		}
 	}
 
 	// Add build info
-	if err := metricsRegistry.Register(collectors.NewBuildInfoCollector()); err != nil {
+	if !mcfg.DisableBuildInfoCollector {
+		if err := labelled.Register(collectors.NewBuildInfoCollector()); err != nil {
+			return Metrics{}, nil, err
+		}
+	}
+
+	var metricRequests *prometheus.CounterVec
+	if cfg.HTTP.LegacyRequestMetrics {
+		// The namespace replaces the historical "root" subsystem.
+		subsystem := "root"
+		if mcfg.Namespace != "" {
+			subsystem = ""
+		}
+
+		metricRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: subsystem,
+			Name:      "requests",
+		}, []string{"path", "remote_ip", "host", "method", "user_agent"})
+
+		if err := registerer.Register(metricRequests); err != nil {
+			return Metrics{}, nil, err
+		}
+	}
+
+	metricHTTPDuration := prometheus.NewHistogramVec(mcfg.Histograms.apply(prometheus.HistogramOpts{
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to serve HTTP requests, by route template, method and status class.",
+	}), []string{"route", "method", "status"})
+
+	if err := registerer.Register(metricHTTPDuration); err != nil {
+		return Metrics{}, nil, err
+	}
+
+	metricHTTPSize := prometheus.NewHistogramVec(mcfg.Histograms.apply(prometheus.HistogramOpts{
+		Subsystem: "http",
+		Name:      "response_size_bytes",
+		Help:      "Size of HTTP response bodies, by route template, method and status class.",
+		Buckets:   prometheus.ExponentialBuckets(100, 10, 7),
+	}), []string{"route", "method", "status"})
+
+	if err := registerer.Register(metricHTTPSize); err != nil {
+		return Metrics{}, nil, err
+	}
+
+	metricHTTPInFlight := prometheus.NewGauge(prometheus.GaugeOpts{
+		Subsystem: "http",
+		Name:      "requests_in_flight",
+		Help:      "Number of HTTP requests being served.",
+	})
+
+	if err := registerer.Register(metricHTTPInFlight); err != nil {
+		return Metrics{}, nil, err
+	}
+
+	metricShutdown := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "shutdown",
+		Name:      "phase_duration_seconds",
+		Help:      "Time taken by each phase of shutdown.",
+	}, []string{"phase"})
+
+	if err := registerer.Register(metricShutdown); err != nil {
+		return Metrics{}, nil, err
+	}
+
+	metricPanics := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "panics_total",
+		Help: "Number of panics recovered from handlers.",
+	}, []string{"protocol"})
+
+	if err := registerer.Register(metricPanics); err != nil {
+		return Metrics{}, nil, err
+	}
+
+	metricCerts := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "tls",
+		Name:      "certificate_expiry_timestamp_seconds",
+		Help:      "Time at which each served certificate expires.",
+	}, []string{"cert_file"})
+
+	if err := registerer.Register(metricCerts); err != nil {
+		return Metrics{}, nil, err
+	}
+
+	metricHealthStatus := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "health",
+		Name:      "status",
+		Help:      "Health of the service, with an empty component, and each dependency. 1 for the current status.",
+	}, []string{"component", "status"})
+
+	if err := registerer.Register(metricHealthStatus); err != nil {
 		return Metrics{}, nil, err
 	}
 
-	metricRequests := prometheus.NewCounterVec(prometheus.CounterOpts{
-		Subsystem: "root",
-		Name:      "requests",
-	}, []string{"path", "remote_ip", "host", "method", "user_agent"})
+	metricHealthTransitions := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "health",
+		Name:      "transitions_total",
+		Help:      "Number of health status changes, by the new status.",
+	}, []string{"component", "status"})
 
-	if err := metricsRegistry.Register(metricRequests); err != nil {
+	if err := registerer.Register(metricHealthTransitions); err != nil {
 		return Metrics{}, nil, err
 	}
 
-	return Metrics{Registry: metricsRegistry, requests: metricRequests}, promhttp.InstrumentMetricHandler(
-		metricsRegistry,
-		promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{ErrorLog: metricsLogger{logger: logger}}),
+	return Metrics{
+		Registry:          metricsRegistry,
+		Registerer:        registerer,
+		histograms:        mcfg.Histograms,
+		requests:          metricRequests,
+		httpDuration:      metricHTTPDuration,
+		httpSize:          metricHTTPSize,
+		httpInFlight:      metricHTTPInFlight,
+		shutdown:          metricShutdown,
+		panics:            metricPanics,
+		certs:             metricCerts,
+		healthStatus:      metricHealthStatus,
+		healthTransitions: metricHealthTransitions,
+		exemplars:         &exemplarSampler{},
+	}, promhttp.InstrumentMetricHandler(
+		labelled,
+		promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{
+			ErrorLog:          metricsLogger{logger: logger},
+			EnableOpenMetrics: true, // For exemplars
+		}),
 	), nil
 }
 
+// The runtime/metrics rule sets of MetricsConfig.GoRuntimeMetrics.
+var goRuntimeMetricsRuleSets = map[string]collectors.GoRuntimeMetricsRule{
+	"all":       collectors.MetricsAll,
+	"gc":        collectors.MetricsGC,
+	"memory":    collectors.MetricsMemory,
+	"scheduler": collectors.MetricsScheduler,
+	"debug":     collectors.MetricsDebug,
+}
+
+func (cfg *MetricsConfig) goRuntimeMetricsRules() ([]collectors.GoRuntimeMetricsRule, error) {
+	rules := make([]collectors.GoRuntimeMetricsRule, 0, len(cfg.GoRuntimeMetrics))
+	for _, r := range cfg.GoRuntimeMetrics {
+		if rule, ok := goRuntimeMetricsRuleSets[r]; ok {
+			rules = append(rules, rule)
+			continue
+		}
+
+		re, err := regexp.Compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid go runtime metrics rule %q: %w", r, err)
+		}
+
+		rules = append(rules, collectors.GoRuntimeMetricsRule{Matcher: re})
+	}
+
+	return rules, nil
+}
+
+func (cfg *MetricsConfig) validate() error {
+	if cfg.Namespace != "" && !model.IsValidLegacyMetricName(cfg.Namespace) {
+		return fmt.Errorf("invalid namespace: %q", cfg.Namespace)
+	}
+
+	for name := range cfg.ConstLabels {
+		if !model.LabelName(name).IsValidLegacy() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			return fmt.Errorf("invalid const label: %q", name)
+		}
+	}
+
+	if _, err := cfg.goRuntimeMetricsRules(); err != nil {
+		return err
+	}
+
+	h := &cfg.Histograms
+	if !slices.IsSorted(h.Buckets) || len(slices.Compact(slices.Clone(h.Buckets))) != len(h.Buckets) {
+		return errors.New("histogram buckets must be increasing")
+	}
+
+	if h.NativeBucketFactor < 0 || h.NativeMinResetDuration < 0 {
+		return errors.New("native histogram settings must not be negative")
+	}
+
+	return nil
+}
+
+// apply fills in the buckets and native histogram settings of opts, where unset.
+func (cfg *HistogramConfig) apply(opts prometheus.HistogramOpts) prometheus.HistogramOpts {
+	if len(opts.Buckets) == 0 {
+		opts.Buckets = cfg.Buckets
+	}
+
+	if opts.NativeHistogramBucketFactor == 0 {
+		opts.NativeHistogramBucketFactor = cfg.NativeBucketFactor
+	}
+
+	if opts.NativeHistogramMaxBucketNumber == 0 {
+		opts.NativeHistogramMaxBucketNumber = cfg.NativeMaxBuckets
+	}
+
+	if opts.NativeHistogramMinResetDuration == 0 {
+		opts.NativeHistogramMinResetDuration = cfg.NativeMinResetDuration
+	}
+
+	return opts
+}
+
+// NewCounter registers a counter with the Registerer.
+func (m *Metrics) NewCounter(opts prometheus.CounterOpts) (prometheus.Counter, error) {
+	c := prometheus.NewCounter(opts)
+	return c, m.Registerer.Register(c)
+}
+
+// NewCounterVec registers a counter vector with the Registerer.
+func (m *Metrics) NewCounterVec(opts prometheus.CounterOpts, labels []string) (*prometheus.CounterVec, error) {
+	c := prometheus.NewCounterVec(opts, labels)
+	return c, m.Registerer.Register(c)
+}
+
+// NewGauge registers a gauge with the Registerer.
+func (m *Metrics) NewGauge(opts prometheus.GaugeOpts) (prometheus.Gauge, error) {
+	g := prometheus.NewGauge(opts)
+	return g, m.Registerer.Register(g)
+}
+
+// NewGaugeVec registers a gauge vector with the Registerer.
+func (m *Metrics) NewGaugeVec(opts prometheus.GaugeOpts, labels []string) (*prometheus.GaugeVec, error) {
+	g := prometheus.NewGaugeVec(opts, labels)
+	return g, m.Registerer.Register(g)
+}
+
+// NewHistogram registers a histogram with the Registerer. Unset buckets and native
+// histogram settings default to those of MetricsConfig.Histograms.
+func (m *Metrics) NewHistogram(opts prometheus.HistogramOpts) (prometheus.Histogram, error) {
+	h := prometheus.NewHistogram(m.histograms.apply(opts))
+	return h, m.Registerer.Register(h)
+}
+
+// NewHistogramVec registers a histogram vector with the Registerer, like NewHistogram.
+func (m *Metrics) NewHistogramVec(opts prometheus.HistogramOpts, labels []string) (*prometheus.HistogramVec, error) {
+	h := prometheus.NewHistogramVec(m.histograms.apply(opts), labels)
+	return h, m.Registerer.Register(h)
+}
+
+// RecordHTTPRequest increments the legacy root_requests counter, if enabled with
+// HTTPConfig.LegacyRequestMetrics. Its labels are unbounded; see instrumentHTTP().
 func (m *Metrics) RecordHTTPRequest(req *http.Request) {
+	if m.requests == nil {
+		return
+	}
+
 	remoteIP, _, _ := net.SplitHostPort(req.RemoteAddr)
 	m.requests.With(prometheus.Labels{
 		"path":       req.URL.Path,
@@ -80,3 +374,187 @@ func (m *Metrics) RecordHTTPRequest(req *http.Request) {
 		"user_agent": req.UserAgent(),
 	}).Inc()
 }
+
+func (m *Metrics) RecordShutdownPhase(phase string, took time.Duration) {
+	m.shutdown.With(prometheus.Labels{"phase": phase}).Set(took.Seconds())
+}
+
+func (m *Metrics) RecordPanic(protocol string) {
+	m.panics.With(prometheus.Labels{"protocol": protocol}).Inc()
+}
+
+func (m *Metrics) RecordCertificateExpiry(certFile string, notAfter time.Time) {
+	m.certs.With(prometheus.Labels{"cert_file": certFile}).Set(float64(notAfter.Unix()))
+}
+
+// RecordHealthStatus sets the status of a component, as a state set.
+func (m *Metrics) RecordHealthStatus(component string, status HealthStatus) {
+	for _, s := range []HealthStatus{HealthStatusHealthy, HealthStatusDegraded, HealthStatusUnhealthy, HealthStatusUnknown} {
+		v := 0.0
+		if s == status {
+			v = 1
+		}
+
+		m.healthStatus.With(prometheus.Labels{"component": component, "status": string(s)}).Set(v)
+	}
+}
+
+func (m *Metrics) RecordHealthTransition(component string, status HealthStatus) {
+	m.healthTransitions.With(prometheus.Labels{"component": component, "status": string(status)}).Inc()
+}
+
+type routeKey struct{}
+
+// routeHolder is filled in by recordRoute(), as only the router knows the route.
+type routeHolder struct {
+	route string
+}
+
+// withRouteHolder returns the request's routeHolder, adding one if it has none.
+func withRouteHolder(r *http.Request) (*http.Request, *routeHolder) {
+	if h, ok := r.Context().Value(routeKey{}).(*routeHolder); ok {
+		return r, h
+	}
+
+	h := &routeHolder{route: routeUnmatched}
+	return r.WithContext(context.WithValue(r.Context(), routeKey{}, h)), h
+}
+
+// recordRoute is router middleware, noting the route's template for instrumentHTTP().
+// It isn't called for requests that don't match a route.
+func recordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h, ok := r.Context().Value(routeKey{}).(*routeHolder); ok {
+			h.route = routeUnnamed
+
+			if route := mux.CurrentRoute(r); route != nil {
+				if tmpl, err := route.GetPathTemplate(); err == nil {
+					h.route = tmpl
+				} else if name := route.GetName(); name != "" {
+					h.route = name
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withRoute notes the route returned by route() for instrumentHTTP(), for handlers that
+// route requests themselves, and so have no template of their own.
+func withRoute(next http.Handler, route func(r *http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h, ok := r.Context().Value(routeKey{}).(*routeHolder); ok {
+			if name := route(r); name != "" {
+				h.route = name
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// instrumentHTTP records the duration and response size of each request, labelled
+// by the route template rather than the path, so the cardinality is bounded.
+func (m *Metrics) instrumentHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, holder := withRouteHolder(r)
+		mw := &metricsWriter{ResponseWriter: w}
+
+		m.httpInFlight.Inc()
+		defer m.httpInFlight.Dec()
+
+		next.ServeHTTP(mw, r)
+
+		if mw.status == 0 {
+			mw.status = http.StatusOK
+		}
+
+		labels := prometheus.Labels{
+			"route":  holder.route,
+			"method": metricsMethod(r.Method),
+			"status": strconv.Itoa(mw.status/100) + "xx",
+		}
+
+		m.httpDuration.With(labels).(prometheus.ExemplarObserver).ObserveWithExemplar(time.Since(start).Seconds(), m.exemplar(r.Context()))
+		m.httpSize.With(labels).Observe(float64(mw.size))
+	})
+}
+
+// metricsMethod collapses non-standard methods, so they can't be used to add labels.
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+type metricsWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (w *metricsWriter) WriteHeader(status int) {
+	// Ignore informational responses
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *metricsWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *metricsWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Exemplars are attached to one in every exemplarSampleEvery requests.
+const exemplarSampleEvery = 10
+
+type exemplarSampler struct {
+	n atomic.Uint64
+}
+
+func (s *exemplarSampler) sample() bool {
+	return s.n.Add(1)%exemplarSampleEvery == 1
+}
+
+// exemplar returns the exemplar linking an observation to its request and trace, or nil
+// if the observation isn't sampled or has nothing to link to.
+func (m *Metrics) exemplar(ctx context.Context) prometheus.Labels {
+	if m.exemplars == nil || !m.exemplars.sample() {
+		return nil
+	}
+
+	labels := prometheus.Labels{}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsSampled() {
+		labels[exemplarTraceID] = sc.TraceID().String()
+	}
+
+	// Exemplars are limited in size, and inbound request IDs aren't.
+	id := requestid.FromContext(ctx)
+	if id != "" && utf8.ValidString(id) &&
+		utf8.RuneCountInString(exemplarRequestID+id+exemplarTraceID+labels[exemplarTraceID]) <= prometheus.ExemplarMaxRunes {
+		labels[exemplarRequestID] = id
+	}
+
+	if len(labels) == 0 {
+		return nil
+	}
+
+	return labels
+}
@@ -0,0 +1,208 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/vs49688/servicebase"
+	"github.com/vs49688/servicebase/internal/middleware/requestid"
+	"github.com/vs49688/servicebase/servicetest"
+)
+
+func TestHTTPMetrics(t *testing.T) {
+	t.Parallel()
+
+	for _, legacy := range []bool{false, true} {
+		cfg := servicebase.DefaultServiceConfig()
+		cfg.HTTP.PathPrefix = "/api"
+		cfg.HTTP.LegacyRequestMetrics = legacy
+
+		h := servicetest.Start(t, cfg, func(_ context.Context, params servicebase.ServiceParameters) (servicebase.Service, error) {
+			params.ApplicationRouter.HandleFunc("/items/{id}", func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = io.WriteString(w, "item")
+			}).Methods(http.MethodGet)
+			return &healthService{health: servicebase.HealthStatusHealthy}, nil
+		}, servicetest.Options{InMemory: true})
+
+		for _, path := range []string{"/api/items/1", "/api/items/2", "/api/nope/1", "/nope/2"} {
+			resp, err := h.HTTPClient.Get(h.BaseURL + path)
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+		}
+
+		families, err := h.Registry.Gather()
+		require.NoError(t, err)
+
+		counts := map[string]uint64{}
+		sizes := map[string]float64{}
+		var legacyFound bool
+		for _, mf := range families {
+			for _, m := range mf.GetMetric() {
+				labels := map[string]string{}
+				for _, l := range m.GetLabel() {
+					labels[l.GetName()] = l.GetValue()
+				}
+
+				key := labels["route"] + " " + labels["method"] + " " + labels["status"]
+				switch mf.GetName() {
+				case "http_request_duration_seconds":
+					counts[key] = m.GetHistogram().GetSampleCount()
+				case "http_response_size_bytes":
+					sizes[key] = m.GetHistogram().GetSampleSum()
+				case "http_requests_in_flight":
+					assert.Equal(t, 0.0, m.GetGauge().GetValue())
+				case "root_requests":
+					legacyFound = true
+				}
+			}
+		}
+
+		assert.Equal(t, map[string]uint64{
+			"/api/items/{id} GET 2xx": 2,
+			"unmatched GET 4xx":       2,
+		}, counts)
+		assert.Equal(t, 8.0, sizes["/api/items/{id} GET 2xx"])
+		assert.Equal(t, legacy, legacyFound)
+	}
+}
+
+func TestExemplars(t *testing.T) {
+	t.Parallel()
+
+	h := servicetest.Start(t, servicebase.DefaultServiceConfig(), func(_ context.Context, params servicebase.ServiceParameters) (servicebase.Service, error) {
+		params.ApplicationRouter.HandleFunc("/hello", func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = io.WriteString(w, "hello")
+		})
+		return &healthService{health: servicebase.HealthStatusHealthy}, nil
+	}, servicetest.Options{InMemory: true})
+
+	// Exemplars are sampled, the first of every 10 requests having one.
+	ctx := metadata.AppendToOutgoingContext(context.Background(), requestid.DefaultMetadataKey, "grpc-request")
+	_, err := grpc_health_v1.NewHealthClient(h.GRPCConn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		req, err := http.NewRequest(http.MethodGet, h.BaseURL+"/hello", nil)
+		require.NoError(t, err)
+		req.Header.Set(requestid.DefaultHeaderName, "http-request")
+
+		resp, err := h.HTTPClient.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, h.BaseURL+"/metrics", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+
+	resp, err := h.HTTPClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Contains(t, resp.Header.Get("Content-Type"), "application/openmetrics-text")
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	exemplars := map[string]int{}
+	for _, line := range strings.Split(string(body), "\n") {
+		name, _, _ := strings.Cut(line, "{")
+		if _, exemplar, ok := strings.Cut(line, " # "); ok {
+			exemplars[name+" "+exemplar[:strings.IndexByte(exemplar, '}')+1]]++
+		}
+	}
+
+	assert.Equal(t, 1, exemplars[`http_request_duration_seconds_bucket {request_id="http-request"}`], exemplars)
+	assert.Equal(t, 1, exemplars[`grpc_server_handling_seconds_bucket {request_id="grpc-request"}`], exemplars)
+}
+
+func TestMetricsConfig(t *testing.T) {
+	t.Parallel()
+
+	cfg := servicebase.DefaultServiceConfig()
+	cfg.Metrics.Namespace = "app"
+	cfg.Metrics.ConstLabels = map[string]string{"env": "test"}
+	cfg.Metrics.DisableProcessCollector = true
+	cfg.Metrics.GoRuntimeMetrics = []string{"gc"}
+	cfg.Metrics.Histograms.Buckets = []float64{0.1, 1}
+	cfg.Metrics.Histograms.NativeBucketFactor = 1.1
+	require.NoError(t, cfg.Validate())
+
+	var jobs prometheus.Histogram
+	h := servicetest.Start(t, cfg, func(_ context.Context, params servicebase.ServiceParameters) (servicebase.Service, error) {
+		var err error
+		jobs, err = params.Metrics.NewHistogram(prometheus.HistogramOpts{Name: "job_duration_seconds"})
+		return &healthService{health: servicebase.HealthStatusHealthy}, err
+	}, servicetest.Options{InMemory: true})
+
+	jobs.Observe(0.5)
+
+	resp, err := h.HTTPClient.Get(h.BaseURL + "/healthz")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	_, err = grpc_health_v1.NewHealthClient(h.GRPCConn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	families, err := h.Registry.Gather()
+	require.NoError(t, err)
+
+	names := map[string]bool{}
+	for _, mf := range families {
+		names[mf.GetName()] = true
+
+		for _, m := range mf.GetMetric() {
+			env := ""
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "env" {
+					env = l.GetValue()
+				}
+			}
+			assert.Equal(t, "test", env, mf.GetName())
+		}
+
+		switch mf.GetName() {
+		case "app_http_request_duration_seconds", "app_grpc_server_handling_seconds", "app_job_duration_seconds":
+			hist := mf.GetMetric()[0].GetHistogram()
+			assert.Len(t, hist.GetBucket(), 2, mf.GetName())
+			assert.NotNil(t, hist.Schema, mf.GetName()) // Native
+		}
+	}
+
+	for _, name := range []string{
+		"app_http_request_duration_seconds",
+		"app_grpc_server_handling_seconds",
+		"app_grpc_server_handled_total",
+		"app_job_duration_seconds",
+		"app_health_status",
+		"go_goroutines",
+		"go_gc_cycles_total_gc_cycles_total",
+	} {
+		assert.True(t, names[name], name)
+	}
+
+	assert.False(t, names["process_cpu_seconds_total"])
+	assert.False(t, names["http_request_duration_seconds"])
+}
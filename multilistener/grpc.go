@@ -16,6 +16,7 @@ package multilistener
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"log/slog"
 	"net"
@@ -24,37 +25,60 @@ import (
 )
 
 type grpcWrapper struct {
+	name   string
 	srv    *grpc.Server
 	lis    net.Listener
+	tls    *tls.Config
 	logger *slog.Logger
 }
 
 func (w *grpcWrapper) Serve(ctx context.Context) error {
+	lis := w.lis
+	if w.tls != nil {
+		lis = tls.NewListener(lis, w.tls)
+	}
+
 	serveChannel := make(chan error, 1)
 	go func() {
-		serveChannel <- w.srv.Serve(w.lis)
+		serveChannel <- w.srv.Serve(lis)
 	}()
 
-	var err error
-
 	select {
 	case <-ctx.Done():
-		w.srv.GracefulStop()
-
-		// If the context was cancelled due to a deadline (timeout), forcefully stop the server
-		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			w.srv.Stop()
+		// Stop accepting. Established streams are left for Shutdown().
+		_ = w.lis.Close()
+		<-serveChannel
+		return nil
+	case err := <-serveChannel:
+		if errors.Is(err, grpc.ErrServerStopped) {
+			return nil
 		}
-	case err = <-serveChannel:
-		break
+
+		return err
 	}
+}
 
-	if errors.Is(err, grpc.ErrServerStopped) {
+// Shutdown gracefully stops the server. The server may be shared by several
+// listeners, so this may be called more than once.
+func (w *grpcWrapper) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		w.srv.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
 		return nil
+	case <-ctx.Done():
+		w.srv.Stop()
+		<-done
+		return ctx.Err()
 	}
+}
 
-	return err
-
+func (w *grpcWrapper) listener() (string, net.Listener) {
+	return w.name, w.lis
 }
 
 func (w *grpcWrapper) Log() *slog.Logger {
@@ -72,10 +96,26 @@ func (l *MultiListener) ListenGRPC(cfg *ListenConfig, srv *grpc.Server) error {
 		return err
 	}
 
+	l.AddGRPCTLS(cfg.Name, lis, srv, cfg.TLS)
+	return nil
+}
+
+// AddGRPC serves a GRPC server on an existing listener. The MultiListener
+// takes ownership of the listener. The name identifies the listener when it is
+// handed to another process, see Files().
+func (l *MultiListener) AddGRPC(name string, lis net.Listener, srv *grpc.Server) {
+	l.AddGRPCTLS(name, lis, srv, nil)
+}
+
+// AddGRPCTLS is AddGRPC(), terminating TLS with the given configuration, if any.
+// This allows TLS to vary by listener, unlike grpc.Creds(). If the configuration
+// has no NextProtos, HTTP/2 is offered, as required by GRPC.
+func (l *MultiListener) AddGRPCTLS(name string, lis net.Listener, srv *grpc.Server, tlsConfig *tls.Config) {
 	l.servers = append(l.servers, &grpcWrapper{
+		name:   name,
 		srv:    srv,
 		lis:    lis,
+		tls:    withNextProtos(tlsConfig, "h2"),
 		logger: l.logger.With(slog.Int("index", len(l.servers))),
 	})
-	return nil
 }
@@ -0,0 +1,92 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multilistener
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+const (
+	// EnvListenFDs and EnvListenFDNames describe sockets passed by a parent
+	// process during an upgrade. They have the same semantics as systemd's
+	// LISTEN_FDS and LISTEN_FDNAMES, but without LISTEN_PID.
+	EnvListenFDs     = "SERVICEBASE_LISTEN_FDS"
+	EnvListenFDNames = "SERVICEBASE_LISTEN_FDNAMES"
+)
+
+type fileListener interface {
+	File() (*os.File, error)
+}
+
+// Files returns duplicates of every listening socket, and their names,
+// for passing to another process. The caller must close the files, see CloseFiles().
+func (l *MultiListener) Files() ([]*os.File, []string, error) {
+	var files []*os.File
+	var names []string
+
+	for i, srv := range l.servers {
+		lw, ok := srv.(listenerWrapper)
+		if !ok {
+			continue
+		}
+
+		name, lis := lw.listener()
+
+		// Connections routed by a mux, which owns the socket.
+		if _, ok := lis.(*connListener); ok {
+			continue
+		}
+
+		fl, ok := lis.(fileListener)
+		if !ok {
+			CloseFiles(files)
+			return nil, nil, fmt.Errorf("listener %d (%s) cannot be passed to another process", i, lis.Addr())
+		}
+
+		f, err := fl.File()
+		if err != nil {
+			CloseFiles(files)
+			return nil, nil, err
+		}
+
+		files = append(files, f)
+		names = append(names, name)
+	}
+
+	return files, names, nil
+}
+
+// SetUnlinkOnClose controls whether UNIX sockets are removed when closed.
+// This should be disabled when handing the sockets to another process.
+func (l *MultiListener) SetUnlinkOnClose(unlink bool) {
+	for _, srv := range l.servers {
+		if lw, ok := srv.(listenerWrapper); ok {
+			if _, lis := lw.listener(); lis != nil {
+				if ul, ok := lis.(*net.UnixListener); ok {
+					ul.SetUnlinkOnClose(unlink)
+				}
+			}
+		}
+	}
+}
+
+// CloseFiles closes the files returned by Files().
+func CloseFiles(files []*os.File) {
+	for _, f := range files {
+		_ = f.Close()
+	}
+}
@@ -16,47 +16,162 @@ package multilistener
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"log/slog"
 	"net"
 	"net/http"
-	"time"
+	"slices"
+	"sync"
+
+	"go.uber.org/multierr"
 )
 
 type httpWrapper struct {
+	name   string
+	mu     sync.Mutex
 	srv    *http.Server
-	lis    net.Listener
+	lis    *sharedListener
+	tls    *tls.Config
 	logger *slog.Logger
+
+	// Replaced servers that are still draining
+	retired []*http.Server
+
+	// Set while serving
+	serving      bool
+	view         net.Listener
+	serveChannel chan serveResult
+	stopped      chan struct{}
+}
+
+type serveResult struct {
+	srv *http.Server
+	err error
+}
+
+func (w *httpWrapper) start(srv *http.Server) {
+	w.view = w.lis.view()
+
+	var lis net.Listener = w.view
+	if w.tls != nil {
+		lis = tls.NewListener(lis, w.tls)
+	}
+
+	go func(lis net.Listener, serveChannel chan serveResult, stopped chan struct{}) {
+		err := srv.Serve(lis)
+
+		select {
+		case serveChannel <- serveResult{srv: srv, err: err}:
+		case <-stopped:
+		}
+	}(lis, w.serveChannel, w.stopped)
+}
+
+func (w *httpWrapper) current() *http.Server {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.srv
 }
 
 func (w *httpWrapper) Serve(ctx context.Context) error {
-	serveChannel := make(chan error, 1)
-	go func() {
-		serveChannel <- w.srv.Serve(w.lis)
+	w.mu.Lock()
+	w.serving = true
+	w.serveChannel = make(chan serveResult, 1)
+	w.stopped = make(chan struct{})
+	w.start(w.srv)
+	w.mu.Unlock()
+
+	defer func() {
+		w.mu.Lock()
+		w.serving = false
+		w.mu.Unlock()
+
+		close(w.stopped)
+		_ = w.lis.Close()
 	}()
 
-	var err error
+	for {
+		select {
+		case <-ctx.Done():
+			// Stop accepting. Established connections are left for Shutdown().
+			return nil
+		case res := <-w.serveChannel:
+			// A replaced server has stopped accepting.
+			if res.srv != w.current() {
+				continue
+			}
+
+			if errors.Is(res.err, http.ErrServerClosed) {
+				return nil
+			}
 
-	select {
-	case <-ctx.Done():
-		// Context cancelled, attempt to clean up gracefully.
-		// Calling Shutdown() will cause Serve() to return immediately with http.ErrServerClosed.
-		shutdownChannel := make(chan error, 1)
+			return res.err
+		}
+	}
+}
+
+// Shutdown gracefully stops the server, and any it replaced.
+func (w *httpWrapper) Shutdown(ctx context.Context) error {
+	w.mu.Lock()
+	servers := append([]*http.Server{w.srv}, w.retired...)
+	w.mu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(servers))
+
+	for i, srv := range servers {
+		wg.Add(1)
 		go func() {
-			sdCtx, sdCancel := context.WithTimeout(context.Background(), 1*time.Second)
-			defer sdCancel()
-			shutdownChannel <- w.srv.Shutdown(sdCtx)
+			defer wg.Done()
+
+			// Shutdown() only returns early if ctx expires.
+			if errs[i] = srv.Shutdown(ctx); errs[i] != nil {
+				_ = srv.Close()
+			}
 		}()
-		err = <-shutdownChannel
-	case err = <-serveChannel:
-		break
 	}
 
-	if errors.Is(err, http.ErrServerClosed) {
-		return nil
+	wg.Wait()
+	return multierr.Combine(errs...)
+}
+
+// replace gracefully swaps the server, if it matches.
+func (w *httpWrapper) replace(old, srv *http.Server) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.srv != old {
+		return false
+	}
+
+	w.srv = srv
+
+	// Not serving, nothing to drain.
+	if !w.serving {
+		return true
 	}
 
-	return err
+	// Stop the old server accepting before the new one starts.
+	_ = w.view.Close()
+	w.start(srv)
+
+	// Allow the old server to drain until we're shut down.
+	w.retired = append(w.retired, old)
+	go func() {
+		if err := old.Shutdown(context.Background()); err == nil {
+			w.mu.Lock()
+			w.retired = slices.DeleteFunc(w.retired, func(s *http.Server) bool { return s == old })
+			w.mu.Unlock()
+		}
+	}()
+
+	return true
+}
+
+func (w *httpWrapper) listener() (string, net.Listener) {
+	return w.name, w.lis.Listener
 }
 
 func (w *httpWrapper) Log() *slog.Logger {
@@ -64,7 +179,16 @@ func (w *httpWrapper) Log() *slog.Logger {
 }
 
 func (w *httpWrapper) Close() error {
-	return w.srv.Close()
+	w.mu.Lock()
+	servers := append([]*http.Server{w.srv}, w.retired...)
+	w.mu.Unlock()
+
+	errs := []error{w.lis.Close()}
+	for _, srv := range servers {
+		errs = append(errs, srv.Close())
+	}
+
+	return multierr.Combine(errs...)
 }
 
 func (l *MultiListener) ListenHTTP(cfg *ListenConfig, srv *http.Server) error {
@@ -73,10 +197,36 @@ func (l *MultiListener) ListenHTTP(cfg *ListenConfig, srv *http.Server) error {
 		return err
 	}
 
+	l.AddHTTPTLS(cfg.Name, lis, srv, cfg.TLS)
+	return nil
+}
+
+// AddHTTP serves an HTTP server on an existing listener. The MultiListener
+// takes ownership of the listener. The name identifies the listener when it is
+// handed to another process, see Files().
+func (l *MultiListener) AddHTTP(name string, lis net.Listener, srv *http.Server) {
+	l.AddHTTPTLS(name, lis, srv, nil)
+}
+
+// AddHTTPTLS is AddHTTP(), terminating TLS with the given configuration, if any.
+// If the configuration has no NextProtos, HTTP/2 and HTTP/1.1 are offered.
+func (l *MultiListener) AddHTTPTLS(name string, lis net.Listener, srv *http.Server, tlsConfig *tls.Config) {
 	l.servers = append(l.servers, &httpWrapper{
+		name:   name,
 		srv:    srv,
-		lis:    lis,
+		lis:    newSharedListener(lis),
+		tls:    withNextProtos(tlsConfig, "h2", "http/1.1"),
 		logger: l.logger.With(slog.Int("index", len(l.servers))),
 	})
-	return nil
+}
+
+// ReplaceHTTP gracefully replaces an HTTP server with another, e.g. to apply new
+// settings. The replacement begins accepting connections on each of the old server's
+// listeners immediately, while the old server is given until shutdown to drain.
+func (l *MultiListener) ReplaceHTTP(old, srv *http.Server) {
+	for _, w := range l.servers {
+		if hw, ok := w.(*httpWrapper); ok && hw.replace(old, srv) {
+			hw.Log().Info("replaced http server")
+		}
+	}
 }
@@ -0,0 +1,118 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multilistener
+
+import (
+	"net"
+	"os"
+	"sync"
+
+	"github.com/vs49688/servicebase/internal/systemd"
+)
+
+var (
+	inheritedOnce sync.Once
+	inheritedMu   sync.Mutex
+	inherited     []*os.File
+)
+
+func addrMatches(addr net.Addr, cfg *ListenConfig) bool {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		want, err := net.ResolveTCPAddr(cfg.BindNetwork, cfg.BindAddress)
+		if err != nil {
+			return false
+		}
+
+		return a.Port == want.Port && (a.IP.Equal(want.IP) || (a.IP.IsUnspecified() && want.IP == nil))
+	case *net.UnixAddr:
+		return a.Name == cfg.BindAddress
+	default:
+		return false
+	}
+}
+
+// sameKind returns true if addr is a UNIX socket address, and cfg is for one, or
+// neither are.
+func sameKind(addr net.Addr, cfg *ListenConfig) bool {
+	_, unix := addr.(*net.UnixAddr)
+	return unix == (cfg.BindNetwork == "unix")
+}
+
+// takeInherited returns a listener created from an inherited socket, either via
+// systemd socket activation or from our parent during an upgrade. Sockets matching
+// both name and address are preferred, then those matching the address, then the
+// name. Each socket may only be taken once.
+func takeInherited(cfg *ListenConfig) (net.Listener, error) {
+	inheritedOnce.Do(func() {
+		inherited = systemd.Files(true)
+
+		// Sockets handed over by our parent during an upgrade.
+		if os.Getenv(EnvListenFDs) != "" {
+			inherited = append(inherited, systemd.ParseFiles("", os.Getenv(EnvListenFDs), os.Getenv(EnvListenFDNames), systemd.ListenFDsStart)...)
+			_ = os.Unsetenv(EnvListenFDs)
+			_ = os.Unsetenv(EnvListenFDNames)
+		}
+	})
+
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+
+	// A socket matching by name alone must at least be of the same kind, as the
+	// binds may have changed since it was named.
+	rank := func(f *os.File, lis net.Listener) int {
+		named := cfg.Name != "" && f.Name() == cfg.Name
+		addressed := addrMatches(lis.Addr(), cfg)
+
+		switch {
+		case named && addressed:
+			return 3
+		case addressed:
+			return 2
+		case named && sameKind(lis.Addr(), cfg):
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	best, bestRank := -1, 0
+	var bestLis net.Listener
+	for i, f := range inherited {
+		lis, err := net.FileListener(f)
+		if err != nil {
+			continue
+		}
+
+		if r := rank(f, lis); r > bestRank {
+			if bestLis != nil {
+				_ = bestLis.Close()
+			}
+
+			best, bestRank, bestLis = i, r, lis
+			continue
+		}
+
+		_ = lis.Close()
+	}
+
+	if bestLis == nil {
+		return nil, nil
+	}
+
+	_ = inherited[best].Close()
+	inherited = append(inherited[:best], inherited[best+1:]...)
+	return bestLis, nil
+}
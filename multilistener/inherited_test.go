@@ -0,0 +1,116 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build unix
+
+package multilistener
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func inherit(t *testing.T, name string, lis net.Listener) {
+	f, err := lis.(interface{ File() (*os.File, error) }).File()
+	require.NoError(t, err)
+	defer f.Close()
+
+	fd, err := syscall.Dup(int(f.Fd()))
+	require.NoError(t, err)
+
+	inheritedOnce.Do(func() {})
+
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+	inherited = append(inherited, os.NewFile(uintptr(fd), name))
+}
+
+func TestListenInherited(t *testing.T) {
+	logger := newTestLogger()
+
+	lis1, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis1.Close()
+
+	lis2, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis2.Close()
+
+	inherit(t, "http", lis1)
+	inherit(t, "unknown", lis2)
+
+	// By name
+	ln, err := Listen(&ListenConfig{Name: "http", BindNetwork: "tcp", BindAddress: "127.0.0.1:1"}, logger)
+	require.NoError(t, err)
+	assert.Equal(t, lis1.Addr().String(), ln.Addr().String())
+	require.NoError(t, ln.Close())
+
+	// By address
+	ln, err = Listen(&ListenConfig{Name: "grpc", BindNetwork: "tcp", BindAddress: lis2.Addr().String()}, logger)
+	require.NoError(t, err)
+	assert.Equal(t, lis2.Addr().String(), ln.Addr().String())
+	require.NoError(t, ln.Close())
+
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+	assert.Empty(t, inherited)
+}
+
+func TestListenInheritedChangedBinds(t *testing.T) {
+	lis1, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis1.Close()
+
+	lis2, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis2.Close()
+
+	sock, err := net.Listen("unix", filepath.Join(t.TempDir(), "http.sock"))
+	require.NoError(t, err)
+	defer sock.Close()
+
+	inherit(t, "http-1", lis1)
+	inherit(t, "http-2", lis2)
+	inherit(t, "http-3", sock)
+
+	// The binds were reordered, the address wins over the name.
+	ln, err := takeInherited(&ListenConfig{Name: "http-1", BindNetwork: "tcp", BindAddress: lis2.Addr().String()})
+	require.NoError(t, err)
+	require.NotNil(t, ln)
+	assert.Equal(t, lis2.Addr().String(), ln.Addr().String())
+	require.NoError(t, ln.Close())
+
+	// A UNIX socket is never taken by name for a TCP bind.
+	ln, err = takeInherited(&ListenConfig{Name: "http-3", BindNetwork: "tcp", BindAddress: "127.0.0.1:1"})
+	require.NoError(t, err)
+	assert.Nil(t, ln)
+
+	ln, err = takeInherited(&ListenConfig{Name: "http-1", BindNetwork: "tcp", BindAddress: "127.0.0.1:1"})
+	require.NoError(t, err)
+	require.NotNil(t, ln)
+	assert.Equal(t, lis1.Addr().String(), ln.Addr().String())
+	require.NoError(t, ln.Close())
+
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+	require.Len(t, inherited, 1)
+	_ = inherited[0].Close()
+	inherited = nil
+}
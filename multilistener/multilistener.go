@@ -17,7 +17,8 @@ package multilistener
 import (
 	"context"
 	"log/slog"
-	"reflect"
+	"sync"
+	"time"
 
 	"go.uber.org/multierr"
 )
@@ -27,81 +28,173 @@ func New(logger *slog.Logger) *MultiListener {
 		logger: logger,
 	}
 
+	l.stopCtx, l.stop = context.WithCancel(context.Background())
 	return l
 }
 
+// SetShutdownConfig sets how Serve() shuts down. It must be called before Serve().
+func (l *MultiListener) SetShutdownConfig(cfg ShutdownConfig) {
+	l.shutdown = cfg
+}
+
+type serverResult struct {
+	idx int
+	err error
+}
+
+// Serve runs every server and worker until ctx is cancelled, or one of them fails.
+// Everything is then shut down in phases: the servers stop accepting, HTTP
+// connections are drained, then GRPC streams, and finally the workers are stopped.
 func (l *MultiListener) Serve(ctx context.Context) error {
-	// Create a sub-context so we can cancel ourselves.
-	ourCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
+	serveCtx, cancelServe := context.WithCancel(ctx)
+	defer cancelServe()
+
+	// Workers are left running until the servers have drained, as handlers may rely on them.
+	workerCtx, cancelWorkers := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWorkers()
 
 	// Fire up all the servers.
-	chans := make([]chan error, len(l.servers))
-	allCases := make([]reflect.SelectCase, len(l.servers)+1)
+	results := make(chan serverResult, len(l.servers))
 	for i, srv := range l.servers {
-		chans[i] = make(chan error, 1)
-		allCases[i] = reflect.SelectCase{
-			Dir:  reflect.SelectRecv,
-			Chan: reflect.ValueOf(chans[i]),
+		srvCtx := serveCtx
+		if isWorker(srv) {
+			srvCtx = workerCtx
 		}
 
 		go func(i int, w wrapper) {
-			chans[i] <- w.Serve(ourCtx)
+			results <- serverResult{idx: i, err: w.Serve(srvCtx)}
 		}(i, srv)
 	}
 
-	contextIndex := len(allCases) - 1
-	allCases[contextIndex] = reflect.SelectCase{
-		Dir:  reflect.SelectRecv,
-		Chan: reflect.ValueOf(ourCtx.Done()),
-	}
-
-	serveCases := allCases[:contextIndex]
-
 	serverErrors := make([]error, len(l.servers))
+	terminated := make([]bool, len(l.servers))
 
-	numActive := len(l.servers)
+	handle := func(res serverResult) {
+		serverErrors[res.idx] = l.handleServerTermination(res.idx, res.err)
+		terminated[res.idx] = true
+	}
 
-	// Pass 1: Wait for a context cancellation, or for one of the servers to die.
-	idx, value, _ := reflect.Select(allCases)
-	if idx == contextIndex {
-		// Context closed, flag and wait for our servers to close.
+	// wait waits for every matching server to terminate, or for ctx to expire.
+	wait := func(ctx context.Context, match func(wrapper) bool) error {
+		for {
+			active := false
+			for i, srv := range l.servers {
+				active = active || (!terminated[i] && match(srv))
+			}
+
+			if !active {
+				return nil
+			}
+
+			select {
+			case res := <-results:
+				handle(res)
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	// Wait for a context cancellation, or for one of the servers to die.
+	select {
+	case <-ctx.Done():
 		l.logger.DebugContext(ctx, "context closed")
-	} else {
+	case res := <-results:
 		// One of our servers terminated. Capture its error and kill the rest.
-		serverErrors[idx] = l.handleServerTermination(idx, value)
-		numActive--
+		handle(res)
 	}
 
-	// Cancel all the servers upon a server error
-	cancel()
+	l.logger.DebugContext(ctx, "shutting down")
 
-	l.logger.DebugContext(ctx, "waiting for death")
+	sdCtx, sdCancel := withTimeout(l.stopCtx, l.shutdown.Timeout)
+	defer sdCancel()
 
-	// Pass 2: Wait for the rest to die.
-	for numActive > 0 {
-		idx, value, _ := reflect.Select(serveCases)
-		serverErrors[idx] = l.handleServerTermination(idx, value)
-		numActive--
-	}
+	l.runPhase(sdCtx, PhaseStopAccepting, 0, func(ctx context.Context) error {
+		cancelServe()
+		return wait(ctx, func(w wrapper) bool { return !isWorker(w) })
+	})
+
+	l.runPhase(sdCtx, PhaseDrainHTTP, l.shutdown.HTTPTimeout, func(ctx context.Context) error {
+		return l.drain(ctx, func(w wrapper) bool {
+			switch w.(type) {
+			case *httpWrapper, *muxWrapper:
+				return true
+			default:
+				return false
+			}
+		})
+	})
+
+	l.runPhase(sdCtx, PhaseDrainGRPC, l.shutdown.GRPCTimeout, func(ctx context.Context) error {
+		return l.drain(ctx, func(w wrapper) bool { _, ok := w.(*grpcWrapper); return ok })
+	})
+
+	l.runPhase(sdCtx, PhaseStopWorkers, l.shutdown.WorkerTimeout, func(ctx context.Context) error {
+		cancelWorkers()
+		return wait(ctx, isWorker)
+	})
 
 	return multierr.Combine(serverErrors...)
 }
 
-func (l *MultiListener) handleServerTermination(idx int, value reflect.Value) error {
+func (l *MultiListener) runPhase(ctx context.Context, phase Phase, timeout time.Duration, fn func(ctx context.Context) error) {
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	started := time.Now()
+	err := fn(ctx)
+	took := time.Since(started)
+
+	l.logger.DebugContext(ctx, "shutdown phase complete", slog.String("phase", string(phase)), slog.Duration("took", took))
+
+	if l.shutdown.OnPhase != nil {
+		l.shutdown.OnPhase(phase, took, err)
+	}
+}
+
+// drain shuts down every matching server concurrently.
+func (l *MultiListener) drain(ctx context.Context, match func(wrapper) bool) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(l.servers))
+
+	for i, srv := range l.servers {
+		sw, ok := srv.(serverWrapper)
+		if !ok || !match(srv) {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = sw.Shutdown(ctx)
+		}()
+	}
+
+	wg.Wait()
+	return multierr.Combine(errs...)
+}
+
+func (l *MultiListener) handleServerTermination(idx int, err error) error {
 	srv := l.servers[idx]
 
-	if value.IsNil() {
+	if err == nil {
 		srv.Log().Info("server terminated")
 		return nil
 	}
 
-	err := value.Interface().(error)
 	srv.Log().Error("server terminated")
 	return err
 }
 
+// Stop forcibly stops everything, abandoning any graceful shutdown in progress.
+// Servers are closed immediately, and workers that have yet to stop are abandoned.
+func (l *MultiListener) Stop() {
+	l.stop()
+}
+
 func (l *MultiListener) Close() error {
+	l.stop()
+
 	errs := make([]error, 0, len(l.servers))
 	for _, srv := range l.servers {
 		err := srv.Close()
@@ -114,3 +207,17 @@ func (l *MultiListener) Close() error {
 
 	return multierr.Combine(errs...)
 }
+
+func isWorker(w wrapper) bool {
+	_, ok := w.(*workerWrapper)
+	return ok
+}
+
+// withTimeout is context.WithTimeout(), where a zero timeout never expires.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
@@ -16,7 +16,9 @@ package multilistener
 
 import (
 	"context"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"testing"
@@ -108,3 +110,205 @@ func TestListenWithoutServe(t *testing.T) {
 	err := xx.ListenHTTP(cfg1, srv1)
 	require.NoError(t, err)
 }
+
+func TestReplaceHTTP(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	}))
+
+	xx := New(logger)
+	defer func() {
+		err := xx.Close()
+		assert.NoError(t, err)
+	}()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	makeServer := func(name string) *http.Server {
+		return &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(name))
+		})}
+	}
+
+	srv1 := makeServer("srv1")
+	xx.AddHTTP("http", lis, srv1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := make(chan error, 1)
+	go func() {
+		ch <- xx.Serve(ctx)
+	}()
+
+	get := func() string {
+		// Don't reuse connections, they're bound to the old server.
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		resp, err := client.Get("http://" + lis.Addr().String())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	assert.Equal(t, "srv1", get())
+
+	xx.ReplaceHTTP(srv1, makeServer("srv2"))
+	assert.Equal(t, "srv2", get())
+
+	cancel()
+	require.NoError(t, <-ch)
+}
+
+func TestShutdownPhases(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	}))
+
+	xx := New(logger)
+	defer func() {
+		err := xx.Close()
+		assert.NoError(t, err)
+	}()
+
+	var phases []Phase
+	var phaseErrors []error
+	xx.SetShutdownConfig(ShutdownConfig{
+		OnPhase: func(phase Phase, _ time.Duration, err error) {
+			phases = append(phases, phase)
+			phaseErrors = append(phaseErrors, err)
+		},
+	})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	inFlight := make(chan struct{})
+	release := make(chan struct{})
+	xx.AddHTTP("http", lis, &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		close(inFlight)
+		<-release
+		_, _ = w.Write([]byte("drained"))
+	})})
+
+	workerStopped := false
+	xx.AddWorker("worker", func(ctx context.Context) error {
+		<-ctx.Done()
+		workerStopped = true
+		return nil
+	}, WorkerConfig{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := make(chan error, 1)
+	go func() {
+		ch <- xx.Serve(ctx)
+	}()
+
+	respChan := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + lis.Addr().String())
+		if err != nil {
+			respChan <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+
+		b, _ := io.ReadAll(resp.Body)
+		respChan <- string(b)
+	}()
+
+	<-inFlight
+	cancel()
+
+	// The in-flight request holds up shutdown.
+	select {
+	case err := <-ch:
+		t.Fatalf("serve stopped before draining: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(t, <-ch)
+	assert.Equal(t, "drained", <-respChan)
+	assert.True(t, workerStopped)
+	assert.Equal(t, []Phase{PhaseStopAccepting, PhaseDrainHTTP, PhaseDrainGRPC, PhaseStopWorkers}, phases)
+	assert.Equal(t, []error{nil, nil, nil, nil}, phaseErrors)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  ShutdownConfig
+		stop bool
+		err  error
+	}{
+		{name: "Phase", cfg: ShutdownConfig{HTTPTimeout: 100 * time.Millisecond}, err: context.DeadlineExceeded},
+		{name: "Overall", cfg: ShutdownConfig{Timeout: 100 * time.Millisecond}, err: context.DeadlineExceeded},
+		{name: "Stop", stop: true, err: context.Canceled},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+				Level: slog.LevelDebug,
+			}))
+
+			xx := New(logger)
+			defer func() {
+				err := xx.Close()
+				assert.NoError(t, err)
+			}()
+
+			var drainErr error
+			tc.cfg.OnPhase = func(phase Phase, _ time.Duration, err error) {
+				if phase == PhaseDrainHTTP {
+					drainErr = err
+				}
+			}
+			xx.SetShutdownConfig(tc.cfg)
+
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+
+			inFlight := make(chan struct{})
+			xx.AddHTTP("http", lis, &http.Server{Handler: http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+				close(inFlight)
+				<-req.Context().Done()
+			})})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			ch := make(chan error, 1)
+			go func() {
+				ch <- xx.Serve(ctx)
+			}()
+
+			go func() {
+				resp, err := http.Get("http://" + lis.Addr().String())
+				if err == nil {
+					_ = resp.Body.Close()
+				}
+			}()
+
+			<-inFlight
+			cancel()
+
+			if tc.stop {
+				xx.Stop()
+			}
+
+			select {
+			case err := <-ch:
+				require.NoError(t, err)
+			case <-time.After(5 * time.Second):
+				t.Fatal("serve did not stop")
+			}
+
+			assert.ErrorIs(t, drainErr, tc.err)
+		})
+	}
+}
@@ -0,0 +1,493 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multilistener
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"google.golang.org/grpc"
+)
+
+const (
+	// muxSniffTimeout bounds how long a client has to complete the TLS handshake and
+	// send the HTTP/2 preface. HTTP/2 clients that are then idle for as long again
+	// are routed to the GRPC server.
+	muxSniffTimeout = 10 * time.Second
+
+	// muxMaxSniffFrames bounds how many frames may precede the first request.
+	muxMaxSniffFrames = 16
+)
+
+type muxRoute int
+
+const (
+	muxRouteHTTP muxRoute = iota
+	muxRouteHTTP2
+	muxRouteGRPC
+)
+
+// muxWrapper accepts connections on behalf of an HTTP and a GRPC server, routing
+// each by protocol. HTTP/2 connections whose first request has a GRPC content type
+// go to the GRPC server, everything else to the HTTP server.
+//
+// net/http will only speak HTTP/2 over a *tls.Conn, so HTTP/2 connections for the
+// HTTP server are served here instead, using the current server's configuration.
+type muxWrapper struct {
+	name   string
+	lis    net.Listener
+	tls    *tls.Config
+	http   *httpWrapper
+	logger *slog.Logger
+
+	sniffTimeout time.Duration
+
+	// Connections routed to each server
+	httpLis *connListener
+	grpcLis *connListener
+
+	h2     *http2.Server
+	h2Base *http.Server // Only used to gracefully stop h2
+
+	closeOnce sync.Once
+	closeErr  error
+
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	draining bool
+	conns    map[net.Conn]struct{}
+}
+
+func (w *muxWrapper) Serve(ctx context.Context) error {
+	serveChannel := make(chan error, 1)
+	go func() {
+		serveChannel <- w.accept()
+	}()
+
+	select {
+	case <-ctx.Done():
+		// Stop accepting. Established connections are left for Shutdown().
+		_ = w.closeListener()
+		<-serveChannel
+		return nil
+	case err := <-serveChannel:
+		return err
+	}
+}
+
+func (w *muxWrapper) accept() error {
+	for {
+		conn, err := w.lis.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() { //nolint:staticcheck
+				w.logger.Warn("error accepting connection", slog.Any("error", err))
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+
+			return err
+		}
+
+		go w.route(conn)
+	}
+}
+
+func (w *muxWrapper) route(conn net.Conn) {
+	route, conn, err := w.sniff(conn)
+	if err != nil {
+		w.logger.Debug("unable to route connection",
+			slog.String("remote_address", conn.RemoteAddr().String()),
+			slog.Any("error", err),
+		)
+		_ = conn.Close()
+		return
+	}
+
+	switch route {
+	case muxRouteHTTP:
+		w.httpLis.deliver(conn)
+	case muxRouteHTTP2:
+		w.serveHTTP2(conn)
+	case muxRouteGRPC:
+		w.grpcLis.deliver(conn)
+	}
+}
+
+// sniff determines where a connection should be routed. The returned connection
+// replays anything read from the original.
+func (w *muxWrapper) sniff(conn net.Conn) (muxRoute, net.Conn, error) {
+	_ = conn.SetDeadline(time.Now().Add(w.sniffTimeout))
+	defer func() { _ = conn.SetDeadline(time.Time{}) }()
+
+	if w.tls != nil {
+		tc := tls.Server(conn, w.tls)
+		if err := tc.Handshake(); err != nil {
+			return 0, tc, err
+		}
+
+		// Without ALPN, this can only be HTTP/1.
+		if tc.ConnectionState().NegotiatedProtocol != http2.NextProtoTLS {
+			return muxRouteHTTP, tc, nil
+		}
+
+		conn = tc
+	}
+
+	// Read only as much as needed to rule out the HTTP/2 preface, a short
+	// HTTP/1 request may not be followed by anything.
+	preface := make([]byte, len(http2.ClientPreface))
+	for n := 0; n < len(preface); {
+		m, err := conn.Read(preface[n:])
+		n += m
+
+		if !strings.HasPrefix(http2.ClientPreface, string(preface[:n])) {
+			return muxRouteHTTP, newSniffedConn(conn, io.MultiReader(bytes.NewReader(preface[:n]), conn)), nil
+		}
+
+		if err != nil {
+			return 0, conn, err
+		}
+	}
+
+	// GRPC clients wait for the server's settings before sending a request. The
+	// server we route to sends its own, so the client will acknowledge twice.
+	if err := http2.NewFramer(conn, nil).WriteSettings(); err != nil {
+		return 0, conn, err
+	}
+
+	// GRPC clients may connect well before their first call, browsers make a request
+	// straight away. Those that are idle are assumed to be GRPC, rather than closed.
+	_ = conn.SetDeadline(time.Now().Add(w.sniffTimeout))
+
+	var buf bytes.Buffer
+	fr := http2.NewFramer(io.Discard, io.TeeReader(conn, &buf))
+	fr.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+
+	for i := 0; i < muxMaxSniffFrames; i++ {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				return muxRouteGRPC, newSniffedConn(conn, io.MultiReader(bytes.NewReader(preface), &buf, conn)), nil
+			}
+
+			return 0, conn, err
+		}
+
+		mh, ok := f.(*http2.MetaHeadersFrame)
+		if !ok {
+			continue
+		}
+
+		// The GRPC server doesn't mind the extra acknowledgement, net/http does.
+		if isGRPCRequest(mh) {
+			return muxRouteGRPC, newSniffedConn(conn, io.MultiReader(bytes.NewReader(preface), &buf, conn)), nil
+		}
+
+		filter := &settingsAckFilter{r: io.MultiReader(&buf, conn)}
+		return muxRouteHTTP2, newSniffedConn(conn, io.MultiReader(bytes.NewReader(preface), filter)), nil
+	}
+
+	return 0, conn, errors.New("no request in initial frames")
+}
+
+// isGRPCRequest returns true if a request has a GRPC content type, such as
+// application/grpc or application/grpc+proto.
+func isGRPCRequest(f *http2.MetaHeadersFrame) bool {
+	for _, hf := range f.RegularFields() {
+		if hf.Name != "content-type" {
+			continue
+		}
+
+		ct, _, _ := strings.Cut(hf.Value, ";")
+		return ct == "application/grpc" || strings.HasPrefix(ct, "application/grpc+")
+	}
+
+	return false
+}
+
+func (w *muxWrapper) serveHTTP2(conn net.Conn) {
+	if !w.track(conn, true) {
+		_ = conn.Close()
+		return
+	}
+	defer w.track(conn, false)
+
+	srv := w.http.current()
+
+	ctx := context.Background()
+	if srv.BaseContext != nil {
+		ctx = srv.BaseContext(w.lis)
+	}
+
+	w.h2.ServeConn(conn, &http2.ServeConnOpts{
+		Context:    ctx,
+		BaseConfig: srv,
+		Handler:    srv.Handler,
+	})
+}
+
+// track adds or removes an HTTP/2 connection, returning false if shutting down.
+func (w *muxWrapper) track(conn net.Conn, add bool) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !add {
+		delete(w.conns, conn)
+		w.wg.Done()
+		return true
+	}
+
+	if w.draining || w.conns == nil {
+		return false
+	}
+
+	w.conns[conn] = struct{}{}
+	w.wg.Add(1)
+	return true
+}
+
+// closeConns closes the HTTP/2 connections, and stops tracking new ones.
+func (w *muxWrapper) closeConns() error {
+	w.mu.Lock()
+	conns := w.conns
+	w.conns = nil
+	w.mu.Unlock()
+
+	var errs []error
+	for conn := range conns {
+		errs = append(errs, conn.Close())
+	}
+
+	return multierr.Combine(errs...)
+}
+
+// Shutdown gracefully stops the HTTP/2 connections being served for the HTTP server.
+func (w *muxWrapper) Shutdown(ctx context.Context) error {
+	w.mu.Lock()
+	w.draining = true
+	w.mu.Unlock()
+
+	// Sends GOAWAY to each connection, they close once idle.
+	_ = w.h2Base.Shutdown(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		_ = w.closeConns()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (w *muxWrapper) listener() (string, net.Listener) {
+	return w.name, w.lis
+}
+
+func (w *muxWrapper) Log() *slog.Logger {
+	return w.logger
+}
+
+func (w *muxWrapper) closeListener() error {
+	w.closeOnce.Do(func() { w.closeErr = w.lis.Close() })
+	return w.closeErr
+}
+
+func (w *muxWrapper) Close() error {
+	return multierr.Combine(w.closeListener(), w.closeConns())
+}
+
+// AddMux serves an HTTP and a GRPC server on the same listener, terminating TLS with
+// the given configuration, if any. HTTP/2 connections whose first request is GRPC are
+// routed to the GRPC server, everything else to the HTTP server. The servers are
+// otherwise treated as though they had their own listeners, see AddHTTP() and AddGRPC().
+//
+// HTTP/2 connections that are idle, making no request once established, are routed to
+// the GRPC server. If the TLS configuration has no NextProtos, HTTP/2 and HTTP/1.1 are offered.
+func (l *MultiListener) AddMux(name string, lis net.Listener, srv *http.Server, grpcSrv *grpc.Server, tlsConfig *tls.Config) error {
+	w := &muxWrapper{
+		name:   name,
+		lis:    lis,
+		tls:    withNextProtos(tlsConfig, "h2", "http/1.1"),
+		logger: l.logger.With(slog.Int("index", len(l.servers))),
+		h2:     &http2.Server{},
+		h2Base: &http.Server{},
+		conns:  map[net.Conn]struct{}{},
+
+		sniffTimeout: muxSniffTimeout,
+		httpLis:      newConnListener(lis.Addr()),
+		grpcLis:      newConnListener(lis.Addr()),
+	}
+
+	if err := http2.ConfigureServer(w.h2Base, w.h2); err != nil {
+		return err
+	}
+
+	l.servers = append(l.servers, w)
+
+	w.http = &httpWrapper{
+		name:   name,
+		srv:    srv,
+		lis:    newSharedListener(w.httpLis),
+		logger: l.logger.With(slog.Int("index", len(l.servers))),
+	}
+	l.servers = append(l.servers, w.http)
+
+	l.servers = append(l.servers, &grpcWrapper{
+		name:   name,
+		srv:    grpcSrv,
+		lis:    w.grpcLis,
+		logger: l.logger.With(slog.Int("index", len(l.servers))),
+	})
+
+	return nil
+}
+
+// connListener is a listener for connections accepted elsewhere.
+type connListener struct {
+	addr      net.Addr
+	conns     chan net.Conn
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{
+		addr:   addr,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+// deliver hands a connection to whoever is accepting, closing it if the listener is closed.
+func (l *connListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		_ = conn.Close()
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
+
+// sniffedConn is a connection read via r, which replays what was read while sniffing.
+type sniffedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func newSniffedConn(conn net.Conn, r io.Reader) net.Conn {
+	sc := &sniffedConn{Conn: conn, r: r}
+	if tc, ok := conn.(*tls.Conn); ok {
+		return &sniffedTLSConn{sniffedConn: sc, tc: tc}
+	}
+
+	return sc
+}
+
+func (c *sniffedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// sniffedTLSConn exposes the TLS connection state, as *tls.Conn does.
+type sniffedTLSConn struct {
+	*sniffedConn
+	tc *tls.Conn
+}
+
+func (c *sniffedTLSConn) ConnectionState() tls.ConnectionState {
+	return c.tc.ConnectionState()
+}
+
+// settingsAckFilter drops the first settings acknowledgement from a stream of HTTP/2
+// frames, being the acknowledgement of the settings sent while sniffing.
+type settingsAckFilter struct {
+	r         io.Reader
+	pending   []byte // Frame header to pass through
+	remaining int    // Payload to pass through
+	done      bool
+}
+
+func (f *settingsAckFilter) Read(b []byte) (int, error) {
+	for !f.done && len(f.pending) == 0 && f.remaining == 0 {
+		hdr := make([]byte, 9)
+		if _, err := io.ReadFull(f.r, hdr); err != nil {
+			return 0, err
+		}
+
+		ft, flags := http2.FrameType(hdr[3]), http2.Flags(hdr[4])
+		if ft == http2.FrameSettings && flags.Has(http2.FlagSettingsAck) {
+			f.done = true
+			break
+		}
+
+		f.pending = hdr
+		f.remaining = int(hdr[0])<<16 | int(hdr[1])<<8 | int(hdr[2])
+	}
+
+	if len(f.pending) > 0 {
+		n := copy(b, f.pending)
+		f.pending = f.pending[n:]
+		return n, nil
+	}
+
+	if f.remaining > 0 {
+		if len(b) > f.remaining {
+			b = b[:f.remaining]
+		}
+
+		n, err := f.r.Read(b)
+		f.remaining -= n
+		return n, err
+	}
+
+	return f.r.Read(b)
+}
@@ -0,0 +1,166 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multilistener
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestMux(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	}))
+
+	xx := New(logger)
+	defer func() {
+		err := xx.Close()
+		assert.NoError(t, err)
+	}()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	inFlight := make(chan struct{})
+	release := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(inFlight)
+			<-release
+		}
+
+		_, _ = w.Write([]byte(r.Proto))
+	})}
+
+	grpcSrv := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(grpcSrv, health.NewServer())
+
+	require.NoError(t, xx.AddMux("http", lis, srv, grpcSrv, nil))
+
+	files, names, err := xx.Files()
+	require.NoError(t, err)
+	CloseFiles(files)
+	assert.Equal(t, []string{"http"}, names)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := make(chan error, 1)
+	go func() {
+		ch <- xx.Serve(ctx)
+	}()
+
+	url := "http://" + lis.Addr().String()
+
+	// Prior-knowledge HTTP/2, without TLS.
+	h2c := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+
+	get := func(client *http.Client, path string) string {
+		resp, err := client.Get(url + path)
+		if err != nil {
+			return err.Error()
+		}
+		defer resp.Body.Close()
+
+		b, _ := io.ReadAll(resp.Body)
+		return string(b)
+	}
+
+	assert.Equal(t, "HTTP/1.1", get(http.DefaultClient, "/"))
+	assert.Equal(t, "HTTP/2.0", get(h2c, "/"))
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	hresp, err := grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, hresp.Status)
+
+	respChan := make(chan string, 1)
+	go func() {
+		respChan <- get(h2c, "/slow")
+	}()
+
+	<-inFlight
+	cancel()
+
+	// The in-flight HTTP/2 request holds up shutdown.
+	select {
+	case err := <-ch:
+		t.Fatalf("serve stopped before draining: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(t, <-ch)
+	assert.Equal(t, "HTTP/2.0", <-respChan)
+}
+
+func TestMuxIdle(t *testing.T) {
+	xx := New(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer xx.Close()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	grpcSrv := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(grpcSrv, health.NewServer())
+
+	require.NoError(t, xx.AddMux("http", lis, &http.Server{}, grpcSrv, nil))
+	xx.servers[0].(*muxWrapper).sniffTimeout = 100 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() { _ = xx.Serve(ctx) }()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Connected ahead of the first call, which is made after the timeout.
+	conn.Connect()
+	require.Eventually(t, func() bool { return conn.GetState() == connectivity.Ready }, 5*time.Second, 10*time.Millisecond)
+
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, connectivity.Ready, conn.GetState())
+
+	hresp, err := grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, hresp.Status)
+}
@@ -0,0 +1,176 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multilistener
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// sharedListener allows multiple servers to accept from the same underlying
+// listener. Each server is given a view of the listener, which can be closed
+// without closing the underlying listener.
+type sharedListener struct {
+	net.Listener
+
+	once      sync.Once
+	closeOnce sync.Once
+	closeErr  error
+	results   chan acceptResult
+	closing   chan struct{}
+	done      chan struct{}
+	err       error
+
+	mu      sync.Mutex
+	waiting int           // Views blocked in Accept()
+	wake    chan struct{} // Signalled as views start waiting
+}
+
+func newSharedListener(lis net.Listener) *sharedListener {
+	return &sharedListener{
+		Listener: lis,
+		results:  make(chan acceptResult),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// setWaiting adjusts the number of views waiting for a connection.
+func (l *sharedListener) setWaiting(delta int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.waiting += delta
+	if l.waiting > 0 {
+		select {
+		case l.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (l *sharedListener) hasWaiting() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.waiting > 0
+}
+
+func (l *sharedListener) pump() {
+	defer close(l.done)
+
+	for {
+		// Only accept while a view is waiting. Otherwise connections are left queued,
+		// for a replacement server or for the process the listener is handed to.
+		for !l.hasWaiting() {
+			select {
+			case <-l.wake:
+			case <-l.closing:
+				l.err = net.ErrClosed
+				return
+			}
+		}
+
+		conn, err := l.Listener.Accept()
+
+		// Temporary errors are forwarded, the server will retry.
+		var ne net.Error
+		if err != nil && !(errors.As(err, &ne) && ne.Temporary()) { //nolint:staticcheck
+			l.err = err
+			return
+		}
+
+		// The view may have closed since, so it goes to whichever is next to accept.
+		select {
+		case l.results <- acceptResult{conn: conn, err: err}:
+			// The view is no longer waiting, and mustn't be counted as such.
+			l.setWaiting(-1)
+			continue
+		case <-l.closing:
+		}
+
+		// Give it to a view that's still accepting, rather than dropping the client.
+		select {
+		case l.results <- acceptResult{conn: conn, err: err}:
+		default:
+			if conn != nil {
+				_ = conn.Close()
+			}
+		}
+
+		l.err = net.ErrClosed
+		return
+	}
+}
+
+func (l *sharedListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closing)
+		l.closeErr = l.Listener.Close()
+	})
+	return l.closeErr
+}
+
+func (l *sharedListener) view() net.Listener {
+	l.once.Do(func() { go l.pump() })
+
+	return &listenerView{
+		parent: l,
+		closed: make(chan struct{}),
+	}
+}
+
+type listenerView struct {
+	parent    *sharedListener
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (v *listenerView) Accept() (net.Conn, error) {
+	select {
+	case <-v.closed:
+		return nil, net.ErrClosed
+	default:
+	}
+
+	// Counted until given a connection by pump(), which uncounts it.
+	v.parent.setWaiting(1)
+
+	select {
+	case r := <-v.parent.results:
+		return r.conn, r.err
+	case <-v.parent.done:
+		return nil, v.parent.err
+	case <-v.closed:
+		v.parent.setWaiting(-1)
+		return nil, net.ErrClosed
+	}
+}
+
+func (v *listenerView) Close() error {
+	v.closeOnce.Do(func() { close(v.closed) })
+	return nil
+}
+
+func (v *listenerView) Addr() net.Addr {
+	return v.parent.Addr()
+}
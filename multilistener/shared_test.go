@@ -0,0 +1,81 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multilistener
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharedListener(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	shared := newSharedListener(lis)
+	defer shared.Close()
+
+	// A replacement view is given connections made while there was none.
+	view := shared.view()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = view.Close()
+	}()
+	_, err = view.Accept()
+	assert.ErrorIs(t, err, net.ErrClosed)
+
+	client, err := net.Dial("tcp", lis.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	view = shared.view()
+	conn, err := view.Accept()
+	require.NoError(t, err)
+	_ = conn.Close()
+	_ = view.Close()
+
+	// Connections aren't accepted without a view, so they survive a handoff.
+	client, err = net.Dial("tcp", lis.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	f, err := lis.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.NoError(t, shared.Close())
+
+	inherited, err := net.FileListener(f)
+	require.NoError(t, err)
+	defer inherited.Close()
+
+	require.NoError(t, inherited.(*net.TCPListener).SetDeadline(time.Now().Add(5*time.Second)))
+	conn, err = inherited.Accept()
+	require.NoError(t, err)
+
+	_, err = io.WriteString(conn, "hello")
+	require.NoError(t, err)
+	_ = conn.Close()
+
+	b, err := io.ReadAll(client)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+}
@@ -16,26 +16,79 @@ package multilistener
 
 import (
 	"context"
+	"crypto/tls"
 	"io"
 	"io/fs"
 	"log/slog"
+	"net"
+	"time"
 )
 
 type ListenConfig struct {
+	// Name identifies the listener, e.g. for matching inherited sockets.
+	Name              string
 	BindAddress       string
 	BindNetwork       string
 	SocketPermissions fs.FileMode
+
+	// TLS, if set, is used to terminate TLS. See ListenHTTP() and ListenGRPC().
+	TLS *tls.Config
 }
 
 type wrapper interface {
 	io.Closer
 
+	// Serve runs until ctx is cancelled or it fails. Servers stop accepting once
+	// ctx is cancelled, leaving established connections for Shutdown().
 	Serve(ctx context.Context) error
 
 	Log() *slog.Logger
 }
 
+// serverWrapper is a wrapper whose connections must be drained upon shutdown.
+type serverWrapper interface {
+	wrapper
+
+	// Shutdown gracefully stops the server, forcibly closing it if ctx expires.
+	Shutdown(ctx context.Context) error
+}
+
+// listenerWrapper is a wrapper that serves on a listener.
+type listenerWrapper interface {
+	wrapper
+
+	listener() (string, net.Listener)
+}
+
+// Phase identifies a stage of shutdown.
+type Phase string
+
+const (
+	PhaseStopAccepting Phase = "stop_accepting"
+	PhaseDrainHTTP     Phase = "drain_http"
+	PhaseDrainGRPC     Phase = "drain_grpc"
+	PhaseStopWorkers   Phase = "stop_workers"
+)
+
+// ShutdownConfig controls how Serve() shuts down. Phases are run in order, each
+// bounded by its own timeout and by the overall timeout. Zero timeouts wait forever.
+type ShutdownConfig struct {
+	Timeout       time.Duration
+	HTTPTimeout   time.Duration
+	GRPCTimeout   time.Duration
+	WorkerTimeout time.Duration
+
+	// OnPhase, if set, is called as each phase completes. err is non-nil
+	// if the phase did not complete in time.
+	OnPhase func(phase Phase, took time.Duration, err error)
+}
+
 type MultiListener struct {
-	logger  *slog.Logger
-	servers []wrapper
+	logger   *slog.Logger
+	servers  []wrapper
+	shutdown ShutdownConfig
+
+	// Cancelled by Stop()
+	stopCtx context.Context
+	stop    context.CancelFunc
 }
@@ -15,11 +15,15 @@
 package multilistener
 
 import (
+	"crypto/tls"
 	"log/slog"
 	"net"
 	"os"
 )
 
+// Listen creates a listener for the given configuration. Sockets passed via
+// systemd socket activation are used in preference, matched by name (LISTEN_FDNAMES),
+// then by address.
 func Listen(cfg *ListenConfig, logger *slog.Logger) (net.Listener, error) {
 	logger = logger.With(
 		slog.String("bind_address", cfg.BindAddress),
@@ -27,7 +31,23 @@ func Listen(cfg *ListenConfig, logger *slog.Logger) (net.Listener, error) {
 		slog.String("socket_permissions", cfg.SocketPermissions.String()),
 	)
 
-	ln, err := net.Listen(cfg.BindNetwork, cfg.BindAddress)
+	if cfg.Name != "" {
+		logger = logger.With(slog.String("name", cfg.Name))
+	}
+
+	ln, err := takeInherited(cfg)
+	if err != nil {
+		logger.Error("error using inherited socket", slog.Any("error", err))
+		return nil, err
+	}
+
+	if ln != nil {
+		// The socket's owner is responsible for its permissions.
+		logger.Info("using inherited socket", slog.String("address", ln.Addr().String()))
+		return ln, nil
+	}
+
+	ln, err = net.Listen(cfg.BindNetwork, cfg.BindAddress)
 	if err != nil {
 		logger.Error("error listening", slog.Any("error", err))
 		return nil, err
@@ -46,3 +66,15 @@ func Listen(cfg *ListenConfig, logger *slog.Logger) (net.Listener, error) {
 
 	return ln, nil
 }
+
+// withNextProtos returns a copy of the TLS configuration, with the given
+// application protocols if it has none.
+func withNextProtos(cfg *tls.Config, protos ...string) *tls.Config {
+	if cfg == nil || len(cfg.NextProtos) > 0 {
+		return cfg
+	}
+
+	cfg = cfg.Clone()
+	cfg.NextProtos = protos
+	return cfg
+}
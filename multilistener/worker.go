@@ -0,0 +1,172 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multilistener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+)
+
+// WorkerFunc is a background task. It should return once ctx is cancelled.
+type WorkerFunc func(ctx context.Context) error
+
+type WorkerConfig struct {
+	// Restart the worker upon failure, instead of stopping everything.
+	Restart bool
+
+	// MinBackoff and MaxBackoff bound the exponential delay between restarts.
+	// MinBackoff is at least DefaultMinBackoff if unset, and MaxBackoff at least MinBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// StopTimeout is how long to wait for the worker to return once cancelled.
+	// Zero waits forever.
+	StopTimeout time.Duration
+}
+
+// DefaultMinBackoff is the delay before restarting a worker, if MinBackoff is unset.
+const DefaultMinBackoff = time.Second
+
+var ErrWorkerStopTimeout = errors.New("worker did not stop in time")
+
+type panicError struct {
+	value any
+	stack []byte
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+type workerWrapper struct {
+	name   string
+	fn     WorkerFunc
+	cfg    WorkerConfig
+	logger *slog.Logger
+}
+
+func (w *workerWrapper) run(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				done <- &panicError{value: v, stack: debug.Stack()}
+			}
+		}()
+
+		done <- w.fn(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	if w.cfg.StopTimeout <= 0 {
+		return <-done
+	}
+
+	t := time.NewTimer(w.cfg.StopTimeout)
+	defer t.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-t.C:
+		return ErrWorkerStopTimeout
+	}
+}
+
+func (w *workerWrapper) Serve(ctx context.Context) error {
+	// Without a floor, a failing worker is restarted in a tight loop.
+	minBackoff := w.cfg.MinBackoff
+	if minBackoff <= 0 {
+		minBackoff = DefaultMinBackoff
+	}
+
+	maxBackoff := max(w.cfg.MaxBackoff, minBackoff)
+	backoff := minBackoff
+
+	for {
+		started := time.Now()
+		err := w.run(ctx)
+
+		var pe *panicError
+		if errors.As(err, &pe) {
+			w.logger.Error("worker panicked", slog.Any("panic", pe.value), slog.String("stack", string(pe.stack)))
+		}
+
+		if ctx.Err() != nil {
+			if errors.Is(err, ErrWorkerStopTimeout) {
+				w.logger.Warn("worker did not stop in time", slog.Duration("timeout", w.cfg.StopTimeout))
+				return err
+			}
+
+			return nil
+		}
+
+		if err == nil {
+			// Finished early, this isn't a failure. Don't take everyone else down.
+			w.logger.Info("worker finished")
+			<-ctx.Done()
+			return nil
+		}
+
+		if !w.cfg.Restart {
+			return err
+		}
+
+		// It ran for a while, assume it was healthy.
+		if time.Since(started) > maxBackoff {
+			backoff = minBackoff
+		}
+
+		w.logger.Error("worker failed, restarting", slog.Any("error", err), slog.Duration("backoff", backoff))
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil
+		case <-t.C:
+		}
+
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func (w *workerWrapper) Log() *slog.Logger {
+	return w.logger
+}
+
+func (w *workerWrapper) Close() error {
+	return nil
+}
+
+// AddWorker runs a worker alongside the servers. As with servers, if the worker fails
+// everything is stopped, unless it is configured to restart.
+func (l *MultiListener) AddWorker(name string, fn WorkerFunc, cfg WorkerConfig) {
+	l.servers = append(l.servers, &workerWrapper{
+		name:   name,
+		fn:     fn,
+		cfg:    cfg,
+		logger: l.logger.With(slog.Int("index", len(l.servers)), slog.String("worker", name)),
+	})
+}
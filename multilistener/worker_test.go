@@ -0,0 +1,147 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multilistener
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	}))
+}
+
+func blockingWorker(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func TestWorkerFailureStopsAll(t *testing.T) {
+	t.Parallel()
+
+	errBoom := errors.New("boom")
+
+	xx := New(newTestLogger())
+	xx.AddWorker("blocking", blockingWorker, WorkerConfig{})
+	xx.AddWorker("failing", func(context.Context) error {
+		return errBoom
+	}, WorkerConfig{})
+
+	err := xx.Serve(context.Background())
+	assert.ErrorIs(t, err, errBoom)
+}
+
+func TestWorkerPanic(t *testing.T) {
+	t.Parallel()
+
+	xx := New(newTestLogger())
+	xx.AddWorker("panicking", func(context.Context) error {
+		panic("oops")
+	}, WorkerConfig{})
+
+	err := xx.Serve(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "oops")
+}
+
+func TestWorkerRestart(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var runs atomic.Int32
+
+	xx := New(newTestLogger())
+	xx.AddWorker("flaky", func(ctx context.Context) error {
+		if runs.Add(1) < 3 {
+			return errors.New("flake")
+		}
+
+		cancel()
+		<-ctx.Done()
+		return nil
+	}, WorkerConfig{Restart: true, MinBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond})
+
+	require.NoError(t, xx.Serve(ctx))
+	assert.Equal(t, int32(3), runs.Load())
+}
+
+func TestWorkerRestartDefaultBackoff(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	var runs atomic.Int32
+
+	xx := New(newTestLogger())
+	xx.AddWorker("failing", func(context.Context) error {
+		runs.Add(1)
+		return errors.New("boom")
+	}, WorkerConfig{Restart: true})
+
+	// Waits DefaultMinBackoff, rather than restarting straight away.
+	require.NoError(t, xx.Serve(ctx))
+	assert.Equal(t, int32(1), runs.Load())
+}
+
+func TestWorkerFinishedEarly(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	var stopped atomic.Bool
+
+	xx := New(newTestLogger())
+	xx.AddWorker("oneshot", func(context.Context) error {
+		return nil
+	}, WorkerConfig{})
+	xx.AddWorker("blocking", func(ctx context.Context) error {
+		<-ctx.Done()
+		stopped.Store(true)
+		return nil
+	}, WorkerConfig{})
+
+	require.NoError(t, xx.Serve(ctx))
+	assert.True(t, stopped.Load())
+	assert.ErrorIs(t, ctx.Err(), context.DeadlineExceeded)
+}
+
+func TestWorkerStopTimeout(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	xx := New(newTestLogger())
+	xx.AddWorker("stubborn", func(context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}, WorkerConfig{StopTimeout: 10 * time.Millisecond})
+
+	assert.ErrorIs(t, xx.Serve(ctx), ErrWorkerStopTimeout)
+}
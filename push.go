@@ -0,0 +1,285 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/common/model"
+
+	"github.com/vs49688/servicebase/internal/remotewrite"
+)
+
+const (
+	// The delay before the first retry of a failed push, doubled upon each retry
+	pushMinBackoff = 500 * time.Millisecond
+
+	// The maximum size of an error response body kept for the error message
+	pushMaxErrorBody = 512
+)
+
+// pushStatusError is a push rejected by the endpoint.
+type pushStatusError struct {
+	code int
+	body string
+}
+
+func (e *pushStatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d: %s", e.code, e.body)
+}
+
+// retryable reports whether a failed push may succeed if retried. Client errors, other
+// than rate limiting, won't.
+func (e *pushStatusError) retryable() bool {
+	return e.code == http.StatusTooManyRequests || e.code >= 500
+}
+
+// metricsPusher periodically pushes the metrics, and once more upon shutdown so short-lived
+// services don't lose their final values.
+type metricsPusher struct {
+	name   string
+	cfg    PushConfig
+	logger *slog.Logger
+	push   func(ctx context.Context) error
+}
+
+func (cfg *PushConfig) validate() error {
+	if cfg.URL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return err
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url: %q", cfg.URL)
+	}
+
+	if cfg.Interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+
+	if cfg.Timeout < 0 || cfg.Retries < 0 {
+		return errors.New("timeout and retries must not be negative")
+	}
+
+	for name := range cfg.Grouping {
+		if !model.LabelName(name).IsValidLegacy() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			return fmt.Errorf("invalid grouping label: %q", name)
+		}
+	}
+
+	return nil
+}
+
+// job returns the job label, defaulting to the program name.
+func (cfg *PushConfig) job() string {
+	if cfg.Job != "" {
+		return cfg.Job
+	}
+
+	return filepath.Base(os.Args[0])
+}
+
+// newPushgatewayPusher pushes to a Prometheus Pushgateway, replacing the metrics of the
+// job and grouping labels each time.
+func newPushgatewayPusher(cfg PushConfig, gatherer prometheus.Gatherer, logger *slog.Logger) *metricsPusher {
+	client := &statusDoer{client: &http.Client{}}
+	pusher := push.New(cfg.URL, cfg.job()).Gatherer(gatherer).Client(client)
+	for name, value := range cfg.Grouping {
+		pusher = pusher.Grouping(name, value)
+	}
+
+	return &metricsPusher{
+		name:   "pushgateway",
+		cfg:    cfg,
+		logger: logger,
+		push: func(ctx context.Context) error {
+			client.code = 0
+			err := pusher.PushContext(ctx)
+			if err != nil && client.code != 0 {
+				return &pushStatusError{code: client.code, body: err.Error()}
+			}
+
+			return err
+		},
+	}
+}
+
+// statusDoer remembers the status code of the last response, as the push package
+// doesn't return it.
+type statusDoer struct {
+	client *http.Client
+	code   int
+}
+
+func (d *statusDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := d.client.Do(req)
+	if err == nil && resp.StatusCode/100 != 2 {
+		d.code = resp.StatusCode
+	}
+
+	return resp, err
+}
+
+// newRemoteWritePusher pushes to a Prometheus remote-write endpoint. The job and grouping
+// labels are added to each series, unless it already has them.
+func newRemoteWritePusher(cfg PushConfig, gatherer prometheus.Gatherer, logger *slog.Logger) *metricsPusher {
+	labels := maps.Clone(cfg.Grouping)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[model.JobLabel] = cfg.job()
+
+	client := &http.Client{}
+
+	return &metricsPusher{
+		name:   "remote write",
+		cfg:    cfg,
+		logger: logger,
+		push: func(ctx context.Context) error {
+			mfs, err := gatherer.Gather()
+			if err != nil {
+				return err
+			}
+
+			body := snappy.Encode(nil, remotewrite.Marshal(remotewrite.FromMetricFamilies(mfs, labels, time.Now())))
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+
+			req.Header.Set("Content-Type", "application/x-protobuf")
+			req.Header.Set("Content-Encoding", "snappy")
+			req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+			resp, err := client.Do(req)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode/100 != 2 {
+				b, _ := io.ReadAll(io.LimitReader(resp.Body, pushMaxErrorBody))
+				return &pushStatusError{code: resp.StatusCode, body: strings.TrimSpace(string(b))}
+			}
+
+			_, _ = io.Copy(io.Discard, resp.Body)
+			return nil
+		},
+	}
+}
+
+func (p *metricsPusher) run(ctx context.Context) error {
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Push once more, regardless of the cancellation. This is bounded by the
+			// worker's stop timeout.
+			p.pushWithRetries(context.WithoutCancel(ctx))
+			return nil
+		case <-ticker.C:
+			p.pushWithRetries(ctx)
+		}
+	}
+}
+
+// pushWithRetries pushes the metrics, retrying with an exponential backoff. Failures are
+// logged, but otherwise ignored, so they don't stop the service.
+func (p *metricsPusher) pushWithRetries(ctx context.Context) {
+	backoff := pushMinBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := p.pushOnce(ctx)
+		if err == nil {
+			return
+		}
+
+		// Stopping, the final push follows.
+		if ctx.Err() != nil {
+			return
+		}
+
+		var statusErr *pushStatusError
+		if attempt >= p.cfg.Retries || (errors.As(err, &statusErr) && !statusErr.retryable()) {
+			p.logger.Warn("unable to push metrics",
+				slog.String("exporter", p.name),
+				slog.Int("attempts", attempt+1),
+				slog.Any("error", err),
+			)
+			return
+		}
+
+		p.logger.Debug("retrying metrics push",
+			slog.String("exporter", p.name),
+			slog.Any("error", err),
+		)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
+
+func (p *metricsPusher) pushOnce(ctx context.Context) error {
+	if p.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.cfg.Timeout)
+		defer cancel()
+	}
+
+	return p.push(ctx)
+}
+
+// startMetricsPush adds workers pushing the metrics to each configured endpoint.
+func startMetricsPush(cfg *MetricsPushConfig, gatherer prometheus.Gatherer, workers *WorkerGroup, logger *slog.Logger) {
+	var pushers []*metricsPusher
+	if cfg.Pushgateway.URL != "" {
+		pushers = append(pushers, newPushgatewayPusher(cfg.Pushgateway, gatherer, logger))
+	}
+
+	if cfg.RemoteWrite.URL != "" {
+		pushers = append(pushers, newRemoteWritePusher(cfg.RemoteWrite, gatherer, logger))
+	}
+
+	for _, p := range pushers {
+		workers.Go("metrics push "+p.name, p.run)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vs49688/servicebase"
+	"github.com/vs49688/servicebase/internal/remotewrite"
+	"github.com/vs49688/servicebase/servicetest"
+)
+
+func TestMetricsPush(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var gatewayPaths []string
+	var writes [][]remotewrite.TimeSeries
+
+	// The first push fails, so it's retried.
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		gatewayPaths = append(gatewayPaths, r.Method+" "+r.URL.Path)
+		if len(gatewayPaths) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+
+		b, err := io.ReadAll(r.Body)
+		if err == nil {
+			b, err = snappy.Decode(nil, b)
+		}
+
+		var series []remotewrite.TimeSeries
+		if err == nil {
+			series, err = remotewrite.Unmarshal(b)
+		}
+
+		if !assert.NoError(t, err) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		writes = append(writes, series)
+		mu.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	cfg := servicebase.DefaultServiceConfig()
+	cfg.MetricsPush.Pushgateway.URL = gateway.URL
+	cfg.MetricsPush.Pushgateway.Job = "test"
+	cfg.MetricsPush.Pushgateway.Grouping = map[string]string{"instance": "a"}
+	cfg.MetricsPush.Pushgateway.Interval = 50 * time.Millisecond
+
+	// Only pushed upon shutdown.
+	cfg.MetricsPush.RemoteWrite.URL = receiver.URL
+	cfg.MetricsPush.RemoteWrite.Job = "test"
+	cfg.MetricsPush.RemoteWrite.Grouping = map[string]string{"instance": "a"}
+	cfg.MetricsPush.RemoteWrite.Interval = time.Hour
+
+	t.Run("serve", func(t *testing.T) {
+		var counter prometheus.Counter
+		servicetest.Start(t, cfg, func(_ context.Context, params servicebase.ServiceParameters) (servicebase.Service, error) {
+			counter = prometheus.NewCounter(prometheus.CounterOpts{Name: "test_pushes_total"})
+			return &healthService{health: servicebase.HealthStatusHealthy}, params.Metrics.Registry.Register(counter)
+		}, servicetest.Options{InMemory: true})
+
+		require.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(gatewayPaths) >= 2
+		}, 5*time.Second, 10*time.Millisecond)
+
+		counter.Add(2)
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, p := range gatewayPaths {
+		assert.Equal(t, "PUT /metrics/job/test/instance/a", p)
+	}
+
+	require.Len(t, writes, 1)
+
+	var found bool
+	for _, ts := range writes[0] {
+		assert.Equal(t, "test", ts.Label("job"))
+		assert.Equal(t, "a", ts.Label("instance"))
+		require.Len(t, ts.Samples, 1)
+
+		if ts.Label("__name__") == "test_pushes_total" {
+			found = true
+			assert.Equal(t, 2.0, ts.Samples[0].Value)
+		}
+	}
+	assert.True(t, found)
+}
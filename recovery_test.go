@@ -0,0 +1,91 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase_test
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/vs49688/servicebase"
+	"github.com/vs49688/servicebase/servicetest"
+)
+
+type panickingHealthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+}
+
+func (panickingHealthServer) Check(context.Context, *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	panic("grpc oops")
+}
+
+func TestPanicRecovery(t *testing.T) {
+	t.Parallel()
+
+	h := servicetest.Start(t, servicebase.DefaultServiceConfig(), func(_ context.Context, params servicebase.ServiceParameters) (servicebase.Service, error) {
+		params.ApplicationRouter.HandleFunc("/panic", func(http.ResponseWriter, *http.Request) {
+			panic("http oops")
+		})
+
+		grpc_health_v1.RegisterHealthServer(params.GRPCRegistrar, panickingHealthServer{})
+		return &healthService{health: servicebase.HealthStatusHealthy}, nil
+	}, servicetest.Options{InMemory: true})
+
+	resp, err := h.HTTPClient.Get(h.BaseURL + "/panic")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	_, err = grpc_health_v1.NewHealthClient(h.GRPCConn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	families, err := h.Registry.Gather()
+	require.NoError(t, err)
+
+	var panics float64
+	for _, mf := range families {
+		if mf.GetName() == "panics_total" {
+			for _, m := range mf.GetMetric() {
+				panics += m.GetCounter().GetValue()
+			}
+		}
+	}
+	assert.Equal(t, 2.0, panics)
+
+	var logged int
+	for _, r := range h.Logs.Records() {
+		if r.Message != "handler panicked" {
+			continue
+		}
+
+		attrs := map[string]string{}
+		r.Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = a.Value.String()
+			return true
+		})
+
+		assert.NotEmpty(t, attrs["request_id"])
+		assert.NotEmpty(t, attrs["stack"])
+		logged++
+	}
+	assert.Equal(t, 2, logged)
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+)
+
+// loadConfig merges the config sources over the configuration the service was
+// started with, so settings removed from them revert.
+func (sw *serviceBase) loadConfig(ctx context.Context) (ServiceConfig, error) {
+	next := sw.baseCfg
+
+	for i, src := range sw.configSources {
+		cfg, err := src(ctx)
+		if err != nil {
+			return ServiceConfig{}, fmt.Errorf("config source %d: %w", i, err)
+		}
+
+		MergeServiceConfig(&next, cfg)
+	}
+
+	if err := next.Validate(); err != nil {
+		return ServiceConfig{}, err
+	}
+
+	return next, nil
+}
+
+// warnRestartRequired logs any changes that can't be applied live.
+func (sw *serviceBase) warnRestartRequired(next *ServiceConfig) {
+	cur := &sw.cfg
+
+	changed := func(name string, a, b any) {
+		if !reflect.DeepEqual(a, b) {
+			sw.logger.Warn("configuration change requires restart", slog.String("setting", name))
+		}
+	}
+
+	changed("http listener", cur.HTTP.ListenConfig, next.HTTP.ListenConfig)
+	changed("http path prefix", cur.HTTP.PathPrefix, next.HTTP.PathPrefix)
+	changed("http serve grpc", cur.HTTP.ServeGRPC, next.HTTP.ServeGRPC)
+	changed("http legacy request metrics", cur.HTTP.LegacyRequestMetrics, next.HTTP.LegacyRequestMetrics)
+	changed("grpc listener", cur.GRPC.ListenConfig, next.GRPC.ListenConfig)
+	changed("grpc-web", cur.GRPC.EnableWeb, next.GRPC.EnableWeb)
+	changed("grpc-web path", cur.GRPC.WebPath, next.GRPC.WebPath)
+	changed("grpc-web allowed origins", cur.GRPC.WebAllowedOrigins, next.GRPC.WebAllowedOrigins)
+	changed("grpc transcoding", cur.GRPC.EnableTranscoding, next.GRPC.EnableTranscoding)
+	changed("grpc transcoding prefix", cur.GRPC.TranscodingPrefix, next.GRPC.TranscodingPrefix)
+	changed("admin listener", cur.Admin.ListenConfig, next.Admin.ListenConfig)
+	changed("shutdown timeout", cur.ShutdownTimeout, next.ShutdownTimeout)
+	changed("shutdown phase timeouts", cur.Shutdown, next.Shutdown)
+	changed("metrics", cur.Metrics, next.Metrics)
+	changed("tracing", cur.Tracing, next.Tracing)
+	changed("metrics push", cur.MetricsPush, next.MetricsPush)
+	changed("dev tls", cur.DevTLS, next.DevTLS)
+	changed("request id", cur.DisableRequestID, next.DisableRequestID)
+	changed("request id settings", cur.RequestID, next.RequestID)
+}
+
+// reload re-reads the configuration and applies what can be changed live.
+// Upon error, the running configuration is left untouched.
+func (sw *serviceBase) reload(ctx context.Context) error {
+	next, err := sw.loadConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	sw.warnRestartRequired(&next)
+
+	if r, ok := sw.svc.(Reloadable); ok {
+		if err := r.Reload(ctx, next); err != nil {
+			return fmt.Errorf("service rejected configuration: %w", err)
+		}
+	}
+
+	sw.applyConfig(&next)
+	sw.cfg = next
+
+	// Certificate paths can't change, but their contents can.
+	sw.reloadCertificates(true)
+	return nil
+}
+
+func (sw *serviceBase) applyConfig(cfg *ServiceConfig) {
+	sw.logLevel.Set(cfg.LogLevel)
+
+	if sw.logSwap != nil && cfg.LogFormat != sw.cfg.LogFormat {
+		sw.logSwap.Swap(newFormatHandler(os.Stdout, cfg.LogFormat, &sw.logLevel))
+	}
+
+	sw.accessLog.Store(!cfg.HTTP.DisableAccessLog)
+	sw.crashOnPanic.Store(cfg.CrashOnPanic)
+	sw.healthStatusCodes.Store(&cfg.HTTP.HealthStatusCodes)
+
+	if cfg.HTTP.ReadHeaderTimeout != sw.cfg.HTTP.ReadHeaderTimeout {
+		for i, old := range sw.httpServers {
+			sw.httpServers[i] = sw.newHTTPServer(&cfg.HTTP, sw.httpListeners[i])
+			sw.multiListener.ReplaceHTTP(old, sw.httpServers[i])
+			sw.retiredServers = append(sw.retiredServers, old)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vs49688/servicebase/multilistener"
+)
+
+type reloadableService struct {
+	err error
+	cfg *ServiceConfig
+}
+
+func (s *reloadableService) GetHealth(context.Context) (*GetHealthResponse, error) {
+	return &GetHealthResponse{Status: HealthStatusHealthy}, nil
+}
+
+func (s *reloadableService) Close(context.Context) error {
+	return nil
+}
+
+func (s *reloadableService) Reload(_ context.Context, cfg ServiceConfig) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	s.cfg = &cfg
+	return nil
+}
+
+func newReloadTestBase(t *testing.T, svc Service, config string) *serviceBase {
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(config), 0600))
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	sw := &serviceBase{
+		cfg:           DefaultServiceConfig(),
+		baseCfg:       DefaultServiceConfig(),
+		configSources: []ConfigSource{JSONConfigFile(path)},
+		logger:        logger,
+		multiListener: multilistener.New(logger),
+		svc:           svc,
+	}
+	sw.httpListeners = []ListenerInfo{{Protocol: "http"}}
+	sw.httpServers = []*http.Server{sw.newHTTPServer(&sw.cfg.HTTP, sw.httpListeners[0])}
+	sw.logLevel.Set(sw.cfg.LogLevel)
+	sw.accessLog.Store(true)
+	return sw
+}
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Applied", func(t *testing.T) {
+		svc := &reloadableService{}
+		sw := newReloadTestBase(t, svc, `{
+			"log_level": "DEBUG",
+			"http": {"disable_access_log": true, "read_header_timeout": 1000000000}
+		}`)
+		oldServer := sw.httpServers[0]
+
+		require.NoError(t, sw.reload(context.Background()))
+
+		assert.Equal(t, slog.LevelDebug, sw.logLevel.Level())
+		assert.False(t, sw.accessLog.Load())
+		assert.NotSame(t, oldServer, sw.httpServers[0])
+		assert.Equal(t, []*http.Server{oldServer}, sw.retiredServers)
+		assert.Equal(t, time.Second, sw.httpServers[0].ReadHeaderTimeout)
+		require.NotNil(t, svc.cfg)
+		assert.Equal(t, slog.LevelDebug, svc.cfg.LogLevel)
+		assert.Equal(t, *svc.cfg, sw.cfg)
+	})
+
+	t.Run("Removed", func(t *testing.T) {
+		svc := &reloadableService{}
+		sw := newReloadTestBase(t, svc, `{}`)
+
+		path := filepath.Join(t.TempDir(), "config.json")
+		sw.configSources = []ConfigSource{JSONConfigFile(path)}
+
+		require.NoError(t, os.WriteFile(path, []byte(`{"log_level": "DEBUG"}`), 0600))
+		require.NoError(t, sw.reload(context.Background()))
+		assert.Equal(t, slog.LevelDebug, sw.logLevel.Level())
+
+		// No longer set, so back to the default rather than kept.
+		require.NoError(t, os.WriteFile(path, []byte(`{}`), 0600))
+		require.NoError(t, sw.reload(context.Background()))
+		assert.Equal(t, slog.LevelInfo, sw.logLevel.Level())
+		assert.Equal(t, DefaultServiceConfig(), sw.cfg)
+	})
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		svc := &reloadableService{}
+		sw := newReloadTestBase(t, svc, `{"log_level": "DEBUG", "log_format": "xml"}`)
+
+		assert.Error(t, sw.reload(context.Background()))
+		assert.Equal(t, slog.LevelInfo, sw.logLevel.Level())
+		assert.Nil(t, svc.cfg)
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		svc := &reloadableService{err: errors.New("nope")}
+		sw := newReloadTestBase(t, svc, `{"log_level": "DEBUG"}`)
+
+		assert.Error(t, sw.reload(context.Background()))
+		assert.Equal(t, slog.LevelInfo, sw.logLevel.Level())
+		assert.Equal(t, DefaultServiceConfig(), sw.cfg)
+	})
+}
+
+func TestInvalidConfigAtStartup(t *testing.T) {
+	cfg := DefaultServiceConfig()
+	cfg.LogFormat = "xml"
+
+	called := false
+	err := RunServiceWithOptions(context.Background(), cfg, func(context.Context, ServiceParameters) (Service, error) {
+		called = true
+		return &reloadableService{}, nil
+	}, RunOptions{DisableSignals: true})
+
+	assert.ErrorContains(t, err, "invalid log format")
+	assert.False(t, called)
+}
@@ -0,0 +1,131 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/vs49688/servicebase/internal/middleware/requestid"
+)
+
+const (
+	RequestIDGeneratorUUIDv4 = "uuidv4"
+	RequestIDGeneratorUUIDv7 = "uuidv7"
+	RequestIDGeneratorULID   = "ulid"
+)
+
+var requestIDGenerators = map[string]requestid.Generator{
+	RequestIDGeneratorUUIDv4: requestid.NewUUIDv4,
+	RequestIDGeneratorUUIDv7: requestid.NewUUIDv7,
+	RequestIDGeneratorULID:   requestid.NewULID,
+}
+
+// validMetadataKey reports whether key may be sent as ASCII GRPC metadata.
+func validMetadataKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "grpc-") || strings.HasSuffix(key, "-bin") {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' && c != '_' && c != '.' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// withDefaults returns a copy of the configuration, with unset fields taken from
+// DefaultRequestIDConfig().
+func (cfg *RequestIDConfig) withDefaults() RequestIDConfig {
+	def := DefaultRequestIDConfig()
+	out := *cfg
+
+	if out.Header == "" {
+		out.Header = def.Header
+	}
+
+	if out.MetadataKey == "" {
+		out.MetadataKey = def.MetadataKey
+	}
+
+	if out.Aliases == nil {
+		out.Aliases = def.Aliases
+	}
+
+	if out.Generator == "" {
+		out.Generator = def.Generator
+	}
+
+	if out.MaxLength == 0 {
+		out.MaxLength = def.MaxLength
+	}
+
+	return out
+}
+
+// validate checks the configuration, once unset fields are defaulted.
+func (cfg *RequestIDConfig) validate() error {
+	c := cfg.withDefaults()
+	cfg = &c
+
+	if !httpguts.ValidHeaderFieldName(cfg.Header) {
+		return fmt.Errorf("invalid header: %q", cfg.Header)
+	}
+
+	if !validMetadataKey(cfg.MetadataKey) {
+		return fmt.Errorf("invalid metadata key: %q", cfg.MetadataKey)
+	}
+
+	for _, alias := range cfg.Aliases {
+		if !httpguts.ValidHeaderFieldName(alias) || !validMetadataKey(strings.ToLower(alias)) {
+			return fmt.Errorf("invalid alias: %q", alias)
+		}
+	}
+
+	if _, ok := requestIDGenerators[cfg.Generator]; !ok {
+		return fmt.Errorf("invalid generator: %q", cfg.Generator)
+	}
+
+	if cfg.MaxLength <= 0 {
+		return errors.New("max length must not be negative")
+	}
+
+	return nil
+}
+
+// options returns the options of the request ID middleware. Invalid options are
+// rejected, as they'd otherwise fail upon the first request.
+func (cfg *RequestIDConfig) options() (*requestid.Options, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("request id: %w", err)
+	}
+
+	c := cfg.withDefaults()
+	cfg = &c
+
+	return &requestid.Options{
+		HeaderName:  cfg.Header,
+		MetadataKey: cfg.MetadataKey,
+		Aliases:     cfg.Aliases,
+		MaxLength:   cfg.MaxLength,
+		Generator:   requestIDGenerators[cfg.Generator],
+	}, nil
+}
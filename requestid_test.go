@@ -0,0 +1,192 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/vs49688/servicebase"
+	"github.com/vs49688/servicebase/servicetest"
+)
+
+func startRequestIDService(t *testing.T, cfg servicebase.ServiceConfig) *servicetest.Harness {
+	t.Helper()
+
+	return servicetest.Start(t, cfg, func(_ context.Context, params servicebase.ServiceParameters) (servicebase.Service, error) {
+		// Only writes the body, never calling WriteHeader() itself.
+		params.ApplicationRouter.HandleFunc("/id", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, servicebase.GetRequestID(r.Context()))
+		})
+
+		return &healthService{health: servicebase.HealthStatusHealthy}, nil
+	}, servicetest.Options{InMemory: true})
+}
+
+func getRequestID(t *testing.T, h *servicetest.Harness, header, value string) (string, http.Header) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, h.BaseURL+"/id", nil)
+	require.NoError(t, err)
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+
+	resp, err := h.HTTPClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(b), resp.Header
+}
+
+func TestRequestID(t *testing.T) {
+	t.Parallel()
+
+	h := startRequestIDService(t, servicebase.DefaultServiceConfig())
+
+	t.Run("generated", func(t *testing.T) {
+		id, header := getRequestID(t, h, "", "")
+		assert.Equal(t, id, header.Get("X-Request-ID"))
+
+		u, err := uuid.Parse(id)
+		require.NoError(t, err)
+		assert.Equal(t, uuid.Version(4), u.Version())
+	})
+
+	t.Run("accepted", func(t *testing.T) {
+		id, header := getRequestID(t, h, "X-Request-ID", " abc-123 ")
+		assert.Equal(t, "abc-123", id)
+		assert.Equal(t, "abc-123", header.Get("X-Request-ID"))
+	})
+
+	t.Run("alias", func(t *testing.T) {
+		id, header := getRequestID(t, h, "X-Correlation-ID", "corr-1")
+		assert.Equal(t, "corr-1", id)
+		assert.Equal(t, "corr-1", header.Get("X-Request-ID"))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, value := range []string{"<script>", "a b", strings.Repeat("a", 129)} {
+			id, header := getRequestID(t, h, "X-Request-ID", value)
+			assert.NotEqual(t, value, id)
+			assert.Equal(t, id, header.Get("X-Request-ID"))
+			_, err := uuid.Parse(id)
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		client := grpc_health_v1.NewHealthClient(h.GRPCConn)
+
+		var md metadata.MD
+		_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}, grpc.Header(&md))
+		require.NoError(t, err)
+		require.Len(t, md.Get("servicebase_request_id"), 1)
+		_, err = uuid.Parse(md.Get("servicebase_request_id")[0])
+		assert.NoError(t, err)
+
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "x-correlation-id", "grpc-1")
+		_, err = client.Check(ctx, &grpc_health_v1.HealthCheckRequest{}, grpc.Header(&md))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"grpc-1"}, md.Get("servicebase_request_id"))
+
+		ctx = metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "grpc-2")
+		_, err = client.Check(ctx, &grpc_health_v1.HealthCheckRequest{}, grpc.Header(&md))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"grpc-2"}, md.Get("servicebase_request_id"))
+	})
+}
+
+func TestRequestIDConfig(t *testing.T) {
+	t.Parallel()
+
+	cfg := servicebase.DefaultServiceConfig()
+	cfg.RequestID.Header = "X-Trace-Token"
+	cfg.RequestID.MetadataKey = "trace-token"
+	cfg.RequestID.Generator = servicebase.RequestIDGeneratorULID
+	cfg.RequestID.MaxLength = 8
+
+	h := startRequestIDService(t, cfg)
+
+	id, header := getRequestID(t, h, "X-Trace-Token", "123456789")
+	assert.Len(t, id, 26)
+	assert.Equal(t, id, header.Get("X-Trace-Token"))
+	assert.Empty(t, header.Get("X-Request-ID"))
+
+	id, header = getRequestID(t, h, "X-Trace-Token", "12345678")
+	assert.Equal(t, "12345678", id)
+	assert.Equal(t, id, header.Get("X-Trace-Token"))
+
+	var md metadata.MD
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "trace-token", "grpc-1")
+	_, err := grpc_health_v1.NewHealthClient(h.GRPCConn).Check(ctx, &grpc_health_v1.HealthCheckRequest{}, grpc.Header(&md))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"grpc-1"}, md.Get("trace-token"))
+
+	cfg.RequestID.MetadataKey = "Trace-Token"
+	assert.Error(t, cfg.Validate())
+
+	cfg.RequestID.MetadataKey = "trace-token"
+	cfg.RequestID.Generator = "uuidv1"
+	assert.Error(t, cfg.Validate())
+}
+
+func TestInvalidRequestIDConfig(t *testing.T) {
+	t.Parallel()
+
+	for name, mutate := range map[string]func(cfg *servicebase.RequestIDConfig){
+		"generator":  func(cfg *servicebase.RequestIDConfig) { cfg.Generator = "uuid7" },
+		"max length": func(cfg *servicebase.RequestIDConfig) { cfg.MaxLength = -1 },
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := servicebase.DefaultServiceConfig()
+			mutate(&cfg.RequestID)
+
+			err := servicebase.RunServiceWithOptions(context.Background(), cfg, func(context.Context, servicebase.ServiceParameters) (servicebase.Service, error) {
+				t.Fatal("service created")
+				return nil, nil
+			}, servicebase.RunOptions{DisableSignals: true})
+			assert.ErrorContains(t, err, "request id")
+		})
+	}
+}
+
+func TestRequestIDConfigDefaults(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, (&servicebase.ServiceConfig{DisableRequestID: true}).Validate())
+
+	cfg := servicebase.DefaultServiceConfig()
+	cfg.RequestID = servicebase.RequestIDConfig{Generator: servicebase.RequestIDGeneratorULID}
+	require.NoError(t, cfg.Validate())
+
+	h := startRequestIDService(t, cfg)
+
+	id, header := getRequestID(t, h, "", "")
+	assert.Len(t, id, 26)
+	assert.Equal(t, id, header.Get("X-Request-ID"))
+}
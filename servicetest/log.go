@@ -0,0 +1,124 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicetest
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+type logStore struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+// LogRecorder is a slog.Handler that captures every record it receives.
+type LogRecorder struct {
+	store  *logStore
+	attrs  []slog.Attr
+	groups []string
+}
+
+func NewLogRecorder() *LogRecorder {
+	return &LogRecorder{store: &logStore{}}
+}
+
+func (h *LogRecorder) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *LogRecorder) Handle(_ context.Context, r slog.Record) error {
+	r = r.Clone()
+
+	// Flatten any handler-level attributes and groups into the record so
+	// consumers don't need to care how the logger was built.
+	if len(h.groups) > 0 {
+		var attrs []slog.Attr
+		r.Attrs(func(a slog.Attr) bool {
+			attrs = append(attrs, a)
+			return true
+		})
+
+		nr := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+		nr.AddAttrs(h.attrs...)
+		nr.AddAttrs(nestAttrs(h.groups, attrs))
+		r = nr
+	} else if len(h.attrs) > 0 {
+		nr := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+		nr.AddAttrs(h.attrs...)
+		r.Attrs(func(a slog.Attr) bool {
+			nr.AddAttrs(a)
+			return true
+		})
+		r = nr
+	}
+
+	h.store.mu.Lock()
+	defer h.store.mu.Unlock()
+
+	h.store.records = append(h.store.records, r)
+	return nil
+}
+
+func (h *LogRecorder) WithAttrs(attrs []slog.Attr) slog.Handler {
+	nh := *h
+	if len(h.groups) > 0 {
+		nh.attrs = append(slices.Clone(h.attrs), nestAttrs(h.groups, attrs))
+	} else {
+		nh.attrs = append(slices.Clone(h.attrs), attrs...)
+	}
+	return &nh
+}
+
+func (h *LogRecorder) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	nh := *h
+	nh.groups = append(slices.Clone(h.groups), name)
+	return &nh
+}
+
+// Records returns a copy of all records captured so far.
+func (h *LogRecorder) Records() []slog.Record {
+	h.store.mu.Lock()
+	defer h.store.mu.Unlock()
+
+	return slices.Clone(h.store.records)
+}
+
+// Messages returns the messages of all records captured so far.
+func (h *LogRecorder) Messages() []string {
+	records := h.Records()
+
+	msgs := make([]string, 0, len(records))
+	for _, r := range records {
+		msgs = append(msgs, r.Message)
+	}
+
+	return msgs
+}
+
+func nestAttrs(groups []string, attrs []slog.Attr) slog.Attr {
+	a := slog.Attr{Key: groups[len(groups)-1], Value: slog.GroupValue(attrs...)}
+	for i := len(groups) - 2; i >= 0; i-- {
+		a = slog.Attr{Key: groups[i], Value: slog.GroupValue(a)}
+	}
+
+	return a
+}
@@ -0,0 +1,259 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package servicetest runs services built for servicebase.RunService()
+// in-process, for end-to-end testing without binding well-known ports.
+package servicetest
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/vs49688/servicebase"
+	"github.com/vs49688/servicebase/multilistener"
+)
+
+const (
+	bufconnSize     = 1024 * 1024
+	startTimeout    = 10 * time.Second
+	shutdownTimeout = 10 * time.Second
+)
+
+type Options struct {
+	// InMemory serves over in-memory (bufconn) listeners instead of
+	// ephemeral loopback ports.
+	InMemory bool
+
+	// TLSClientConfig, if set, is used by the clients to connect to TLS listeners.
+	TLSClientConfig *tls.Config
+}
+
+// Harness is a running service.
+type Harness struct {
+	Service servicebase.Service
+	Metrics servicebase.Metrics
+
+	// Registry is the service's Prometheus registry.
+	Registry *prometheus.Registry
+
+	// Logs holds every record logged by the service.
+	Logs *LogRecorder
+
+	// HTTPClient is a client connected to the HTTP server. Requests should be
+	// made against BaseURL. nil if HTTP is disabled.
+	HTTPClient *http.Client
+	HTTPAddr   net.Addr
+	BaseURL    string
+
+	// HTTPAddrs and GRPCAddrs are the addresses of every listener, in order.
+	HTTPAddrs []net.Addr
+	GRPCAddrs []net.Addr
+
+	// AdminClient is a client connected to the admin server. Requests should be
+	// made against AdminURL. nil if the admin listener is disabled.
+	AdminClient *http.Client
+	AdminAddrs  []net.Addr
+	AdminURL    string
+
+	// GRPCConn is a client connected to the GRPC server. nil if GRPC is disabled.
+	GRPCConn grpc.ClientConnInterface
+	GRPCAddr net.Addr
+}
+
+// Start runs the service in the background, returning once it is ready
+// to serve. The service is stopped when the test completes.
+func Start(t testing.TB, cfg servicebase.ServiceConfig, factory servicebase.ServiceFactory, opts Options) *Harness {
+	t.Helper()
+
+	h := &Harness{Logs: NewLogRecorder()}
+
+	listeners := map[net.Addr]*memListener{}
+	listen := func(_ *multilistener.ListenConfig, _ *slog.Logger) (net.Listener, error) {
+		if !opts.InMemory {
+			return net.Listen("tcp", "127.0.0.1:0")
+		}
+
+		lis := &memListener{
+			Listener: bufconn.Listen(bufconnSize),
+			addr:     memAddr(fmt.Sprintf("bufconn-%d", len(listeners))),
+		}
+		listeners[lis.addr] = lis
+		return lis, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	readyChan := make(chan servicebase.ReadyInfo, 1)
+	doneChan := make(chan error, 1)
+	go func() {
+		doneChan <- servicebase.RunServiceWithOptions(ctx, cfg, factory, servicebase.RunOptions{
+			LogHandler:     h.Logs,
+			Listen:         listen,
+			DisableSignals: true,
+			OnReady: func(info servicebase.ReadyInfo) {
+				readyChan <- info
+			},
+		})
+	}()
+
+	var info servicebase.ReadyInfo
+	select {
+	case info = <-readyChan:
+	case err := <-doneChan:
+		cancel()
+		t.Fatalf("service exited during startup: %v", err)
+	case <-time.After(startTimeout):
+		cancel()
+		t.Fatalf("service did not start within %v", startTimeout)
+	}
+
+	t.Cleanup(func() {
+		if h.HTTPClient != nil {
+			h.HTTPClient.CloseIdleConnections()
+		}
+
+		if h.AdminClient != nil {
+			h.AdminClient.CloseIdleConnections()
+		}
+
+		if conn, ok := h.GRPCConn.(*grpc.ClientConn); ok {
+			_ = conn.Close()
+		}
+
+		cancel()
+
+		select {
+		case err := <-doneChan:
+			if err != nil {
+				t.Errorf("service terminated with error: %v", err)
+			}
+		case <-time.After(shutdownTimeout):
+			t.Errorf("service did not stop within %v", shutdownTimeout)
+		}
+	})
+
+	h.Service = info.Service
+	h.Metrics = info.Metrics
+	h.Registry = info.Metrics.Registry
+	h.HTTPAddrs = info.HTTPAddrs
+	h.GRPCAddrs = info.GRPCAddrs
+	h.AdminAddrs = info.AdminAddrs
+
+	if len(info.HTTPAddrs) > 0 {
+		h.HTTPAddr = info.HTTPAddrs[0]
+		h.HTTPClient, h.BaseURL = newHTTPClient(h.HTTPAddr, listeners[h.HTTPAddr], clientTLS(&cfg.HTTP.ListenConfig, opts))
+	}
+
+	if len(info.AdminAddrs) > 0 {
+		h.AdminClient, h.AdminURL = newHTTPClient(info.AdminAddrs[0], listeners[info.AdminAddrs[0]], clientTLS(&cfg.Admin.ListenConfig, opts))
+	}
+
+	if len(info.GRPCAddrs) > 0 {
+		h.GRPCAddr = info.GRPCAddrs[0]
+
+		// The HTTP listeners come first if they also serve GRPC.
+		grpcListen := &cfg.GRPC.ListenConfig
+		if cfg.HTTP.Enabled && cfg.HTTP.ServeGRPC {
+			grpcListen = &cfg.HTTP.ListenConfig
+		}
+
+		conn, err := newGRPCConn(h.GRPCAddr, listeners[h.GRPCAddr], clientTLS(grpcListen, opts))
+		if err != nil {
+			t.Fatalf("unable to create grpc client: %v", err)
+		}
+
+		h.GRPCConn = conn
+	}
+
+	return h
+}
+
+// memAddr is the address of an in-memory listener. Unlike bufconn's own
+// address, each one is distinct.
+type memAddr string
+
+func (a memAddr) Network() string {
+	return "bufconn"
+}
+
+func (a memAddr) String() string {
+	return string(a)
+}
+
+type memListener struct {
+	*bufconn.Listener
+	addr memAddr
+}
+
+func (l *memListener) Addr() net.Addr {
+	return l.addr
+}
+
+// clientTLS returns the client TLS configuration for a listener, or nil if it doesn't use TLS.
+func clientTLS(cfg *servicebase.ListenConfig, opts Options) *tls.Config {
+	if !cfg.TLS.Enabled() {
+		return nil
+	}
+
+	if opts.TLSClientConfig == nil {
+		return &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	return opts.TLSClientConfig
+}
+
+func newHTTPClient(addr net.Addr, lis *memListener, tlsConfig *tls.Config) (*http.Client, string) {
+	scheme := "http://"
+	if tlsConfig != nil {
+		scheme = "https://"
+	}
+
+	transport := &http.Transport{TLSClientConfig: tlsConfig, ForceAttemptHTTP2: true}
+	if lis != nil {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}
+	}
+
+	return &http.Client{Transport: transport}, scheme + addr.String()
+}
+
+func newGRPCConn(addr net.Addr, lis *memListener, tlsConfig *tls.Config) (*grpc.ClientConn, error) {
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if tlsConfig != nil {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))}
+	}
+
+	if lis == nil {
+		return grpc.NewClient("passthrough:///"+addr.String(), opts...)
+	}
+
+	opts = append(opts, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}))
+
+	return grpc.NewClient("passthrough:///"+addr.String(), opts...)
+}
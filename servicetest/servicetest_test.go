@@ -0,0 +1,246 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicetest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/vs49688/servicebase"
+)
+
+type testService struct{}
+
+func (s *testService) GetHealth(context.Context) (*servicebase.GetHealthResponse, error) {
+	return &servicebase.GetHealthResponse{Status: servicebase.HealthStatusHealthy}, nil
+}
+
+func (s *testService) Close(context.Context) error {
+	return nil
+}
+
+func testFactory(_ context.Context, params servicebase.ServiceParameters) (servicebase.Service, error) {
+	params.ApplicationRouter.HandleFunc("/teapot", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = w.Write([]byte("im a teapot"))
+	})
+
+	grpc_health_v1.RegisterHealthServer(params.GRPCRegistrar, health.NewServer())
+	return &testService{}, nil
+}
+
+func TestHarness(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		opts Options
+	}{
+		{name: "Ephemeral", opts: Options{}},
+		{name: "InMemory", opts: Options{InMemory: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := Start(t, servicebase.DefaultServiceConfig(), testFactory, tc.opts)
+
+			resp, err := h.HTTPClient.Get(h.BaseURL + "/teapot")
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+			assert.Equal(t, "im a teapot", string(body))
+			assert.NotEmpty(t, resp.Header.Get("X-Request-ID"))
+
+			hresp, err := grpc_health_v1.NewHealthClient(h.GRPCConn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+			require.NoError(t, err)
+			assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, hresp.Status)
+
+			families, err := h.Registry.Gather()
+			require.NoError(t, err)
+			names := make([]string, 0, len(families))
+			for _, mf := range families {
+				names = append(names, mf.GetName())
+			}
+			assert.Contains(t, names, "http_request_duration_seconds")
+
+			assert.True(t, slices.ContainsFunc(h.Logs.Messages(), func(msg string) bool {
+				return strings.Contains(msg, "GET /teapot")
+			}))
+		})
+	}
+}
+
+func TestHarnessAdmin(t *testing.T) {
+	t.Parallel()
+
+	cfg := servicebase.DefaultServiceConfig()
+	cfg.Admin.Enabled = true
+	cfg.HTTP.EnableDebug = true
+
+	h := Start(t, cfg, testFactory, Options{InMemory: true})
+	require.NotNil(t, h.AdminClient)
+
+	get := func(client *http.Client, url string) int {
+		resp, err := client.Get(url)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode
+	}
+
+	for _, p := range []string{"/metrics", "/health", "/health/ready", "/debug/pprof/"} {
+		assert.Equal(t, http.StatusOK, get(h.AdminClient, h.AdminURL+p), p)
+		assert.Equal(t, http.StatusNotFound, get(h.HTTPClient, h.BaseURL+p), p)
+	}
+
+	assert.Equal(t, http.StatusTeapot, get(h.HTTPClient, h.BaseURL+"/teapot"))
+	assert.Equal(t, http.StatusNotFound, get(h.AdminClient, h.AdminURL+"/teapot"))
+}
+
+// testCertificate writes a self-signed certificate for localhost, returning its
+// configuration and a pool trusting it.
+func testCertificate(t *testing.T) (servicebase.TLSConfig, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	tlsCfg := servicebase.TLSConfig{
+		CertFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:  filepath.Join(dir, "key.pem"),
+	}
+	require.NoError(t, os.WriteFile(tlsCfg.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(tlsCfg.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return tlsCfg, roots
+}
+
+func TestHarnessTLS(t *testing.T) {
+	t.Parallel()
+
+	tlsCfg, roots := testCertificate(t)
+
+	cfg := servicebase.DefaultServiceConfig()
+	cfg.HTTP.TLS = tlsCfg
+	cfg.GRPC.TLS = tlsCfg
+
+	h := Start(t, cfg, testFactory, Options{
+		InMemory:        true,
+		TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost", MinVersion: tls.VersionTLS12},
+	})
+
+	assert.True(t, strings.HasPrefix(h.BaseURL, "https://"))
+
+	resp, err := h.HTTPClient.Get(h.BaseURL + "/teapot")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+	assert.Equal(t, 2, resp.ProtoMajor)
+
+	hresp, err := grpc_health_v1.NewHealthClient(h.GRPCConn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, hresp.Status)
+}
+
+func TestHarnessServeGRPC(t *testing.T) {
+	t.Parallel()
+
+	tlsCfg, roots := testCertificate(t)
+
+	for _, tc := range []struct {
+		name  string
+		tls   bool
+		opts  Options
+		proto int
+	}{
+		{name: "Ephemeral", opts: Options{}, proto: 1},
+		{name: "InMemory", opts: Options{InMemory: true}, proto: 1},
+		{
+			name:  "TLS",
+			tls:   true,
+			opts:  Options{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost", MinVersion: tls.VersionTLS12}},
+			proto: 2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := servicebase.DefaultServiceConfig()
+			cfg.HTTP.ServeGRPC = true
+			cfg.GRPC.Enabled = false
+			if tc.tls {
+				cfg.HTTP.TLS = tlsCfg
+			}
+
+			h := Start(t, cfg, testFactory, tc.opts)
+			require.Equal(t, h.HTTPAddr, h.GRPCAddr)
+
+			resp, err := h.HTTPClient.Get(h.BaseURL + "/teapot")
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+			assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+			assert.Equal(t, tc.proto, resp.ProtoMajor)
+
+			hresp, err := grpc_health_v1.NewHealthClient(h.GRPCConn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+			require.NoError(t, err)
+			assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, hresp.Status)
+		})
+	}
+}
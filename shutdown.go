@@ -0,0 +1,107 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/vs49688/servicebase/multilistener"
+)
+
+// Shutdown phases, after those of the MultiListener.
+const (
+	phaseCloseService = "close_service"
+	phaseFlushTraces  = "flush_traces"
+	phaseFlushLogs    = "flush_logs"
+)
+
+// withTimeout is context.WithTimeout(), where a zero timeout never expires.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (sw *serviceBase) listenerShutdownConfig(cfg *ServiceConfig) multilistener.ShutdownConfig {
+	return multilistener.ShutdownConfig{
+		Timeout:       cfg.ShutdownTimeout,
+		HTTPTimeout:   cfg.Shutdown.HTTPTimeout,
+		GRPCTimeout:   cfg.Shutdown.GRPCTimeout,
+		WorkerTimeout: cfg.Shutdown.WorkerTimeout,
+		OnPhase: func(phase multilistener.Phase, took time.Duration, err error) {
+			// Shutdown as a whole begins once we stop accepting.
+			if phase == multilistener.PhaseStopAccepting && cfg.ShutdownTimeout > 0 {
+				sw.shutdownDeadline = time.Now().Add(cfg.ShutdownTimeout - took)
+			}
+
+			sw.recordShutdownPhase(string(phase), took, err)
+		},
+	}
+}
+
+func (sw *serviceBase) recordShutdownPhase(phase string, took time.Duration, err error) {
+	sw.metrics.RecordShutdownPhase(phase, took)
+
+	if err != nil {
+		sw.logger.Warn("shutdown phase did not complete",
+			slog.String("phase", phase),
+			slog.Duration("took", took),
+			slog.Any("error", err),
+		)
+		return
+	}
+
+	sw.logger.Info("shutdown phase complete", slog.String("phase", phase), slog.Duration("took", took))
+}
+
+func (sw *serviceBase) runShutdownPhase(ctx context.Context, phase string, timeout time.Duration, fn func(ctx context.Context) error) {
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	started := time.Now()
+	err := fn(ctx)
+	sw.recordShutdownPhase(phase, time.Since(started), err)
+}
+
+// finishShutdown closes the service and flushes the traces and logs, once the servers
+// and workers have stopped. The phases share what remains of the shutdown timeout.
+func (sw *serviceBase) finishShutdown(cfg *ServiceConfig) {
+	ctx, cancel := context.WithCancel(sw.stopCtx)
+	if !sw.shutdownDeadline.IsZero() {
+		ctx, cancel = context.WithDeadline(sw.stopCtx, sw.shutdownDeadline)
+	}
+	defer cancel()
+
+	sw.runShutdownPhase(ctx, phaseCloseService, cfg.Shutdown.ServiceTimeout, sw.svc.Close)
+
+	if sw.tracing.enabled() {
+		sw.runShutdownPhase(ctx, phaseFlushTraces, 0, sw.tracing.shutdown)
+	}
+
+	if sw.logFlusher != nil {
+		sw.runShutdownPhase(ctx, phaseFlushLogs, cfg.Shutdown.LogTimeout, sw.logFlusher.Flush)
+	}
+}
+
+// forceStop abandons any graceful shutdown in progress.
+func (sw *serviceBase) forceStop() {
+	sw.logger.Warn("forcing stop")
+	sw.stop()
+	sw.multiListener.Stop()
+}
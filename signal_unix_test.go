@@ -0,0 +1,95 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build unix
+
+package servicebase
+
+import (
+	"context"
+	"log/slog"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// messageHandler sends the message of each record.
+type messageHandler struct {
+	messages chan string
+}
+
+func (h *messageHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *messageHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *messageHandler) WithGroup(string) slog.Handler            { return h }
+func (h *messageHandler) Handle(_ context.Context, r slog.Record) error {
+	select {
+	case h.messages <- r.Message:
+	default:
+	}
+	return nil
+}
+
+func waitForMessage(t *testing.T, messages <-chan string, want string) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-messages:
+			if msg == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+}
+
+func TestSignalDuringDrain(t *testing.T) {
+	cfg := DefaultServiceConfig()
+	cfg.HTTP.BindAddress = "127.0.0.1:0"
+	cfg.GRPC.Enabled = false
+	cfg.DrainDelay = time.Hour
+
+	h := &messageHandler{messages: make(chan string, 100)}
+
+	ready := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- RunServiceWithOptions(context.Background(), cfg, func(context.Context, ServiceParameters) (Service, error) {
+			return &upgradeChildService{}, nil
+		}, RunOptions{
+			LogHandler: h,
+			OnReady:    func(ReadyInfo) { close(ready) },
+		})
+	}()
+
+	<-ready
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGTERM))
+	waitForMessage(t, h.messages, "draining")
+
+	// The second signal forces a stop, rather than the drain delay being cut short.
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGTERM))
+	waitForMessage(t, h.messages, "forcing stop")
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("service didn't stop")
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/vs49688/servicebase/internal/systemd"
+)
+
+// notify sends a notification to systemd, if running under it.
+func (sw *serviceBase) notify(state ...string) {
+	if _, err := systemd.Notify(state...); err != nil {
+		sw.logger.Warn("unable to notify systemd", slog.Any("error", err))
+	}
+}
+
+// watchdog pings the systemd watchdog, for as long as the service isn't unhealthy.
+func (sw *serviceBase) watchdog(interval time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		// Ping at twice the rate systemd expects.
+		t := time.NewTicker(interval / 2)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-t.C:
+			}
+
+			hctx, cancel := context.WithTimeout(ctx, interval/2)
+			r, err := sw.getHealth(hctx)
+			cancel()
+
+			if err != nil || r == nil {
+				sw.logger.WarnContext(ctx, "health check failed, skipping watchdog", slog.Any("error", err))
+				continue
+			}
+
+			if r.Status == HealthStatusUnhealthy {
+				sw.logger.WarnContext(ctx, "service unhealthy, skipping watchdog", slog.String("message", r.Message))
+				continue
+			}
+
+			sw.notify(systemd.StateWatchdog)
+		}
+	}
+}
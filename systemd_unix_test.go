@@ -0,0 +1,125 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build unix
+
+package servicebase
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vs49688/servicebase/internal/systemd"
+)
+
+type watchdogService struct {
+	unhealthy atomic.Bool
+}
+
+func (s *watchdogService) GetHealth(context.Context) (*GetHealthResponse, error) {
+	if s.unhealthy.Load() {
+		return &GetHealthResponse{Status: HealthStatusUnhealthy, Message: "broken"}, nil
+	}
+
+	return &GetHealthResponse{Status: HealthStatusHealthy}, nil
+}
+
+func (s *watchdogService) Close(context.Context) error {
+	return nil
+}
+
+// listenNotify listens on a fake systemd notification socket, sending each notification.
+func listenNotify(t *testing.T) <-chan string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	notifications := make(chan string, 100)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+
+			notifications <- string(buf[:n])
+		}
+	}()
+
+	return notifications
+}
+
+func TestSystemdNotify(t *testing.T) {
+	notifications := listenNotify(t)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	cfg := DefaultServiceConfig()
+	cfg.HTTP.BindAddress = "127.0.0.1:0"
+	cfg.GRPC.Enabled = false
+
+	svc := &watchdogService{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- RunServiceWithOptions(ctx, cfg, func(context.Context, ServiceParameters) (Service, error) {
+			return svc, nil
+		}, RunOptions{DisableSignals: true})
+	}()
+
+	waitForMessage(t, notifications, systemd.StateReady+"\n"+systemd.Status("serving"))
+	waitForMessage(t, notifications, systemd.StateWatchdog)
+
+	// Pings stop while unhealthy, one may already have been sent.
+	svc.unhealthy.Store(true)
+	time.Sleep(100 * time.Millisecond)
+	for len(notifications) > 0 {
+		<-notifications
+	}
+
+	select {
+	case n := <-notifications:
+		t.Fatalf("unexpected notification while unhealthy: %q", n)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	svc.unhealthy.Store(false)
+	waitForMessage(t, notifications, systemd.StateWatchdog)
+
+	cancel()
+	waitForMessage(t, notifications, systemd.StateStopping+"\n"+systemd.Status("stopped"))
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("service didn't stop")
+	}
+}
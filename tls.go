@@ -0,0 +1,308 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tlsPollInterval is how often certificates are checked for changes.
+const tlsPollInterval = 10 * time.Second
+
+var (
+	// Both HTTP/2 and GRPC require ALPN.
+	httpNextProtos = []string{"h2", "http/1.1"}
+	grpcNextProtos = []string{"h2"}
+)
+
+var tlsClientAuthTypes = map[string]tls.ClientAuthType{
+	"":                   tls.NoClientCert,
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS12,
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	suites := map[string]uint16{}
+	for _, cs := range tls.CipherSuites() {
+		suites[cs.Name] = cs.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher suite: %q", name)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func (cfg *TLSConfig) validate() error {
+	if !cfg.Enabled() {
+		if cfg.KeyFile != "" || cfg.ClientCAFile != "" {
+			return errors.New("certificate file required")
+		}
+
+		return nil
+	}
+
+	if cfg.KeyFile == "" {
+		return errors.New("key file required")
+	}
+
+	clientAuth, ok := tlsClientAuthTypes[cfg.ClientAuth]
+	if !ok {
+		return fmt.Errorf("invalid client auth: %q", cfg.ClientAuth)
+	}
+
+	if clientAuth >= tls.VerifyClientCertIfGiven && cfg.ClientCAFile == "" {
+		return errors.New("client ca file required to verify client certificates")
+	}
+
+	if _, ok := tlsVersions[cfg.MinVersion]; !ok {
+		return fmt.Errorf("invalid minimum version: %q", cfg.MinVersion)
+	}
+
+	if _, err := parseCipherSuites(cfg.CipherSuites); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// certReloader serves a listener's certificates from disk, reloading them when they change.
+type certReloader struct {
+	cfg        TLSConfig
+	nextProtos []string
+	metrics    *Metrics
+
+	mu       sync.Mutex
+	modTimes map[string]time.Time
+	current  atomic.Pointer[tls.Config]
+}
+
+func newCertReloader(cfg *TLSConfig, nextProtos []string, metrics *Metrics) (*certReloader, error) {
+	// Otherwise, an unknown client auth type would silently disable client verification.
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	r := &certReloader{
+		cfg:        *cfg,
+		nextProtos: nextProtos,
+		metrics:    metrics,
+	}
+
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+
+	return files
+}
+
+func (r *certReloader) stat() (map[string]time.Time, error) {
+	modTimes := map[string]time.Time{}
+	for _, f := range r.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+
+		modTimes[f] = fi.ModTime()
+	}
+
+	return modTimes, nil
+}
+
+// changed reports whether any of the files have changed since they were loaded.
+func (r *certReloader) changed() bool {
+	modTimes, err := r.stat()
+	if err != nil {
+		// Let reload() report it.
+		return true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for f, t := range modTimes {
+		if !t.Equal(r.modTimes[f]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// certificateLeaf returns the certificate's leaf, parsing it if unset, as with
+// GODEBUG=x509keypairleaf=0.
+func certificateLeaf(cert *tls.Certificate) (*x509.Certificate, error) {
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+
+	return x509.ParseCertificate(cert.Certificate[0])
+}
+
+// reload loads the certificates. Upon error, the current certificates are kept.
+func (r *certReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTimes, err := r.stat()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	if cert.Leaf, err = certificateLeaf(&cert); err != nil {
+		return err
+	}
+
+	var clientCAs *x509.CertPool
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return err
+		}
+
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no certificates found", r.cfg.ClientCAFile)
+		}
+	}
+
+	cipherSuites, err := parseCipherSuites(r.cfg.CipherSuites)
+	if err != nil {
+		return err
+	}
+
+	r.current.Store(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tlsClientAuthTypes[r.cfg.ClientAuth],
+		MinVersion:   tlsVersions[r.cfg.MinVersion],
+		CipherSuites: cipherSuites,
+		NextProtos:   r.nextProtos,
+	})
+	r.modTimes = modTimes
+
+	r.metrics.RecordCertificateExpiry(r.cfg.CertFile, cert.Leaf.NotAfter)
+	return nil
+}
+
+// TLSConfig returns a configuration that always uses the current certificates.
+func (r *certReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tlsVersions[r.cfg.MinVersion],
+		NextProtos: r.nextProtos,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current.Load(), nil
+		},
+	}
+}
+
+// newTLSConfig returns the TLS configuration for a listener, or nil if TLS is disabled.
+func (sw *serviceBase) newTLSConfig(cfg *TLSConfig, nextProtos []string) (*tls.Config, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+
+	r, err := newCertReloader(cfg, nextProtos, &sw.metrics)
+	if err != nil {
+		sw.logger.Error("unable to load certificates", slog.Any("error", err))
+		return nil, err
+	}
+
+	sw.certReloaders = append(sw.certReloaders, r)
+	return r.TLSConfig(), nil
+}
+
+// reloadCertificates reloads the certificates of every listener. If force is
+// false, only those that have changed are reloaded.
+func (sw *serviceBase) reloadCertificates(force bool) {
+	for _, r := range sw.certReloaders {
+		if !force && !r.changed() {
+			continue
+		}
+
+		if err := r.reload(); err != nil {
+			sw.logger.Error("unable to reload certificates, keeping current",
+				slog.String("cert_file", r.cfg.CertFile),
+				slog.Any("error", err),
+			)
+			continue
+		}
+
+		sw.logger.Info("reloaded certificates", slog.String("cert_file", r.cfg.CertFile))
+	}
+}
+
+// watchCertificates reloads certificates as they change.
+func (sw *serviceBase) watchCertificates(interval time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-t.C:
+				sw.reloadCertificates(false)
+			}
+		}
+	}
+}
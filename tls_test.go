@@ -0,0 +1,155 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestCertificate writes a self-signed certificate for localhost.
+func writeTestCertificate(t *testing.T, dir string, serial int64, notAfter time.Time) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func TestTLSConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name  string
+		cfg   TLSConfig
+		valid bool
+	}{
+		{name: "Disabled", cfg: TLSConfig{}, valid: true},
+		{name: "Enabled", cfg: TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", MinVersion: "1.3"}, valid: true},
+		{name: "NoKey", cfg: TLSConfig{CertFile: "cert.pem"}},
+		{name: "NoCert", cfg: TLSConfig{KeyFile: "key.pem"}},
+		{name: "ClientAuth", cfg: TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", ClientAuth: "maybe"}},
+		{name: "NoClientCA", cfg: TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", ClientAuth: "require_and_verify"}},
+		{name: "MinVersion", cfg: TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", MinVersion: "2.0"}},
+		{name: "CipherSuite", cfg: TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", CipherSuites: []string{"TLS_NOPE"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.valid {
+				assert.NoError(t, tc.cfg.validate())
+			} else {
+				assert.Error(t, tc.cfg.validate())
+			}
+		})
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	t.Parallel()
+
+	metrics, _, err := configureMetrics(&ServiceConfig{}, slog.Default())
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	expiry := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	certFile, keyFile := writeTestCertificate(t, dir, 1, expiry)
+
+	r, err := newCertReloader(&TLSConfig{CertFile: certFile, KeyFile: keyFile}, httpNextProtos, &metrics)
+	require.NoError(t, err)
+	assert.False(t, r.changed())
+
+	serial := func() int64 {
+		cfg, err := r.TLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+		require.NoError(t, err)
+		return cfg.Certificates[0].Leaf.SerialNumber.Int64()
+	}
+	assert.Equal(t, int64(1), serial())
+
+	writeTestCertificate(t, dir, 2, expiry.Add(time.Hour))
+	require.NoError(t, os.Chtimes(certFile, time.Time{}, time.Now().Add(time.Minute)))
+	assert.True(t, r.changed())
+
+	require.NoError(t, r.reload())
+	assert.Equal(t, int64(2), serial())
+
+	// Invalid settings aren't replaced with the defaults.
+	_, err = newCertReloader(&TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientAuth: "require-and-verify"}, httpNextProtos, &metrics)
+	assert.ErrorContains(t, err, "invalid client auth")
+
+	// A broken certificate is ignored.
+	require.NoError(t, os.WriteFile(certFile, []byte("nope"), 0600))
+	assert.Error(t, r.reload())
+	assert.Equal(t, int64(2), serial())
+
+	families, err := metrics.Registry.Gather()
+	require.NoError(t, err)
+	for _, mf := range families {
+		if mf.GetName() == "tls_certificate_expiry_timestamp_seconds" {
+			require.Len(t, mf.GetMetric(), 1)
+			assert.Equal(t, float64(expiry.Add(time.Hour).Unix()), mf.GetMetric()[0].GetGauge().GetValue())
+		}
+	}
+}
+
+func TestCertificateLeaf(t *testing.T) {
+	t.Parallel()
+
+	expiry := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	certFile, keyFile := writeTestCertificate(t, t.TempDir(), 1, expiry)
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	require.NoError(t, err)
+
+	// As with GODEBUG=x509keypairleaf=0.
+	cert.Leaf = nil
+
+	leaf, err := certificateLeaf(&cert)
+	require.NoError(t, err)
+	assert.True(t, expiry.Equal(leaf.NotAfter))
+}
@@ -0,0 +1,244 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc"
+
+	"github.com/vs49688/servicebase/internal/middleware/tracing"
+)
+
+const (
+	TracingExporterOTLPHTTP = "otlp-http"
+	TracingExporterOTLPGRPC = "otlp-grpc"
+
+	TracingPropagatorTraceContext = "tracecontext"
+	TracingPropagatorBaggage      = "baggage"
+	TracingPropagatorB3           = "b3"
+	TracingPropagatorB3Multi      = "b3multi"
+
+	// The instrumentation scope of our spans
+	tracerName = "github.com/vs49688/servicebase"
+)
+
+// Tracing propagates the trace of a request to those the service makes, with
+// InjectHTTP() or the GRPC clients created with DialOptions(). Those made with
+// OpenTelemetry instrumentation are propagated too, as Tracing is installed globally. If
+// tracing is disabled, Tracer records nothing and Propagator propagates nothing.
+type Tracing struct {
+	Tracer     trace.Tracer
+	Propagator propagation.TextMapPropagator
+
+	provider *sdktrace.TracerProvider // nil if disabled
+}
+
+func (cfg *TracingConfig) validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	switch cfg.Exporter {
+	case TracingExporterOTLPHTTP, TracingExporterOTLPGRPC:
+	default:
+		return fmt.Errorf("invalid exporter: %q", cfg.Exporter)
+	}
+
+	if strings.Contains(cfg.Endpoint, "://") {
+		if _, err := url.Parse(cfg.Endpoint); err != nil {
+			return fmt.Errorf("invalid endpoint: %w", err)
+		}
+	}
+
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return errors.New("sample ratio must be between 0 and 1")
+	}
+
+	_, err := cfg.propagator()
+	return err
+}
+
+func (cfg *TracingConfig) propagator() (propagation.TextMapPropagator, error) {
+	var propagators []propagation.TextMapPropagator
+	for _, p := range cfg.Propagators {
+		switch p {
+		case TracingPropagatorTraceContext:
+			propagators = append(propagators, propagation.TraceContext{})
+		case TracingPropagatorBaggage:
+			propagators = append(propagators, propagation.Baggage{})
+		case TracingPropagatorB3:
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3SingleHeader)))
+		case TracingPropagatorB3Multi:
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)))
+		default:
+			return nil, fmt.Errorf("invalid propagator: %q", p)
+		}
+	}
+
+	return propagation.NewCompositeTextMapPropagator(propagators...), nil
+}
+
+func (cfg *TracingConfig) exporter(ctx context.Context) (sdktrace.SpanExporter, error) {
+	hasURL := strings.Contains(cfg.Endpoint, "://")
+
+	if cfg.Exporter == TracingExporterOTLPGRPC {
+		var opts []otlptracegrpc.Option
+		switch {
+		case hasURL:
+			opts = append(opts, otlptracegrpc.WithEndpointURL(cfg.Endpoint))
+		case cfg.Endpoint != "":
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		}
+
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlptracegrpc.WithHeaders(cfg.Headers))
+		}
+
+		return otlptracegrpc.New(ctx, opts...)
+	}
+
+	var opts []otlptracehttp.Option
+	switch {
+	case hasURL:
+		opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	case cfg.Endpoint != "":
+		opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+	}
+
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+	}
+
+	return otlptracehttp.New(ctx, opts...)
+}
+
+// newTracing creates the tracer provider and installs it globally, if tracing is enabled.
+func newTracing(ctx context.Context, cfg *TracingConfig) (*Tracing, error) {
+	if !cfg.Enabled {
+		return &Tracing{
+			Tracer:     noop.NewTracerProvider().Tracer(tracerName),
+			Propagator: propagation.NewCompositeTextMapPropagator(),
+		}, nil
+	}
+
+	propagator, err := cfg.propagator()
+	if err != nil {
+		return nil, err
+	}
+
+	exporter, err := cfg.exporter(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The environment takes precedence over the program name, but not the configuration.
+	res := resource.Default()
+	if name := cfg.ServiceName; name != "" || os.Getenv("OTEL_SERVICE_NAME") == "" {
+		if name == "" {
+			name = filepath.Base(os.Args[0])
+		}
+
+		res, err = resource.Merge(res, resource.NewSchemaless(semconv.ServiceName(name)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+
+	return &Tracing{
+		Tracer:     provider.Tracer(tracerName),
+		Propagator: propagator,
+		provider:   provider,
+	}, nil
+}
+
+// InjectHTTP adds the trace context to the headers of an outgoing HTTP request.
+func (t *Tracing) InjectHTTP(ctx context.Context, header http.Header) {
+	t.Propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// DialOptions returns the options of a GRPC client propagating the trace of each call's
+// context.
+func (t *Tracing) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(tracing.UnaryClientInterceptor(t.Propagator)),
+		grpc.WithChainStreamInterceptor(tracing.StreamClientInterceptor(t.Propagator)),
+	}
+}
+
+// enabled reports whether requests should be traced.
+func (t *Tracing) enabled() bool {
+	return t.provider != nil
+}
+
+// shutdown exports any remaining spans.
+func (t *Tracing) shutdown(ctx context.Context) error {
+	if t.provider == nil {
+		return nil
+	}
+
+	return t.provider.Shutdown(ctx)
+}
+
+// instrumentHTTP serves each request in a span, named after its route template.
+func (t *Tracing) instrumentHTTP(next http.Handler) http.Handler {
+	traced := tracing.NewHandler(next, t.Tracer, t.Propagator, func(r *http.Request) string {
+		if h, ok := r.Context().Value(routeKey{}).(*routeHolder); ok && h.route != routeUnmatched && h.route != routeUnnamed {
+			return h.route
+		}
+
+		return ""
+	})
+
+	// The route is noted within, so must be available to both the span and the metrics.
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, _ = withRouteHolder(r)
+		traced.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,180 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase_test
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/vs49688/servicebase"
+	"github.com/vs49688/servicebase/servicetest"
+)
+
+func TestTracing(t *testing.T) {
+	t.Parallel()
+
+	const (
+		traceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentID = "00f067aa0ba902b7"
+
+		transcodedTraceID = "5bf92f3577b34da6a3ce929d0e0e4736"
+	)
+
+	var mu sync.Mutex
+	var spans []*tracepb.Span
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+
+		var req coltracepb.ExportTraceServiceRequest
+		if err == nil {
+			err = proto.Unmarshal(b, &req)
+		}
+
+		if !assert.NoError(t, err) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer receiver.Close()
+
+	cfg := servicebase.DefaultServiceConfig()
+	cfg.Tracing.Enabled = true
+	cfg.Tracing.Endpoint = receiver.URL
+	cfg.Tracing.ServiceName = "test"
+	cfg.GRPC.EnableTranscoding = true
+
+	var h *servicetest.Harness
+
+	// Spans are flushed upon shutdown.
+	t.Run("serve", func(t *testing.T) {
+		h = servicetest.Start(t, cfg, func(_ context.Context, params servicebase.ServiceParameters) (servicebase.Service, error) {
+			grpc_health_v1.RegisterHealthServer(params.GRPCRegistrar, health.NewServer())
+
+			// Calls the service's own GRPC server, within a span of its own.
+			params.ApplicationRouter.HandleFunc("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+				conn, err := grpc.NewClient("passthrough:///"+h.GRPCAddr.String(),
+					append(params.Tracing.DialOptions(), grpc.WithTransportCredentials(insecure.NewCredentials()))...,
+				)
+				if !assert.NoError(t, err) {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				defer conn.Close()
+
+				ctx, span := params.Tracing.Tracer.Start(r.Context(), "child")
+				_, err = grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+				span.End()
+				assert.NoError(t, err)
+
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			return &healthService{health: servicebase.HealthStatusHealthy}, nil
+		}, servicetest.Options{})
+
+		req, err := http.NewRequest(http.MethodGet, h.BaseURL+"/items/1", nil)
+		require.NoError(t, err)
+		req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+
+		resp, err := h.HTTPClient.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		req, err = http.NewRequest(http.MethodPost, h.BaseURL+"/rpc/grpc.health.v1.Health/Check", strings.NewReader("{}"))
+		require.NoError(t, err)
+		req.Header.Set("traceparent", "00-"+transcodedTraceID+"-"+parentID+"-01")
+
+		resp, err = h.HTTPClient.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	find := func(traceID, name string) *tracepb.Span {
+		t.Helper()
+
+		for _, span := range spans {
+			if hex.EncodeToString(span.TraceId) == traceID && span.Name == name {
+				return span
+			}
+		}
+
+		require.Fail(t, "span not found", "%s %s", traceID, name)
+		return nil
+	}
+
+	httpSpan := find(traceID, "GET /items/{id}")
+	assert.Equal(t, parentID, hex.EncodeToString(httpSpan.ParentSpanId))
+	assert.Equal(t, tracepb.Span_SPAN_KIND_SERVER, httpSpan.Kind)
+
+	// Propagated as the call is made, so from the child rather than the request.
+	child := find(traceID, "child")
+	assert.Equal(t, httpSpan.SpanId, child.ParentSpanId)
+
+	grpcSpan := find(traceID, "grpc.health.v1.Health/Check")
+	assert.Equal(t, child.SpanId, grpcSpan.ParentSpanId)
+
+	// Bridged calls are children of the HTTP request.
+	transcoded := find(transcodedTraceID, "POST /rpc/grpc.health.v1.Health/Check")
+	assert.Equal(t, parentID, hex.EncodeToString(transcoded.ParentSpanId))
+
+	bridged := find(transcodedTraceID, "grpc.health.v1.Health/Check")
+	assert.Equal(t, transcoded.SpanId, bridged.ParentSpanId)
+
+	// The access log is written within the span.
+	var logged bool
+	for _, r := range h.Logs.Records() {
+		r.Attrs(func(a slog.Attr) bool {
+			if a.Key == "trace_id" && a.Value.String() == traceID {
+				logged = true
+			}
+			return true
+		})
+	}
+	assert.True(t, logged)
+}
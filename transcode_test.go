@@ -0,0 +1,127 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/vs49688/servicebase"
+	"github.com/vs49688/servicebase/servicetest"
+)
+
+func TestTranscoding(t *testing.T) {
+	t.Parallel()
+
+	cfg := servicebase.DefaultServiceConfig()
+	cfg.HTTP.PathPrefix = "/api"
+	cfg.GRPC.EnableTranscoding = true
+
+	h := servicetest.Start(t, cfg, func(_ context.Context, params servicebase.ServiceParameters) (servicebase.Service, error) {
+		grpc_health_v1.RegisterHealthServer(params.GRPCRegistrar, health.NewServer())
+		params.ApplicationRouter.Path("/hello").HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = io.WriteString(w, "hello")
+		})
+		return &healthService{health: servicebase.HealthStatusHealthy}, nil
+	}, servicetest.Options{InMemory: true})
+
+	call := func(t *testing.T, method, path, body string) (*http.Response, map[string]any) {
+		req, err := http.NewRequest(method, h.BaseURL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := h.HTTPClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var out map[string]any
+		if resp.Header.Get("Content-Type") == "application/json" {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&out))
+		}
+
+		return resp, out
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		resp, out := call(t, http.MethodPost, "/api/rpc/grpc.health.v1.Health/Check", `{"service": ""}`)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.NotEmpty(t, resp.Header.Get("X-Request-ID"))
+		assert.Equal(t, map[string]any{"status": "SERVING"}, out)
+	})
+
+	t.Run("EmptyBody", func(t *testing.T) {
+		resp, out := call(t, http.MethodPost, "/api/rpc/grpc.health.v1.Health/Check", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, map[string]any{"status": "SERVING"}, out)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		resp, out := call(t, http.MethodPost, "/api/rpc/grpc.health.v1.Health/Check", `{"service": "missing"}`)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Equal(t, float64(5), out["code"]) // NOT_FOUND
+		assert.Equal(t, "unknown service", out["message"])
+	})
+
+	t.Run("InvalidArgument", func(t *testing.T) {
+		resp, out := call(t, http.MethodPost, "/api/rpc/grpc.health.v1.Health/Check", `{"nope": 1}`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Equal(t, float64(3), out["code"]) // INVALID_ARGUMENT
+	})
+
+	// Streaming methods aren't transcoded, and the service's own routes are untouched.
+	t.Run("Routes", func(t *testing.T) {
+		resp, _ := call(t, http.MethodPost, "/api/rpc/grpc.health.v1.Health/Watch", `{}`)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp, _ = call(t, http.MethodGet, "/api/rpc/grpc.health.v1.Health/Check", "")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp, _ = call(t, http.MethodGet, "/api/hello", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	// Labelled with the binding's template, rather than as an unnamed route.
+	t.Run("Metrics", func(t *testing.T) {
+		families, err := h.Registry.Gather()
+		require.NoError(t, err)
+
+		routes := map[string]bool{}
+		for _, mf := range families {
+			if mf.GetName() != "http_request_duration_seconds" {
+				continue
+			}
+
+			for _, m := range mf.GetMetric() {
+				for _, l := range m.GetLabel() {
+					if l.GetName() == "route" {
+						routes[l.GetValue()] = true
+					}
+				}
+			}
+		}
+
+		assert.True(t, routes["/api/rpc/grpc.health.v1.Health/Check"])
+		assert.False(t, routes["unnamed"])
+	})
+}
@@ -22,8 +22,11 @@ import (
 	"google.golang.org/grpc"
 	"io/fs"
 	"log/slog"
+	"net"
 	"net/http"
 	"strconv"
+	"sync/atomic"
+	"time"
 )
 
 type LogFormat string
@@ -54,6 +57,30 @@ type HealthCheckable interface {
 	GetHealth(ctx context.Context) (*GetHealthResponse, error)
 }
 
+// ReadinessCheckable may be implemented by a Service to control /health/ready.
+// If not implemented, readiness is determined by GetHealth().
+type ReadinessCheckable interface {
+	GetReadiness(ctx context.Context) (*GetHealthResponse, error)
+}
+
+// StartupCheckable may be implemented by a Service to control /health/startup.
+// If not implemented, the service is considered started once it begins serving.
+type StartupCheckable interface {
+	GetStartup(ctx context.Context) (*GetHealthResponse, error)
+}
+
+// Reloadable may be implemented by a Service to receive the new configuration
+// on SIGHUP. Returning an error rejects the new configuration.
+type Reloadable interface {
+	Reload(ctx context.Context, cfg ServiceConfig) error
+}
+
+// LogFlusher may be implemented by a RunOptions.LogHandler that buffers records.
+// It is flushed as the final phase of shutdown.
+type LogFlusher interface {
+	Flush(ctx context.Context) error
+}
+
 type Service interface {
 	HealthCheckable
 
@@ -65,6 +92,7 @@ type ServiceParameters struct {
 	Metrics Metrics
 
 	// ServiceRouter is the top-level HTTP router, without the path prefix applied.
+	// If the admin listener is enabled, this does not serve the built-in endpoints.
 	ServiceRouter *mux.Router
 
 	// ApplicationRouter is the application-level HTTP router, with the path prefix applied.
@@ -72,17 +100,99 @@ type ServiceParameters struct {
 
 	// GRPCRegistrar is the GRPC service registrar.
 	GRPCRegistrar grpc.ServiceRegistrar
+
+	// GRPCHealth serves grpc.health.v1.Health, unless the service registers its own.
+	GRPCHealth *GRPCHealth
+
+	// Workers runs background tasks alongside the servers.
+	Workers *WorkerGroup
+
+	// HealthChecks runs dependency checks in the background, adding them to GetHealth().
+	HealthChecks *HealthChecks
+
+	// Tracing propagates traces to the requests the service makes.
+	Tracing *Tracing
 }
 
 type ServiceFactory func(ctx context.Context, params ServiceParameters) (Service, error)
 
+// ConfigSource supplies configuration on reload. See RunOptions.ConfigSources.
+type ConfigSource func(ctx context.Context) (*ServiceConfig, error)
+
+// ListenFunc creates a listener for the given configuration. See multilistener.Listen().
+type ListenFunc func(cfg *multilistener.ListenConfig, logger *slog.Logger) (net.Listener, error)
+
+// RunOptions controls how RunServiceWithOptions() hosts a service.
+// The zero value behaves identically to RunService().
+type RunOptions struct {
+	// LogHandler, if set, replaces the default stdout log handler.
+	// The log level and format are then left to the handler. See LogFlusher.
+	LogHandler slog.Handler
+
+	// ConfigSources are read at startup and on SIGHUP, and merged in order over the
+	// configuration the service is run with, using MergeServiceConfig().
+	ConfigSources []ConfigSource
+
+	// Listen, if set, replaces multilistener.Listen() for creating listeners.
+	Listen ListenFunc
+
+	// DisableSignals disables SIGINT/SIGTERM handling. The service may then
+	// only be stopped by cancelling its context.
+	DisableSignals bool
+
+	// OnReady, if set, is called once all listeners are bound and serving has begun.
+	OnReady func(info ReadyInfo)
+}
+
+// ReadyInfo describes a service that is about to start serving.
+type ReadyInfo struct {
+	Service    Service
+	Metrics    Metrics
+	HTTPAddrs  []net.Addr
+	GRPCAddrs  []net.Addr
+	AdminAddrs []net.Addr
+}
+
 type serviceBase struct {
 	logger            *slog.Logger
 	multiListener     *multilistener.MultiListener
 	metrics           Metrics
+	tracing           *Tracing
 	serviceRouter     *mux.Router
 	applicationRouter *mux.Router
-	httpServer        *http.Server
+	adminRouter       *mux.Router
+	httpHandler       http.Handler
+	adminHandler      http.Handler
+	httpServers       []*http.Server
+	retiredServers    []*http.Server // Replaced by a reload, possibly still draining
+	httpListeners     []ListenerInfo
+	grpcListeners     grpcListeners
 	grpcServer        *grpc.Server
+	grpcBridgeServer  *grpc.Server // nil unless GRPC-Web or transcoding is enabled
+	grpcHealth        *GRPCHealth
+	healthChecks      *HealthChecks
+	healthHistory     *healthHistory
+	certReloaders     []*certReloader
 	svc               Service
+
+	cfg           ServiceConfig
+	configSources []ConfigSource
+	baseCfg       ServiceConfig // As run with, before the config sources
+	logLevel      slog.LevelVar
+	logSwap       *swapHandler
+	accessLog     atomic.Bool
+	crashOnPanic  atomic.Bool
+	logFlusher    LogFlusher
+
+	// Overrides defaultHealthStatusCodes
+	healthStatusCodes atomic.Pointer[map[HealthStatus]int]
+
+	started  atomic.Bool
+	draining atomic.Bool
+
+	// Cancelled to force an immediate stop
+	stopCtx context.Context
+	stop    context.CancelFunc
+
+	shutdownDeadline time.Time
 }
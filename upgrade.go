@@ -0,0 +1,57 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+// envUpgradeFD is the descriptor a child writes to once ready, during an upgrade.
+const envUpgradeFD = "SERVICEBASE_UPGRADE_FD"
+
+type upgradeResult struct {
+	pid int
+	err error
+}
+
+// notifyParent tells our parent we're ready, if we were started by an upgrade.
+func (sw *serviceBase) notifyParent() {
+	val := os.Getenv(envUpgradeFD)
+	if val == "" {
+		return
+	}
+
+	_ = os.Unsetenv(envUpgradeFD)
+
+	fd, err := strconv.Atoi(val)
+	if err != nil {
+		sw.logger.Error("invalid upgrade descriptor", slog.String("fd", val))
+		return
+	}
+
+	f := os.NewFile(uintptr(fd), "upgrade")
+	defer func() { _ = f.Close() }()
+
+	if _, err := f.Write([]byte(upgradeReady)); err != nil {
+		sw.logger.Error("unable to notify parent", slog.Any("error", err))
+		return
+	}
+
+	sw.logger.Info("notified parent of readiness")
+}
+
+const upgradeReady = "READY=1\n"
@@ -0,0 +1,134 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build unix
+
+package servicebase
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/vs49688/servicebase/multilistener"
+)
+
+var upgradeSignals = map[string]os.Signal{
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+}
+
+// startUpgrade re-executes our binary, passing it our listening sockets. The
+// result is delivered once the child is ready, has failed, or has timed out. A zero
+// timeout waits forever.
+func (sw *serviceBase) startUpgrade(timeout time.Duration) (<-chan upgradeResult, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	files, names, err := sw.multiListener.Files()
+	if err != nil {
+		return nil, err
+	}
+	defer multilistener.CloseFiles(files)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = w.Close() }()
+
+	// Our sockets start at 3, the readiness pipe follows them.
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, w)
+	cmd.Env = append(childEnv(),
+		multilistener.EnvListenFDs+"="+strconv.Itoa(len(files)),
+		multilistener.EnvListenFDNames+"="+strings.Join(names, ":"),
+		envUpgradeFD+"="+strconv.Itoa(3+len(files)),
+	)
+
+	if err := cmd.Start(); err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+
+	sw.logger.Info("started upgrade", slog.String("executable", exe), slog.Int("pid", cmd.Process.Pid))
+
+	ch := make(chan upgradeResult, 1)
+	go func() {
+		defer func() { _ = r.Close() }()
+
+		readyChan := make(chan error, 1)
+		go func() {
+			buf := make([]byte, len(upgradeReady))
+			_, err := io.ReadFull(r, buf)
+			if err == nil && string(buf) != upgradeReady {
+				err = fmt.Errorf("unexpected message from child: %q", buf)
+			} else if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				err = errors.New("child exited before becoming ready")
+			}
+
+			readyChan <- err
+		}()
+
+		// Zero waits forever.
+		var timeoutChan <-chan time.Time
+		if timeout > 0 {
+			timeoutChan = time.After(timeout)
+		}
+
+		var err error
+		select {
+		case err = <-readyChan:
+		case <-timeoutChan:
+			err = fmt.Errorf("child not ready after %v", timeout)
+		}
+
+		if err != nil {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+		}
+
+		ch <- upgradeResult{pid: cmd.Process.Pid, err: err}
+	}()
+
+	return ch, nil
+}
+
+// childEnv is our environment, minus anything tied to our pid.
+func childEnv() []string {
+	env := os.Environ()
+	out := env[:0]
+	for _, kv := range env {
+		// The child becomes the main process, it needs to ping the watchdog.
+		if strings.HasPrefix(kv, "WATCHDOG_PID=") {
+			continue
+		}
+
+		out = append(out, kv)
+	}
+
+	return out
+}
@@ -0,0 +1,109 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build unix
+
+package servicebase
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vs49688/servicebase/multilistener"
+)
+
+const envUpgradeChild = "SERVICEBASE_TEST_UPGRADE_CHILD"
+
+func TestMain(m *testing.M) {
+	// When re-executed by TestUpgrade, become the upgraded service.
+	if os.Getenv(envUpgradeChild) != "" {
+		runUpgradeChild()
+		return
+	}
+
+	os.Exit(m.Run())
+}
+
+type upgradeChildService struct{}
+
+func (s *upgradeChildService) GetHealth(context.Context) (*GetHealthResponse, error) {
+	return &GetHealthResponse{Status: HealthStatusHealthy}, nil
+}
+
+func (s *upgradeChildService) Close(context.Context) error {
+	return nil
+}
+
+func runUpgradeChild() {
+	cfg := DefaultServiceConfig()
+	cfg.HTTP.BindAddress = "127.0.0.1:1" // Unusable, must be inherited
+	cfg.GRPC.Enabled = false
+
+	err := RunService(context.Background(), cfg, func(_ context.Context, params ServiceParameters) (Service, error) {
+		params.ApplicationRouter.HandleFunc("/pid", func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(strconv.Itoa(os.Getpid())))
+		})
+		return &upgradeChildService{}, nil
+	})
+	if err != nil {
+		os.Exit(1)
+	}
+
+	os.Exit(0)
+}
+
+func TestUpgrade(t *testing.T) {
+	t.Setenv(envUpgradeChild, "1")
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	sw := &serviceBase{
+		logger:        logger,
+		multiListener: multilistener.New(logger),
+	}
+	sw.multiListener.AddHTTP("http", lis, &http.Server{})
+	defer sw.multiListener.Close()
+
+	ch, err := sw.startUpgrade(30 * time.Second)
+	require.NoError(t, err)
+
+	res := <-ch
+	require.NoError(t, res.err)
+	defer func() {
+		_ = syscall.Kill(res.pid, syscall.SIGTERM)
+	}()
+
+	// We're not serving, so the child must be answering on our socket.
+	resp, err := http.Get("http://" + lis.Addr().String() + "/pid")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(res.pid), string(body))
+}
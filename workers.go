@@ -0,0 +1,55 @@
+// Copyright 2023 Zane van Iperen
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicebase
+
+import (
+	"context"
+	"time"
+
+	"github.com/vs49688/servicebase/multilistener"
+)
+
+// WorkerGroup runs background workers, such as queue consumers, alongside the servers.
+// Workers are started with the servers and cancelled upon shutdown. Workers must be
+// added before the ServiceFactory returns.
+type WorkerGroup struct {
+	multiListener *multilistener.MultiListener
+	cfg           multilistener.WorkerConfig
+}
+
+func newWorkerGroup(ml *multilistener.MultiListener, cfg *WorkerConfig, stopTimeout time.Duration) *WorkerGroup {
+	return &WorkerGroup{
+		multiListener: ml,
+		cfg: multilistener.WorkerConfig{
+			Restart:     cfg.RestartOnFailure,
+			MinBackoff:  cfg.MinBackoff,
+			MaxBackoff:  cfg.MaxBackoff,
+			StopTimeout: stopTimeout,
+		},
+	}
+}
+
+// Go adds a worker using the service's worker configuration. Should the worker fail,
+// the entire service is stopped, unless restarts are enabled.
+func (g *WorkerGroup) Go(name string, fn func(ctx context.Context) error) {
+	g.multiListener.AddWorker(name, fn, g.cfg)
+}
+
+// GoWithRestart adds a worker that is always restarted upon failure.
+func (g *WorkerGroup) GoWithRestart(name string, fn func(ctx context.Context) error) {
+	cfg := g.cfg
+	cfg.Restart = true
+	g.multiListener.AddWorker(name, fn, cfg)
+}